@@ -0,0 +1,194 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/config"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/oidc"
+	"github.com/Modul-306/backend/password"
+	"github.com/Modul-306/backend/token"
+	"github.com/Modul-306/backend/webauthn"
+)
+
+// App owns the dependencies shared by every handler. Handlers must reach
+// their collaborators through it instead of through package-level state.
+type App struct {
+	// Pool is the connection pool backing Queries. It may be nil when
+	// Queries is replaced, e.g. in tests.
+	Pool      *db.Pool
+	Queries   db.Querier
+	Config    config.Config
+	Clock     func() time.Time
+	Logger    *slog.Logger
+	Tokens    *token.Signer
+	Mailer    mail.Mailer
+	Passwords *password.Service
+	// OIDC holds the OpenID Connect providers users can sign in with, by
+	// name.
+	OIDC map[string]*oidc.Provider
+	// WebAuthn runs the passkey ceremonies.
+	WebAuthn *webauthn.RelyingParty
+}
+
+// New wires an App around pool using the system clock. It fails if the
+// signing keys cannot be loaded or none of them may sign right now.
+func New(pool *db.Pool, cfg config.Config, logger *slog.Logger) (*App, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	keys, err := signingKeys(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keys.SigningKey(time.Now()); err != nil {
+		return nil, err
+	}
+
+	passwords, err := newPasswords(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &App{
+		Pool:      pool,
+		Queries:   db.New(pool),
+		Config:    cfg,
+		Clock:     time.Now,
+		Logger:    logger,
+		Mailer:    newMailer(cfg.Mail),
+		Passwords: passwords,
+	}
+	a.Tokens = token.NewSigner(keys, cfg.Auth.Issuer, cfg.Auth.Audience, a.now)
+
+	a.OIDC = map[string]*oidc.Provider{}
+	for _, p := range cfg.Auth.OIDCProviders {
+		a.OIDC[p.Name] = NewOIDCProvider(cfg.Server, p, a.now)
+	}
+
+	rpID, origins := cfg.WebAuthnRelyingParty()
+	a.WebAuthn = webauthn.New(webauthn.Config{
+		RPID:    rpID,
+		RPName:  cfg.Auth.WebAuthnRPName,
+		Origins: origins,
+		Timeout: cfg.Auth.WebAuthnTimeout,
+	})
+
+	return a, nil
+}
+
+// signingKeys builds the key ring from JWT_KEY and the configured signing keys.
+func signingKeys(cfg config.AuthConfig) (*token.KeyRing, error) {
+	var keys []token.Key
+	if cfg.JWTKey != "" {
+		keys = append(keys, token.Key{
+			ID:        config.JWTKeyID,
+			Algorithm: token.HS256,
+			Secret:    []byte(cfg.JWTKey),
+		})
+	}
+
+	for _, k := range cfg.SigningKeys {
+		key := token.Key{
+			ID:        k.ID,
+			Algorithm: k.Algorithm,
+			NotBefore: k.NotBefore,
+			NotAfter:  k.NotAfter,
+		}
+
+		if k.Algorithm == token.HS256 {
+			key.Secret = []byte(k.Secret)
+		} else {
+			data, err := os.ReadFile(k.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %q: %w", k.ID, err)
+			}
+			key.Private, err = token.ParsePrivateKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %q: %w", k.ID, err)
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return token.NewKeyRing(keys...)
+}
+
+// oidcTimeout bounds each request to an OpenID Connect provider.
+const oidcTimeout = 10 * time.Second
+
+// NewOIDCProvider returns the provider described by p. Users are sent back
+// to the frontend at /oidc/<name>/callback, which passes the code on to
+// the API.
+func NewOIDCProvider(server config.ServerConfig, p config.OIDCProviderConfig, clock func() time.Time) *oidc.Provider {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	return oidc.NewProvider(oidc.Config{
+		Issuer:       p.Issuer,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  strings.TrimSuffix(server.FrontendURL, "/") + "/oidc/" + p.Name + "/callback",
+		Scopes:       scopes,
+	}, &http.Client{Timeout: oidcTimeout}, clock)
+}
+
+// newMailer returns the mailer selected by cfg.Driver.
+func newMailer(cfg config.MailConfig) mail.Mailer {
+	if cfg.Driver == "smtp" {
+		return &mail.SMTPMailer{
+			Addr:     net.JoinHostPort(cfg.SMTP.Host, strconv.Itoa(cfg.SMTP.Port)),
+			From:     cfg.From,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+		}
+	}
+	return &mail.FileMailer{Dir: cfg.Dir, From: cfg.From}
+}
+
+// newPasswords builds the password service configured by cfg, reading the
+// extra blocklist file if one is set.
+func newPasswords(cfg config.AuthConfig) (*password.Service, error) {
+	blocklist := password.DefaultBlocklist()
+	if cfg.PasswordBlocklist != "" {
+		f, err := os.Open(cfg.PasswordBlocklist)
+		if err != nil {
+			return nil, fmt.Errorf("password blocklist: %w", err)
+		}
+		defer f.Close()
+		if err := blocklist.Read(f); err != nil {
+			return nil, fmt.Errorf("password blocklist: %w", err)
+		}
+	}
+
+	return &password.Service{
+		Algorithm:  cfg.PasswordHash,
+		BcryptCost: cfg.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      uint32(cfg.Argon2Memory),
+			Iterations:  uint32(cfg.Argon2Iterations),
+			Parallelism: uint8(cfg.Argon2Parallelism),
+		},
+		MinLength: cfg.PasswordMinLength,
+		MaxLength: cfg.PasswordMaxLength,
+		Blocklist: blocklist,
+
+		AllowPlaintext: cfg.AllowLegacyPlaintext,
+	}, nil
+}
+
+// now defers to Clock so that tests can swap it after New.
+func (a *App) now() time.Time {
+	return a.Clock()
+}
@@ -0,0 +1,56 @@
+// Package audit records security relevant events in the audit_log table.
+package audit
+
+import (
+	"context"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Actions recorded in the audit log.
+const (
+	AccountLocked   = "account_locked"
+	AccountUnlocked = "account_unlocked"
+	IPLocked        = "ip_locked"
+	// SessionsRevoked is recorded when an admin signs a user out
+	// everywhere.
+	SessionsRevoked = "sessions_revoked"
+	// PasskeyCloneSuspected is recorded when a passkey's signature counter
+	// goes backwards, which happens when two copies of it are in use.
+	PasskeyCloneSuspected = "passkey_clone_suspected"
+	// ImpersonationStarted is recorded when an admin obtains a token to act
+	// as a user, ImpersonatedRequest for every request made with it.
+	ImpersonationStarted = "impersonation_started"
+	ImpersonatedRequest  = "impersonated_request"
+)
+
+type Entry struct {
+	Action string
+	// ActorID is the user who acted, or 0 for events without one.
+	ActorID int32
+	// Target is what the action applies to, e.g. "user:alice".
+	Target string
+	IP     string
+	// Detail describes the event further, e.g. the request made.
+	Detail string
+}
+
+// Record writes e to the audit log. Failures are logged rather than
+// returned, as they must not fail the request that caused the entry.
+func Record(ctx context.Context, a *app.App, e Entry) {
+	err := a.Queries.CreateAuditEntry(ctx, db.CreateAuditEntryParams{
+		Action:    e.Action,
+		ActorID:   pgtype.Int4{Int32: e.ActorID, Valid: e.ActorID != 0},
+		Target:    e.Target,
+		Ip:        e.IP,
+		Detail:    e.Detail,
+		CreatedAt: pgtype.Timestamp{Time: a.Clock().UTC(), Valid: true},
+	})
+	if err != nil {
+		a.Logger.Error("failed to write audit entry", "action", e.Action, "target", e.Target, "error", err)
+		return
+	}
+	a.Logger.Info("audit", "action", e.Action, "actor_id", e.ActorID, "target", e.Target, "ip", e.IP, "detail", e.Detail)
+}
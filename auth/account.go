@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/password"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+)
+
+// sessionUser loads the caller of a self-service account endpoint. Like
+// second factors, the password, email and the account itself can only be
+// changed from a session.
+func sessionUser(w http.ResponseWriter, r *http.Request, a *app.App) (Principal, db.User, bool) {
+	p, ok := sessionPrincipal(w, r)
+	if !ok {
+		return Principal{}, db.User{}, false
+	}
+
+	user, err := a.Queries.GetUser(r.Context(), p.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return Principal{}, db.User{}, false
+		}
+		a.Logger.Error("failed to load user", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return Principal{}, db.User{}, false
+	}
+	return p, user, true
+}
+
+// CodeReauthenticationRequired is the problem code answered to users
+// without a password whose sign-in is too old to confirm a change.
+const CodeReauthenticationRequired = "reauthentication_required"
+
+// confirmIdentity checks that the caller p is user and answers the request
+// if not. Users with a password confirm it; see confirmPassword. Users who
+// only sign in through a provider, a sign-in link or a passkey have none,
+// and must have signed in within RecentLoginWindow instead.
+func confirmIdentity(w http.ResponseWriter, r *http.Request, a *app.App, p Principal, user db.User, password string) bool {
+	if user.Password != "" {
+		if password == "" {
+			problem.Error(w, "Current password is required", http.StatusBadRequest)
+			return false
+		}
+		return confirmPassword(w, r, a, user, password)
+	}
+
+	session, err := a.Queries.GetSession(r.Context(), p.SessionID)
+	if err != nil {
+		a.Logger.Error("failed to load session", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return false
+	}
+	if a.Clock().Sub(session.CreatedAt.Time) > a.Config.Auth.RecentLoginWindow {
+		problem.Write(w, problem.New(http.StatusForbidden, CodeReauthenticationRequired, "Sign in again to confirm this change"))
+		return false
+	}
+	return true
+}
+
+// confirmPassword checks that password is the current password of user and
+// answers the request if it is not. Wrong guesses count as failed logins,
+// so that a stolen session cannot be used to find out the password.
+func confirmPassword(w http.ResponseWriter, r *http.Request, a *app.App, user db.User, password string) bool {
+	ip := ClientIP(r, a.Config.Server.TrustProxyHeaders)
+	keys := []loginKey{userLoginKey(a, user.Name), ipLoginKey(a, ip)}
+	wait, err := loginRetryAfter(r.Context(), a, keys...)
+	if err != nil {
+		a.Logger.Error("failed to check login throttling", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		tooManyAttempts(w, wait)
+		return false
+	}
+
+	if ok, _ := a.Passwords.Verify(password, user.Password); !ok {
+		recordLoginFailure(r.Context(), a, ip, keys...)
+		problem.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ChangePassword returns the handler that sets a new password for the
+// signed-in user. Every other session of the user is revoked afterwards.
+func ChangePassword(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, user, ok := sessionUser(w, r, a)
+		if !ok {
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if !confirmIdentity(w, r, a, p, user, req.CurrentPassword) {
+			return
+		}
+		if err := a.Passwords.Check(req.NewPassword); err != nil {
+			problem.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hashedPassword, err := a.Passwords.Hash(req.NewPassword)
+		if err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		err = a.Queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+			ID:       user.ID,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			a.Logger.Error("failed to update password", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		err = a.Queries.RevokeOtherUserSessions(r.Context(), db.RevokeOtherUserSessionsParams{
+			UserID:    user.ID,
+			ID:        p.SessionID,
+			RevokedAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			a.Logger.Error("failed to revoke sessions", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if err := a.Queries.DeleteUserPasswordResetTokens(r.Context(), user.ID); err != nil {
+			a.Logger.Error("failed to delete password reset tokens", "error", err)
+		}
+
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: "Your password was changed",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"The password of your account was just changed and your other sessions were signed out.\n\n"+
+				"If this was not you, reset your password right away.\n",
+				user.Name),
+		}
+		inBackground(a, "password change notice", func(ctx context.Context) error {
+			return a.Mailer.Send(ctx, msg)
+		})
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ChangeEmail returns the handler that starts changing the address of the
+// signed-in user. The new address only takes effect once confirmed with
+// the link mailed to it; the old address is told about the change.
+func ChangeEmail(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, user, ok := sessionUser(w, r, a)
+		if !ok {
+			return
+		}
+
+		var req ChangeEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		email := NormalizeEmail(req.Email)
+		if email == "" {
+			problem.Error(w, "Email is required", http.StatusBadRequest)
+			return
+		}
+		if !confirmIdentity(w, r, a, p, user, req.CurrentPassword) {
+			return
+		}
+		if email == user.Email {
+			problem.Error(w, "Email is unchanged", http.StatusBadRequest)
+			return
+		}
+
+		_, err := a.Queries.GetUserByEmail(r.Context(), email)
+		if err == nil {
+			conflict(w, CodeEmailTaken, "Email is already in use")
+			return
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			a.Logger.Error("failed to look up email", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		secret, err := newOpaqueToken()
+		if err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		now := a.Clock()
+		err = a.Queries.CreateEmailChange(r.Context(), db.CreateEmailChangeParams{
+			TokenHash: hashToken(secret),
+			UserID:    user.ID,
+			NewEmail:  email,
+			ExpiresAt: timestamp(now.Add(a.Config.Auth.EmailVerificationTTL)),
+			CreatedAt: timestamp(now),
+		})
+		if err != nil {
+			a.Logger.Error("failed to create email change", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		confirmation := mail.Message{
+			To:      email,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"Open the link below to use this address for your account. It expires in %s.\n\n"+
+				"%s\n\n"+
+				"If you did not ask for this, you can ignore this mail.\n",
+				user.Name, a.Config.Auth.EmailVerificationTTL, frontendLink(a, "/confirm-email-change", secret)),
+		}
+		notice := mail.Message{
+			To:      user.Email,
+			Subject: "Your email address is being changed",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"Someone signed in to your account asked to change its email address to %s.\n\n"+
+				"If this was not you, change your password right away. The address is only changed once the new one is confirmed.\n",
+				user.Name, email),
+		}
+		inBackground(a, "email change confirmation", func(ctx context.Context) error {
+			return errors.Join(a.Mailer.Send(ctx, confirmation), a.Mailer.Send(ctx, notice))
+		})
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ConfirmEmailChange returns the handler that switches a user to the
+// address confirmed by a token from ChangeEmail. The address counts as
+// verified, as the token proves it can receive mail.
+func ConfirmEmailChange(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		change, err := a.Queries.UseEmailChange(r.Context(), db.UseEmailChangeParams{
+			TokenHash: hashToken(req.Token),
+			ExpiresAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Invalid or expired email change token", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to use email change token", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		// The address may have been taken since the change was asked for.
+		_, err = a.Queries.ChangeUserEmail(r.Context(), db.ChangeUserEmailParams{
+			ID:    change.UserID,
+			Email: change.NewEmail,
+		})
+		if UserConflict(w, err) {
+			return
+		}
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Invalid or expired email change token", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to change email", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		// Links sent to the old address or for other changes are of no
+		// further use.
+		if err := a.Queries.DeleteUserEmailChanges(r.Context(), change.UserID); err != nil {
+			a.Logger.Error("failed to delete email changes", "error", err)
+		}
+		if err := a.Queries.DeleteUserEmailVerificationTokens(r.Context(), change.UserID); err != nil {
+			a.Logger.Error("failed to delete verification tokens", "error", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteAccount returns the handler that deletes the account of the
+// signed-in user after AccountDeletionGrace. The user is signed out
+// everywhere; signing in again before the grace period ends restores the
+// account, see startSession.
+func DeleteAccount(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, user, ok := sessionUser(w, r, a)
+		if !ok {
+			return
+		}
+
+		var req DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if !confirmIdentity(w, r, a, p, user, req.Password) {
+			return
+		}
+
+		now := a.Clock()
+		deleteAt := now.Add(a.Config.Auth.AccountDeletionGrace)
+		err := a.Queries.ScheduleUserDeletion(r.Context(), db.ScheduleUserDeletionParams{
+			ID:                  user.ID,
+			DeletionScheduledAt: timestamp(deleteAt),
+		})
+		if err != nil {
+			a.Logger.Error("failed to schedule account deletion", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		err = a.Queries.RevokeUserSessions(r.Context(), db.RevokeUserSessionsParams{
+			UserID:    user.ID,
+			RevokedAt: timestamp(now),
+		})
+		if err != nil {
+			a.Logger.Error("failed to revoke sessions", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if err := a.Queries.DeleteUserPersonalAccessTokens(r.Context(), user.ID); err != nil {
+			a.Logger.Error("failed to delete personal access tokens", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"Your account will be deleted on %s.\n\n"+
+				"If you change your mind, sign in before then to keep it.\n",
+				user.Name, deleteAt.UTC().Format("2006-01-02 15:04 MST")),
+		}
+		inBackground(a, "account deletion notice", func(ctx context.Context) error {
+			return a.Mailer.Send(ctx, msg)
+		})
+
+		clearSessionCookies(w, a)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(AccountDeletionResponse{DeletionScheduledAt: deleteAt.UTC()})
+	}
+}
+
+// PurgeDeletedAccounts deletes the accounts whose grace period is over,
+// together with everything they own. It returns how many were deleted.
+func PurgeDeletedAccounts(ctx context.Context, a *app.App) (int64, error) {
+	return a.Queries.PurgeDeletedUsers(ctx, timestamp(a.Clock()))
+}
+
+// HashPlaintextPasswords replaces the passwords that earlier versions
+// stored in plaintext by hashes made with the configured algorithm. It
+// returns how many were replaced.
+func HashPlaintextPasswords(ctx context.Context, a *app.App) (int, error) {
+	users, err := a.Queries.GetUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	hashed := 0
+	for _, user := range users {
+		if user.Password == "" || password.IsHash(user.Password) {
+			continue
+		}
+
+		hash, err := a.Passwords.Hash(user.Password)
+		if err != nil {
+			return hashed, err
+		}
+		err = a.Queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+			ID:       user.ID,
+			Password: hash,
+		})
+		if err != nil {
+			return hashed, fmt.Errorf("user %d: %w", user.ID, err)
+		}
+		hashed++
+	}
+	return hashed, nil
+}
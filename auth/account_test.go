@@ -0,0 +1,281 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var emailChangeLink = regexp.MustCompile(`/confirm-email-change\?token=([A-Za-z0-9_-]+)`)
+
+func TestAccountSelfService(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("old password")
+	for _, u := range [][2]string{{"testuser", "test@example.com"}, {"other", "other@example.com"}} {
+		_, err = conn.Exec(context.Background(), `
+            INSERT INTO users (name, password, email, email_verified)
+            VALUES ($1, $2, $3, TRUE)
+        `, u[0], hashedPassword, u[1])
+		if err != nil {
+			t.Fatalf("failed to create test user: %v", err)
+		}
+	}
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO blogs (title, content, user_id) VALUES ('Mine', 'Content', 1)
+    `)
+	if err != nil {
+		t.Fatalf("failed to create test blog: %v", err)
+	}
+
+	do := func(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		return do("POST", "/api/v1/auth/login", auth.Credentials{Username: "testuser", Password: password})
+	}
+	waitForMail := func(sent int) []string {
+		assert.Eventually(t, func() bool {
+			return len(testhelpers.SentMail(t, a)) >= sent
+		}, 5*time.Second, 10*time.Millisecond)
+		return testhelpers.SentMail(t, a)
+	}
+
+	session := cookiesByName(login("old password"))
+	other := cookiesByName(login("old password"))
+
+	// The profile never shows password material
+	rec := do("GET", "/api/v1/me", nil, session["token"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "password")
+	assert.NotContains(t, rec.Body.String(), "$2a$")
+	var me handlers.UserResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Equal(t, 1, me.ID)
+	assert.Equal(t, "testuser", me.Name)
+	assert.True(t, me.EmailVerified)
+	assert.Nil(t, me.DeletionScheduledAt)
+
+	rec = do("UPDATE", "/api/v1/me", handlers.MeRequest{Name: "renamed"}, session["token"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Equal(t, "renamed", me.Name)
+	assert.Equal(t, "test@example.com", me.Email, "the email is not changed")
+	rec = do("UPDATE", "/api/v1/me", handlers.MeRequest{Name: "OTHER"}, session["token"])
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = do("UPDATE", "/api/v1/me", handlers.MeRequest{Name: "testuser"}, session["token"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Changing the password needs the current one
+	rec = do("POST", "/api/v1/me/password", auth.ChangePasswordRequest{CurrentPassword: "wrong password", NewPassword: "a brand new password"}, session["token"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do("POST", "/api/v1/me/password", auth.ChangePasswordRequest{CurrentPassword: "old password", NewPassword: "short"}, session["token"])
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do("POST", "/api/v1/me/password", auth.ChangePasswordRequest{CurrentPassword: "old password", NewPassword: "a brand new password"}, session["token"])
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Len(t, waitForMail(1), 1, "the owner is told about the change")
+
+	assert.Equal(t, http.StatusUnauthorized, login("old password").Code)
+	assert.Equal(t, http.StatusOK, login("a brand new password").Code)
+
+	// The changing session stays signed in, the others are revoked
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/me", nil, session["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", nil, other["token"]).Code)
+
+	// Personal access tokens can read the profile but not change the account
+	rec = do("POST", "/api/v1/tokens", handlers.PersonalAccessTokenRequest{Name: "script", Scopes: []string{"orders:read"}}, session["token"])
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var pat handlers.PersonalAccessTokenResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&pat))
+	withPAT := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		req.Header.Set("Authorization", "Bearer "+pat.Token)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, withPAT("GET", "/api/v1/me", nil).Code)
+	rec = withPAT("POST", "/api/v1/me/password", auth.ChangePasswordRequest{CurrentPassword: "a brand new password", NewPassword: "stolen token password"})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Email changes need the current password and a taken address is refused
+	rec = do("POST", "/api/v1/me/email", auth.ChangeEmailRequest{Email: "new@example.com", CurrentPassword: "old password"}, session["token"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do("POST", "/api/v1/me/email", auth.ChangeEmailRequest{Email: "Other@Example.com", CurrentPassword: "a brand new password"}, session["token"])
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	sent := len(testhelpers.SentMail(t, a))
+	rec = do("POST", "/api/v1/me/email", auth.ChangeEmailRequest{Email: "New@Example.com", CurrentPassword: "a brand new password"}, session["token"])
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	// The new address gets the link, the old one a notice
+	messages := waitForMail(sent + 2)
+	var secret string
+	for _, m := range messages[sent:] {
+		if match := emailChangeLink.FindStringSubmatch(m); match != nil {
+			assert.Contains(t, m, "new@example.com")
+			secret = match[1]
+		} else {
+			assert.Contains(t, m, "test@example.com")
+		}
+	}
+	if secret == "" {
+		t.Fatalf("no email change link in mail: %v", messages[sent:])
+	}
+
+	// Nothing changes before the new address is confirmed
+	rec = do("GET", "/api/v1/me", nil, session["token"])
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Equal(t, "test@example.com", me.Email)
+
+	rec = do("POST", "/api/v1/auth/confirm-email-change", auth.VerifyEmailRequest{Token: "wrong"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do("POST", "/api/v1/auth/confirm-email-change", auth.VerifyEmailRequest{Token: secret})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = do("POST", "/api/v1/auth/confirm-email-change", auth.VerifyEmailRequest{Token: secret})
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "tokens are single use")
+
+	rec = do("GET", "/api/v1/me", nil, session["token"])
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Equal(t, "new@example.com", me.Email)
+	assert.True(t, me.EmailVerified)
+
+	// Deleting the account signs out everywhere and can be undone by
+	// signing in within the grace period
+	rec = withPAT("DELETE", "/api/v1/me", auth.DeleteAccountRequest{Password: "a brand new password"})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do("DELETE", "/api/v1/me", auth.DeleteAccountRequest{Password: "wrong password"}, session["token"])
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do("DELETE", "/api/v1/me", auth.DeleteAccountRequest{Password: "a brand new password"}, session["token"])
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	var deletion auth.AccountDeletionResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&deletion))
+	assert.WithinDuration(t, time.Now().Add(a.Config.Auth.AccountDeletionGrace), deletion.DeletionScheduledAt, time.Minute)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", nil, session["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, withPAT("GET", "/api/v1/me", nil).Code)
+
+	session = cookiesByName(login("a brand new password"))
+	rec = do("GET", "/api/v1/me", nil, session["token"])
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Nil(t, me.DeletionScheduledAt, "signing in cancels the deletion")
+
+	// Accounts are purged once the grace period is over
+	rec = do("DELETE", "/api/v1/me", auth.DeleteAccountRequest{Password: "a brand new password"}, session["token"])
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	purged, err := auth.PurgeDeletedAccounts(context.Background(), a)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+
+	a.Clock = func() time.Time { return time.Now().Add(a.Config.Auth.AccountDeletionGrace + time.Minute) }
+	purged, err = auth.PurgeDeletedAccounts(context.Background(), a)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	var users, blogs int
+	assert.NoError(t, conn.QueryRow(context.Background(), `SELECT count(*) FROM users`).Scan(&users))
+	assert.NoError(t, conn.QueryRow(context.Background(), `SELECT count(*) FROM blogs`).Scan(&blogs))
+	assert.Equal(t, 1, users)
+	assert.Zero(t, blogs, "what the account owned goes with it")
+}
+
+func TestPasswordlessAccountSelfService(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	// Accounts created through a provider or a sign-in link have no password
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email, email_verified)
+        VALUES ('testuser', '', 'test@example.com', TRUE)
+    `)
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	session := testhelpers.SessionCookie(t, a, 1, "customer")
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		req.AddCookie(session)
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A recent sign-in stands in for the password
+	rec := do("POST", "/api/v1/me/email", auth.ChangeEmailRequest{Email: "new@example.com"})
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	// An older one does not
+	a.Clock = func() time.Time { return time.Now().Add(a.Config.Auth.RecentLoginWindow + time.Minute) }
+	rec = do("DELETE", "/api/v1/me", auth.DeleteAccountRequest{})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var response problem.Problem
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, auth.CodeReauthenticationRequired, response.Code)
+	user, err := a.Queries.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.False(t, user.DeletionScheduledAt.Valid)
+
+	a.Clock = time.Now
+	rec = do("DELETE", "/api/v1/me", auth.DeleteAccountRequest{})
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
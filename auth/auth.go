@@ -1,97 +1,115 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
-	"github.com/golang-jwt/jwt"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/token"
 )
 
-type Claims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
-}
+// errUnauthenticated is returned when a request's credential is unknown,
+// expired or revoked.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// IsAuthorized rejects requests without a valid credential and makes the
+// caller available through PrincipalFrom. The credential is either an
+// access token, sent as the token cookie or as a Bearer token, or a
+// personal access token sent as a Bearer token. Handlers see the same
+// Principal either way. Responses to impersonated requests carry the
+// ImpersonationHeader, and the requests are recorded in the audit log.
+func IsAuthorized(a *app.App, endpoint func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := requestCredential(r)
+		if credential == "" {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
 
-var jwtKey = []byte("my_secret_key")
+		var p Principal
+		var err error
+		if isPersonalAccessToken(credential) {
+			p, err = authenticatePersonalAccessToken(r.Context(), a, credential)
+		} else {
+			p, err = authenticateAccessToken(r.Context(), a, credential)
+		}
+		switch {
+		case errors.Is(err, token.ErrLegacyToken):
+			problem.Error(w, "Token format is no longer supported, please sign in again", http.StatusUnauthorized)
+			return
+		case errors.Is(err, errUnauthenticated), errors.Is(err, token.ErrInvalidToken):
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		case err != nil:
+			a.Logger.Error("failed to authenticate request", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
 
-// HashPassword generates a bcrypt hash for the given password.
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
+		if p.Impersonated() {
+			if err := checkImpersonation(r, a, p); err != nil {
+				if errors.Is(err, errUnauthenticated) {
+					problem.Error(w, "", http.StatusUnauthorized)
+					return
+				}
+				a.Logger.Error("failed to check impersonation", "error", err)
+				problem.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set(ImpersonationHeader, strconv.Itoa(int(p.ActorID)))
+		}
 
-// VerifyPassword verifies if the given password matches the stored hash.
-func VerifyPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+		endpoint(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
 }
 
-func GetUsername(r *http.Request) string {
-	c, err := r.Cookie("token")
-	if err != nil {
-		return ""
+// requestCredential returns the Bearer token of the Authorization header,
+// falling back to the token cookie.
+func requestCredential(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credential, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(credential)
 	}
 
-	tknStr := c.Value
-	claims := &Claims{}
-
-	_, err = jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
-	if err != nil {
-		return ""
+	if c, err := r.Cookie(accessCookie); err == nil {
+		return c.Value
 	}
-	return claims.Username
+	return ""
 }
 
-func CreateToken(username string, expirationTime time.Time) (string, error) {
-	claims := &Claims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+// authenticateAccessToken resolves the caller of a JWT access token. Roles
+// are taken from the token, so role changes apply once the session is next
+// refreshed; the session is checked on every request, so revocation applies
+// at once.
+func authenticateAccessToken(ctx context.Context, a *app.App, tokenString string) (Principal, error) {
+	claims, err := a.Tokens.Parse(tokenString)
+	if err != nil {
+		return Principal{}, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	// Parse has already checked that the subject and actor are user IDs.
+	userID, _ := claims.UserID()
+	actorID, _ := claims.ActorID()
 
-}
-
-func IsAuthorized(endpoint func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		tknStr := c.Value
-		claims := &Claims{}
-
-		tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		if !tkn.Valid {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+	session, active, err := activeSession(ctx, a, claims.SessionID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !active || session.UserID != userID || session.ImpersonatorID.Int32 != actorID {
+		return Principal{}, errUnauthenticated
+	}
 
-		endpoint(w, r)
-	})
+	return Principal{
+		UserID:    userID,
+		Roles:     RolesFromStrings(claims.Roles),
+		SessionID: session.ID,
+		ActorID:   actorID,
+	}, nil
 }
@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Modul-306/backend/app"
 	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/password"
+	"github.com/Modul-306/backend/problem"
 	"github.com/Modul-306/backend/router"
 	"github.com/Modul-306/backend/tests/containers"
 	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/Modul-306/backend/token"
+	"github.com/golang-jwt/jwt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,6 +42,8 @@ func TestAuthHandlers(t *testing.T) {
 	testhelpers.SetupTestDB(t, conn)
 	defer testhelpers.CleanupTestDB(t, conn)
 
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
 	tests := []struct {
 		name      string
 		setup     func() *http.Request
@@ -44,7 +55,7 @@ func TestAuthHandlers(t *testing.T) {
 			setup: func() *http.Request {
 				creds := auth.SignUpCredentials{
 					Username: "newuser",
-					Password: "password123",
+					Password: "a new user password",
 					Email:    "new@example.com",
 				}
 				body, _ := json.Marshal(creds)
@@ -65,11 +76,80 @@ func TestAuthHandlers(t *testing.T) {
 				assert.True(t, hasToken, "token cookie not found")
 			},
 		},
+		{
+			name: "SignUp with taken username",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(auth.SignUpCredentials{
+					Username: "NewUser",
+					Password: "a new user password",
+					Email:    "other@example.com",
+				})
+				return httptest.NewRequest("POST", "/api/v1/auth/sign-up", bytes.NewBuffer(body))
+			},
+			wantCode: http.StatusConflict,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, problem.ContentType, rec.Header().Get("Content-Type"))
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, auth.CodeUsernameTaken, response.Code)
+				assert.Equal(t, http.StatusConflict, response.Status)
+			},
+		},
+		{
+			name: "SignUp with taken email",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(auth.SignUpCredentials{
+					Username: "otheruser",
+					Password: "a new user password",
+					Email:    " New@Example.com ",
+				})
+				return httptest.NewRequest("POST", "/api/v1/auth/sign-up", bytes.NewBuffer(body))
+			},
+			wantCode: http.StatusConflict,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, auth.CodeEmailTaken, response.Code)
+			},
+		},
+		{
+			name: "SignUp with blank username and malformed email",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(auth.SignUpCredentials{
+					Username: "   ",
+					Password: "a new user password",
+					Email:    "not an email",
+				})
+				return httptest.NewRequest("POST", "/api/v1/auth/sign-up", bytes.NewBuffer(body))
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, problem.CodeValidation, response.Code)
+				fields := []string{}
+				for _, e := range response.Errors {
+					fields = append(fields, e.Field)
+				}
+				assert.ElementsMatch(t, []string{"username", "email"}, fields)
+			},
+		},
+		{
+			name: "SignUp without email",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(auth.SignUpCredentials{
+					Username: "noemail",
+					Password: "a new user password",
+				})
+				return httptest.NewRequest("POST", "/api/v1/auth/sign-up", bytes.NewBuffer(body))
+			},
+			wantCode: http.StatusUnprocessableEntity,
+		},
 		{
 			name: "Login",
 			setup: func() *http.Request {
 				// Create test user first
-				hashedPassword, _ := auth.HashPassword("testpass")
+				hashedPassword, _ := a.Passwords.Hash("testpass")
 				_, err := conn.Exec(context.Background(), `
                     INSERT INTO users (name, password, email) 
                     VALUES ($1, $2, $3)
@@ -118,11 +198,8 @@ func TestAuthHandlers(t *testing.T) {
 			name: "Protected Endpoint",
 			setup: func() *http.Request {
 				req := httptest.NewRequest("GET", "/api/v1/order", nil)
-				token, _ := auth.CreateToken("testuser", time.Now().Add(time.Hour))
-				req.AddCookie(&http.Cookie{
-					Name:  "token",
-					Value: token,
-				})
+				// testuser was inserted after newuser signed up
+				req.AddCookie(testhelpers.SessionCookie(t, a, 2, "customer"))
 				return req
 			},
 			wantCode: http.StatusOK,
@@ -132,10 +209,10 @@ func TestAuthHandlers(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			req := tt.setup()
+			req := testhelpers.WithCSRF(a, tt.setup())
 			rec := httptest.NewRecorder()
 
-			sut := router.CreateRouter()
+			sut := router.CreateRouter(a)
 
 			// Act
 			// changed act - calling GetById through production router
@@ -153,22 +230,179 @@ func TestAuthHandlers(t *testing.T) {
 	}
 }
 
-func TestPassword(t *testing.T) {
-	password := "testpass"
-	hash, err := auth.HashPassword(password)
+func TestPasswordRehash(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	// Earlier versions stored the password of updated users in plaintext
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email)
+        VALUES ($1, $2, $3), ($4, $5, $6)
+    `, "testuser", "testpass", "test@example.com", "otheruser", "otherpass", "other@example.com")
+	if err != nil {
+		t.Fatalf("failed to create test users: %v", err)
+	}
+
+	loginAs := func(username, password string) int {
+		body, _ := json.Marshal(auth.Credentials{Username: username, Password: password})
+		req := httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	login := func() int { return loginAs("testuser", "testpass") }
+	stored := func() string {
+		user, err := a.Queries.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+		return user.Password
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login(), "plaintext is refused unless allowed")
+	a.Passwords.AllowPlaintext = true
+	assert.Equal(t, http.StatusOK, login())
+	assert.True(t, strings.HasPrefix(stored(), "$2a$"), "plaintext is replaced by a bcrypt hash")
+
+	a.Passwords.Algorithm = password.Argon2id
+	assert.Equal(t, http.StatusOK, login())
+	assert.True(t, strings.HasPrefix(stored(), "$argon2id$"), "switching the algorithm upgrades on login")
+
+	assert.Equal(t, http.StatusOK, login())
+
+	// Users who never sign in again are converted by hash-passwords
+	a.Passwords.AllowPlaintext = false
+	hashed, err := auth.HashPlaintextPasswords(context.Background(), a)
 	assert.NoError(t, err)
-	assert.True(t, auth.VerifyPassword(password, hash))
-	assert.False(t, auth.VerifyPassword("wrongpass", hash))
+	assert.Equal(t, 1, hashed)
+	assert.Equal(t, http.StatusOK, loginAs("otheruser", "otherpass"))
+}
+
+// sessionStore serves sessions from memory so that IsAuthorized can run
+// without a database.
+type sessionStore struct {
+	db.Querier
+	sessions map[string]db.Session
+}
+
+func (s sessionStore) GetSession(ctx context.Context, id string) (db.Session, error) {
+	session, ok := s.sessions[id]
+	if !ok {
+		return db.Session{}, pgx.ErrNoRows
+	}
+	return session, nil
+}
+
+func (s sessionStore) TouchSession(ctx context.Context, arg db.TouchSessionParams) error {
+	session := s.sessions[arg.ID]
+	session.LastSeenAt = arg.LastSeenAt
+	s.sessions[arg.ID] = session
+	return nil
 }
 
 func TestToken(t *testing.T) {
-	username := "testuser"
-	expiry := time.Now().Add(time.Hour)
-	token, err := auth.CreateToken(username, expiry)
+	expires := pgtype.Timestamp{Time: time.Now().Add(time.Hour), Valid: true}
+	a := &app.App{
+		Queries: sessionStore{sessions: map[string]db.Session{
+			"active":        {ID: "active", UserID: 7, ExpiresAt: expires},
+			"revoked":       {ID: "revoked", UserID: 7, ExpiresAt: expires, RevokedAt: pgtype.Timestamp{Time: time.Now(), Valid: true}},
+			"impersonation": {ID: "impersonation", UserID: 7, ExpiresAt: expires, ImpersonatorID: pgtype.Int4{Int32: 1, Valid: true}},
+		}},
+		Clock:  time.Now,
+		Logger: slog.Default(),
+	}
+	keys, err := token.NewKeyRing(token.Key{ID: "test", Algorithm: token.HS256, Secret: []byte("test_secret_key")})
 	assert.NoError(t, err)
-	assert.NotEmpty(t, token)
+	a.Tokens = token.NewSigner(keys, "backend", "api", time.Now)
+
+	var got auth.Principal
+	endpoint := auth.IsAuthorized(a, func(w http.ResponseWriter, r *http.Request) {
+		got, _ = auth.PrincipalFrom(r.Context())
+	})
+	call := func(tokenString string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "token", Value: tokenString})
+		rec := httptest.NewRecorder()
+		endpoint(rec, req)
+		return rec
+	}
+
+	tokenString, err := a.Tokens.Create(token.Subject{UserID: 7, Roles: []string{"customer", "editor"}, SessionID: "active"}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokenString)
+
+	rec := call(tokenString)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, auth.Principal{UserID: 7, Roles: []auth.Role{auth.RoleCustomer, auth.RoleEditor}, SessionID: "active"}, got)
+	assert.True(t, a.Queries.(sessionStore).sessions["active"].LastSeenAt.Valid, "using a session records activity")
+
+	// Tokens of revoked or unknown sessions are turned away
+	revoked, err := a.Tokens.Create(token.Subject{UserID: 7, SessionID: "revoked"}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, call(revoked).Code)
+
+	unknown, err := a.Tokens.Create(token.Subject{UserID: 7, SessionID: "unknown"}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, call(unknown).Code)
+
+	// A session only vouches for its own user
+	otherUser, err := a.Tokens.Create(token.Subject{UserID: 8, SessionID: "active"}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, call(otherUser).Code)
+
+	// and for the admin impersonating with it
+	for name, subject := range map[string]token.Subject{
+		"no actor":          {UserID: 7, SessionID: "impersonation"},
+		"other actor":       {UserID: 7, SessionID: "impersonation", ActorID: 2},
+		"not impersonating": {UserID: 7, SessionID: "active", ActorID: 1},
+	} {
+		forged, err := a.Tokens.Create(subject, time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, call(forged).Code, name)
+	}
+
+	// Username-only tokens from before the switch to user IDs are turned away
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": "testuser",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test_secret_key"))
+	assert.NoError(t, err)
+
+	rec = call(legacy)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "sign in again")
+}
+
+func TestPermissions(t *testing.T) {
+	customer := auth.Principal{Roles: []auth.Role{auth.RoleCustomer}}
+	editor := auth.Principal{Roles: []auth.Role{auth.RoleCustomer, auth.RoleEditor}}
+	admin := auth.Principal{Roles: []auth.Role{auth.RoleCustomer, auth.RoleAdmin}}
+
+	assert.True(t, customer.Can(auth.PermOrdersWrite))
+	assert.False(t, customer.Can(auth.PermBlogsWrite))
+	assert.False(t, customer.Can(auth.PermProductsWrite))
+	assert.False(t, customer.Can(auth.PermUsersManage))
+
+	assert.True(t, editor.Can(auth.PermBlogsWrite))
+	assert.False(t, editor.Can(auth.PermOrdersReadAll))
+
+	assert.True(t, admin.Can(auth.PermProductsWrite))
+	assert.True(t, admin.Can(auth.PermUsersManage))
+	assert.True(t, admin.Can(auth.PermOrdersReadAll))
 
-	req := httptest.NewRequest("GET", "/", nil)
-	req.AddCookie(&http.Cookie{Name: "token", Value: token})
-	assert.Equal(t, username, auth.GetUsername(req))
+	assert.False(t, auth.Principal{}.Can(auth.PermOrdersRead))
+	assert.False(t, auth.ValidRole("superuser"))
 }
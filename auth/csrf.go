@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/problem"
+)
+
+const (
+	csrfCookie = "csrf_token"
+	// CSRFHeader must repeat the CSRF token on unsafe requests that are
+	// authenticated by cookies.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRFToken returns the CSRF token of a session. It is derived from the
+// secret session ID, so unlike a random double-submit cookie it needs no
+// storage and cannot be planted by a sibling domain.
+func CSRFToken(sessionID string) string {
+	return hashToken("csrf:" + sessionID)
+}
+
+// setCSRFCookie hands the session's CSRF token to the client, both as a
+// cookie that scripts on the frontend can read and as a response header.
+func setCSRFCookie(w http.ResponseWriter, a *app.App, sessionID string, expires time.Time) {
+	token := CSRFToken(sessionID)
+	c := authCookie(a, csrfCookie, token, "/", expires)
+	c.HttpOnly = false
+	http.SetCookie(w, c)
+	w.Header().Set(CSRFHeader, token)
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CSRF returns middleware that protects cookie-authenticated requests from
+// being sent by other sites. Unsafe requests, i.e. anything but GET, HEAD,
+// OPTIONS and TRACE, are refused unless
+//
+//   - their Origin, or failing that their Referer, is one of the trusted
+//     origins, if the browser sent either, and
+//   - if they carry a session cookie, the CSRFHeader holds the session's
+//     CSRF token.
+//
+// Requests with an Authorization header are exempt: browsers never add one
+// on their own, and such requests do not use the cookies.
+func CSRF(a *app.App) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if safeMethod(r.Method) || r.Header.Get("Authorization") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !trustedOrigin(r, a.Config.Server.TrustedOrigins()) {
+				problem.Error(w, "Cross-origin request refused", http.StatusForbidden)
+				return
+			}
+
+			// Without a session there is nothing to forge a request with.
+			if sessionID := requestSessionID(r, a); sessionID != "" {
+				want := CSRFToken(sessionID)
+				got := r.Header.Get(CSRFHeader)
+				if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+					problem.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedOrigin reports whether the request comes from one of origins.
+// Requests without Origin and Referer are not from a browser page and pass.
+func trustedOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	// Browsers send "null" for sandboxed and privacy-sensitive contexts,
+	// which never belongs to the list.
+	return slices.Contains(origins, strings.ToLower(origin))
+}
+
+// GetCSRFToken returns the handler that tells the caller the CSRF token of
+// their session, for sessions started before the cookie existed and for
+// frontends on another site that cannot read it.
+func GetCSRFToken(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID := requestSessionID(r, a)
+		if sessionID == "" {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		session, active, err := activeSession(r.Context(), a, sessionID)
+		if err != nil {
+			a.Logger.Error("failed to load session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		setCSRFCookie(w, a, session.ID, session.ExpiresAt.Time)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CSRFTokenResponse{CSRFToken: CSRFToken(session.ID)})
+	}
+}
@@ -0,0 +1,126 @@
+package auth_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/config"
+	"github.com/Modul-306/backend/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRF(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.FrontendURL = "https://shop.example.com"
+	cfg.Server.CSRFTrustedOrigins = []string{"https://admin.example.com"}
+	a := &app.App{Config: cfg, Clock: time.Now, Logger: slog.Default()}
+	keys, err := token.NewKeyRing(token.Key{ID: "test", Algorithm: token.HS256, Secret: []byte("test_secret_key")})
+	assert.NoError(t, err)
+	a.Tokens = token.NewSigner(keys, "backend", "api", time.Now)
+
+	session, err := a.Tokens.Create(token.Subject{UserID: 7, SessionID: "session"}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	handler := auth.CSRF(a)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		header   map[string]string
+		cookie   bool
+		wantCode int
+	}{
+		{name: "safe method", method: "GET", cookie: true, wantCode: http.StatusNoContent},
+		{name: "without session", method: "POST", wantCode: http.StatusNoContent},
+		{name: "session without token", method: "POST", cookie: true, wantCode: http.StatusForbidden},
+		{name: "custom method without token", method: "UPDATE", cookie: true, wantCode: http.StatusForbidden},
+		{
+			name:     "session with token",
+			method:   "DELETE",
+			cookie:   true,
+			header:   map[string]string{auth.CSRFHeader: auth.CSRFToken("session")},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "token of another session",
+			method:   "POST",
+			cookie:   true,
+			header:   map[string]string{auth.CSRFHeader: auth.CSRFToken("other")},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "authorization header",
+			method:   "POST",
+			cookie:   true,
+			header:   map[string]string{"Authorization": "Bearer " + session, "Origin": "https://evil.example.com"},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "frontend origin",
+			method:   "POST",
+			header:   map[string]string{"Origin": "https://shop.example.com"},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "trusted origin",
+			method:   "POST",
+			header:   map[string]string{"Origin": "https://ADMIN.example.com"},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "untrusted origin",
+			method:   "POST",
+			header:   map[string]string{"Origin": "https://evil.example.com"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "untrusted origin with token",
+			method:   "POST",
+			cookie:   true,
+			header:   map[string]string{"Origin": "https://evil.example.com", auth.CSRFHeader: auth.CSRFToken("session")},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "null origin",
+			method:   "POST",
+			header:   map[string]string{"Origin": "null"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "trusted referer",
+			method:   "POST",
+			header:   map[string]string{"Referer": "https://shop.example.com/cart?step=2"},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "untrusted referer",
+			method:   "POST",
+			header:   map[string]string{"Referer": "https://shop.example.com.evil.example.com/"},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/order", nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: "token", Value: session})
+			}
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
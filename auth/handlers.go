@@ -3,104 +3,169 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"time"
+	"strings"
+	"sync"
 
+	"github.com/Modul-306/backend/app"
 	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/validate"
+	"github.com/jackc/pgx/v5"
 )
 
-func Login(w http.ResponseWriter, r *http.Request) {
-	var creds Credentials
-	err := json.NewDecoder(r.Body).Decode(&creds)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// Authenticate the user
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(context.Background())
-
-	db := db.New(conn)
-
-	user, err := db.GetUserByUsername(context.Background(), creds.Username)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	if !VerifyPassword(creds.Password, user.Password) {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	expirationTime := time.Now().Add(5 * time.Minute)
-
-	tokenString, err := CreateToken(creds.Username, expirationTime)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
+// Login returns the handler that exchanges credentials for a new session.
+// Users with a second factor instead get an MFA token to complete the
+// login with at LoginMFA.
+//
+// Failed logins are counted per user name and per client IP; see
+// throttle.go. Unknown user names are checked against a dummy hash so that
+// they take as long as real ones.
+func Login(a *app.App) http.HandlerFunc {
+	dummyHash := sync.OnceValue(func() string {
+		hash, _ := a.Passwords.Hash("not the password of any user")
+		return hash
 	})
-}
-
-func SignUp(w http.ResponseWriter, r *http.Request) {
-	var creds SignUpCredentials
-	err := json.NewDecoder(r.Body).Decode(&creds)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
 
-	// Authenticate the user
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds Credentials
+		err := json.NewDecoder(r.Body).Decode(&creds)
+		if err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		ip := ClientIP(r, a.Config.Server.TrustProxyHeaders)
+		keys := []loginKey{userLoginKey(a, creds.Username), ipLoginKey(a, ip)}
+		wait, err := loginRetryAfter(r.Context(), a, keys...)
+		if err != nil {
+			a.Logger.Error("failed to check login throttling", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
+		// Authenticate the user
+		user, err := a.Queries.GetUserByUsername(r.Context(), creds.Username)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		hash := user.Password
+		if err != nil {
+			hash = dummyHash()
+		}
+		ok, rehash := a.Passwords.Verify(creds.Password, hash)
+		if !ok || err != nil {
+			recordLoginFailure(r.Context(), a, ip, keys...)
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		if rehash {
+			upgradePasswordHash(r.Context(), a, user.ID, creds.Password)
+		}
+
+		enrolled, err := hasTOTP(r.Context(), a, user.ID)
+		if err != nil {
+			a.Logger.Error("failed to look up second factor", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if enrolled {
+			// Failures are only cleared once the second factor is in too.
+			startMFAChallenge(w, r, a, user)
+			return
+		}
+
+		if err := ClearLoginFailures(r.Context(), a, user.Name); err != nil {
+			a.Logger.Error("failed to clear login failures", "error", err)
+		}
+
+		if err := startSession(w, r, a, user, false); err != nil {
+			a.Logger.Error("failed to start session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
 	}
-	defer conn.Close(context.Background())
+}
 
-	hashedPassword, err := HashPassword(creds.Password)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// upgradePasswordHash replaces the stored hash of a user who just signed in
+// with one made with the configured algorithm and cost. Failing to do so
+// does not fail the login; it is tried again next time.
+func upgradePasswordHash(ctx context.Context, a *app.App, userID int32, password string) {
+	hash, err := a.Passwords.Hash(password)
+	if err == nil {
+		err = a.Queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+			ID:       userID,
+			Password: hash,
+		})
 	}
-
-	dbConn := db.New(conn)
-	_, err = dbConn.CreateUser(context.Background(), db.CreateUserParams{
-		Name:     creds.Username,
-		Password: hashedPassword,
-		Email:    creds.Email,
-	})
-
 	if err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
-		return
+		a.Logger.Error("failed to upgrade password hash", "error", err)
 	}
+}
 
-	expirationTime := time.Now().Add(5 * time.Minute)
-
-	tokenString, err := CreateToken(creds.Username, expirationTime)
-	if err != nil {
-		http.Error(w, "Failed to create token", http.StatusInternalServerError)
-		return
+// SignUp returns the handler that registers a new user, signs them in and
+// mails them a link to verify their address.
+func SignUp(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds SignUpCredentials
+		err := json.NewDecoder(r.Body).Decode(&creds)
+		if err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		creds.Username = strings.TrimSpace(creds.Username)
+		creds.Email = NormalizeEmail(creds.Email)
+		if err := validate.Struct(creds); err != nil {
+			problem.Write(w, problem.FromError(err))
+			return
+		}
+		if err := a.Passwords.Check(creds.Password); err != nil {
+			problem.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hashedPassword, err := a.Passwords.Hash(creds.Password)
+		if err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		user, err := a.Queries.CreateUser(r.Context(), db.CreateUserParams{
+			Name:     creds.Username,
+			Password: hashedPassword,
+			Email:    creds.Email,
+		})
+
+		if UserConflict(w, err) {
+			return
+		}
+		if err != nil {
+			a.Logger.Error("failed to create user", "error", err)
+			problem.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+
+		if err := startSession(w, r, a, user, false); err != nil {
+			a.Logger.Error("failed to start session", "error", err)
+			problem.Error(w, "Failed to start session", http.StatusInternalServerError)
+			return
+		}
+
+		// The account is usable right away; routes that need a confirmed
+		// address check it with RequireVerifiedEmail.
+		if err := StartEmailVerification(r.Context(), a, user); err != nil {
+			a.Logger.Error("failed to start email verification", "error", err)
+		}
+
+		w.WriteHeader(http.StatusCreated)
 	}
-
-	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
-
-	w.WriteHeader(http.StatusCreated)
 }
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/audit"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/token"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ImpersonationHeader is set on responses to impersonated requests and
+// holds the user ID of the admin acting.
+const ImpersonationHeader = "X-Impersonated-By"
+
+// Impersonate returns the handler that lets an admin act as the user with
+// the ID in the URL. The token it hands out names both of them, expires
+// after ImpersonationTTL and is refused by DenyImpersonation and every
+// handler that changes credentials. Admins cannot be impersonated, so
+// impersonation never grants more than the caller already has.
+func Impersonate(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if int32(id) == p.UserID {
+			problem.Error(w, "Cannot impersonate yourself", http.StatusBadRequest)
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), int32(id))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if slices.Contains(user.Roles, string(RoleAdmin)) {
+			problem.Error(w, "Admins cannot be impersonated", http.StatusForbidden)
+			return
+		}
+
+		sessionID, err := newOpaqueToken()
+		if err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		ip := ClientIP(r, a.Config.Server.TrustProxyHeaders)
+		now := a.Clock()
+		expiresAt := now.Add(a.Config.Auth.ImpersonationTTL)
+		_, err = a.Queries.CreateSession(r.Context(), db.CreateSessionParams{
+			ID:             sessionID,
+			UserID:         user.ID,
+			ExpiresAt:      timestamp(expiresAt),
+			UserAgent:      sessionUserAgent(r),
+			Ip:             ip,
+			CreatedAt:      timestamp(now),
+			ImpersonatorID: pgtype.Int4{Int32: p.UserID, Valid: true},
+		})
+		if err != nil {
+			a.Logger.Error("failed to create session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		access, err := a.Tokens.Create(token.Subject{
+			UserID:    user.ID,
+			Roles:     user.Roles,
+			SessionID: sessionID,
+			ActorID:   p.UserID,
+		}, expiresAt)
+		if err != nil {
+			a.Logger.Error("failed to create token", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		audit.Record(r.Context(), a, audit.Entry{
+			Action:  audit.ImpersonationStarted,
+			ActorID: p.UserID,
+			Target:  "user:" + strings.ToLower(user.Name),
+			IP:      ip,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ImpersonationResponse{
+			Token:     access,
+			UserID:    user.ID,
+			ExpiresAt: expiresAt,
+		})
+	}
+}
+
+// checkImpersonation makes sure the admin behind p may still impersonate
+// and records the request in the audit log. Taking away the admin's
+// permission ends their impersonations at once.
+func checkImpersonation(r *http.Request, a *app.App, p Principal) error {
+	actor, err := a.Queries.GetUser(r.Context(), p.ActorID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errUnauthenticated
+		}
+		return err
+	}
+	if !(Principal{Roles: RolesFromStrings(actor.Roles)}).Can(PermUsersManage) {
+		return errUnauthenticated
+	}
+
+	user, err := a.Queries.GetUser(r.Context(), p.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errUnauthenticated
+		}
+		return err
+	}
+
+	audit.Record(r.Context(), a, audit.Entry{
+		Action:  audit.ImpersonatedRequest,
+		ActorID: actor.ID,
+		Target:  "user:" + strings.ToLower(user.Name),
+		IP:      ClientIP(r, a.Config.Server.TrustProxyHeaders),
+		Detail:  r.Method + " " + r.URL.Path,
+	})
+	return nil
+}
+
+// DenyImpersonation responds 403 to impersonated callers. It guards
+// actions only the users themselves may take, such as changing their
+// credentials, and must run behind IsAuthorized.
+func DenyImpersonation(endpoint http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := PrincipalFrom(r.Context()); ok && p.Impersonated() {
+			problem.Error(w, errImpersonationDenied, http.StatusForbidden)
+			return
+		}
+		endpoint(w, r)
+	}
+}
+
+const errImpersonationDenied = "Not allowed while impersonating a user"
@@ -0,0 +1,183 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonation(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	now := time.Now()
+	a.Clock = func() time.Time { return now }
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("testpass")
+	var adminID, otherAdminID, userID int32
+	for _, u := range []struct {
+		name  string
+		roles []string
+		id    *int32
+	}{
+		{"admin", []string{"customer", "admin"}, &adminID},
+		{"otheradmin", []string{"customer", "admin"}, &otherAdminID},
+		{"testuser", []string{"customer"}, &userID},
+	} {
+		err = conn.QueryRow(context.Background(), `
+            INSERT INTO users (name, password, email, email_verified, roles)
+            VALUES ($1, $2, $1 || '@example.com', TRUE, $3)
+            RETURNING id
+        `, u.name, hashedPassword, u.roles).Scan(u.id)
+		if err != nil {
+			t.Fatalf("failed to create test user: %v", err)
+		}
+	}
+	admin := testhelpers.SessionCookie(t, a, adminID, "customer", "admin")
+	customer := testhelpers.SessionCookie(t, a, userID, "customer")
+
+	do := func(method, path string, body interface{}, credential interface{}) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		switch c := credential.(type) {
+		case *http.Cookie:
+			req.AddCookie(c)
+			testhelpers.WithCSRF(a, req)
+		case string:
+			req.Header.Set("Authorization", "Bearer "+c)
+		}
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	impersonate := func(id int32, session *http.Cookie) *httptest.ResponseRecorder {
+		return do("POST", fmt.Sprintf("/api/v1/user/%d/impersonate", id), nil, session)
+	}
+	auditEntries := func(action, detail string) int {
+		var entries int
+		err := conn.QueryRow(context.Background(), `
+            SELECT count(*) FROM audit_log
+            WHERE action = $1 AND actor_id = $2 AND target = 'user:testuser' AND ($3 = '' OR detail = $3)
+        `, action, adminID, detail).Scan(&entries)
+		assert.NoError(t, err)
+		return entries
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, impersonate(adminID, customer).Code, "customers")
+		assert.Equal(t, http.StatusForbidden, impersonate(otherAdminID, admin).Code, "admins")
+		assert.Equal(t, http.StatusBadRequest, impersonate(adminID, admin).Code, "yourself")
+		assert.Equal(t, http.StatusNotFound, impersonate(9999, admin).Code, "unknown user")
+		assert.Zero(t, auditEntries("impersonation_started", ""))
+	})
+
+	var impersonation auth.ImpersonationResponse
+	t.Run("impersonate", func(t *testing.T) {
+		rec := impersonate(userID, admin)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&impersonation))
+		assert.Equal(t, userID, impersonation.UserID)
+		assert.WithinDuration(t, now.Add(a.Config.Auth.ImpersonationTTL), impersonation.ExpiresAt, time.Second)
+		assert.Equal(t, 1, auditEntries("impersonation_started", ""))
+
+		// The admin sees the shop as the user, flagged as impersonated
+		rec = do("GET", "/api/v1/me", nil, impersonation.Token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, strconv.Itoa(int(adminID)), rec.Header().Get(auth.ImpersonationHeader))
+		var me handlers.UserResponse
+		json.NewDecoder(rec.Body).Decode(&me)
+		assert.Equal(t, "testuser", me.Name)
+
+		rec = do("GET", "/api/v1/order", nil, impersonation.Token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get(auth.ImpersonationHeader))
+
+		// Every request is audited
+		assert.Equal(t, 1, auditEntries("impersonated_request", "GET /api/v1/me"))
+		assert.Equal(t, 1, auditEntries("impersonated_request", "GET /api/v1/order"))
+
+		// The user's own requests are not flagged, and the impersonation is
+		// not one of their sessions
+		rec = do("GET", "/api/v1/me/sessions", nil, customer)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get(auth.ImpersonationHeader))
+		var sessions []auth.SessionResponse
+		json.NewDecoder(rec.Body).Decode(&sessions)
+		assert.Len(t, sessions, 1)
+	})
+
+	t.Run("sensitive actions", func(t *testing.T) {
+		user := fmt.Sprintf("/api/v1/user/%d", userID)
+		for _, tt := range []struct {
+			method, path string
+			body         interface{}
+		}{
+			{"POST", "/api/v1/me/password", map[string]string{"current_password": "testpass", "new_password": "a brand new password"}},
+			{"POST", "/api/v1/me/email", map[string]string{"email": "evil@example.com"}},
+			{"UPDATE", "/api/v1/me", map[string]string{"name": "renamed"}},
+			{"DELETE", "/api/v1/me", map[string]string{"password": "testpass"}},
+			{"UPDATE", user, map[string]string{"name": "testuser", "password": "a brand new password"}},
+			{"DELETE", user, nil},
+			{"POST", "/api/v1/tokens", map[string]interface{}{"name": "backdoor", "scopes": []string{"orders:read"}}},
+			{"POST", "/api/v1/auth/mfa/totp", nil},
+			{"POST", "/api/v1/me/passkeys/begin", nil},
+			{"POST", "/api/v1/auth/logout-all", nil},
+			{"POST", fmt.Sprintf("/api/v1/user/%d/impersonate", otherAdminID), nil},
+		} {
+			rec := do(tt.method, tt.path, tt.body, impersonation.Token)
+			assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s", tt.method, tt.path)
+		}
+
+		// The password still works
+		body, _ := json.Marshal(auth.Credentials{Username: "testuser", Password: "testpass"})
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("end", func(t *testing.T) {
+		// Impersonation ends with the admin's permission
+		_, err := conn.Exec(context.Background(), `UPDATE users SET roles = '{customer}' WHERE id = $1`, adminID)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", nil, impersonation.Token).Code)
+
+		_, err = conn.Exec(context.Background(), `UPDATE users SET roles = '{customer,admin}' WHERE id = $1`, adminID)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, do("GET", "/api/v1/me", nil, impersonation.Token).Code)
+
+		// and after ImpersonationTTL
+		now = now.Add(a.Config.Auth.ImpersonationTTL)
+		assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", nil, impersonation.Token).Code)
+	})
+}
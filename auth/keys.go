@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Modul-306/backend/app"
+)
+
+// JWKS returns the handler that publishes the public signing keys, so that
+// other services can verify our tokens without sharing a secret.
+func JWKS(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// Keys are published ahead of use, so verifiers may cache briefly.
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(a.Tokens.JWKS())
+	}
+}
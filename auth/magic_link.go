@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// magicLinkCookie binds sign-in links to the browser that requested
+	// them.
+	magicLinkCookie     = "magic_link"
+	magicLinkCookiePath = "/api/v1/auth/magic-link"
+)
+
+// RequestMagicLink returns the handler that mails a sign-in link, as an
+// alternative to Login for users who do not want to keep a password. Like
+// ForgotPassword, it answers the same way whether or not the address
+// belongs to an account.
+//
+// Requests are limited per address and per client IP. With
+// MagicLinkBindBrowser the link only works in the browser that asked for
+// it, which is recognised by a cookie set here.
+func RequestMagicLink(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MagicLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		email := NormalizeEmail(req.Email)
+		if email == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		ip := ClientIP(r, a.Config.Server.TrustProxyHeaders)
+		wait, err := magicLinkRetryAfter(r.Context(), a, email, ip)
+		if err != nil {
+			a.Logger.Error("failed to check magic link requests", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			problem.Error(w, "Too many sign-in links requested, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		now := a.Clock()
+		err = a.Queries.CreateMagicLinkRequest(r.Context(), db.CreateMagicLinkRequestParams{
+			Email:     email,
+			Ip:        ip,
+			CreatedAt: timestamp(now),
+		})
+		if err != nil {
+			a.Logger.Error("failed to record magic link request", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		var browserHash string
+		if a.Config.Auth.MagicLinkBindBrowser {
+			// A browser keeps its cookie, so that asking again does not
+			// break the links it already has.
+			browser := ""
+			if c, err := r.Cookie(magicLinkCookie); err == nil {
+				browser = c.Value
+			}
+			if browser == "" {
+				if browser, err = newOpaqueToken(); err != nil {
+					problem.Error(w, "", http.StatusInternalServerError)
+					return
+				}
+			}
+			http.SetCookie(w, authCookie(a, magicLinkCookie, browser, magicLinkCookiePath, now.Add(a.Config.Auth.MagicLinkTTL)))
+			browserHash = hashToken(browser)
+		}
+
+		inBackground(a, "magic link", func(ctx context.Context) error {
+			if err := a.Queries.DeleteMagicLinkRequestsBefore(ctx, timestamp(now.Add(-a.Config.Auth.MagicLinkWindow))); err != nil {
+				a.Logger.Error("failed to delete old magic link requests", "error", err)
+			}
+			return sendMagicLink(ctx, a, email, browserHash)
+		})
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account with that email exists, a sign-in link has been sent.",
+		})
+	}
+}
+
+// magicLinkRetryAfter returns how long the caller has to wait before asking
+// for another link for email from ip, or zero if they may ask now.
+func magicLinkRetryAfter(ctx context.Context, a *app.App, email, ip string) (time.Duration, error) {
+	now := a.Clock()
+	since := timestamp(now.Add(-a.Config.Auth.MagicLinkWindow))
+
+	byEmail, err := a.Queries.GetMagicLinkRequestsByEmail(ctx, db.GetMagicLinkRequestsByEmailParams{
+		Email:     email,
+		CreatedAt: since,
+	})
+	if err != nil {
+		return 0, err
+	}
+	byIP, err := a.Queries.GetMagicLinkRequestsByIP(ctx, db.GetMagicLinkRequestsByIPParams{
+		Ip:        ip,
+		CreatedAt: since,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// A slot frees up once the oldest request in the window leaves it.
+	var wait time.Duration
+	if int(byEmail.Requests) >= a.Config.Auth.MagicLinkEmailLimit {
+		wait = max(wait, byEmail.Oldest.Time.Add(a.Config.Auth.MagicLinkWindow).Sub(now))
+	}
+	if int(byIP.Requests) >= a.Config.Auth.MagicLinkIPLimit {
+		wait = max(wait, byIP.Oldest.Time.Add(a.Config.Auth.MagicLinkWindow).Sub(now))
+	}
+	return max(wait, 0), nil
+}
+
+func sendMagicLink(ctx context.Context, a *app.App, email, browserHash string) error {
+	user, err := a.Queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	now := a.Clock()
+	err = a.Queries.CreateMagicLink(ctx, db.CreateMagicLinkParams{
+		TokenHash:   hashToken(secret),
+		UserID:      user.ID,
+		Email:       user.Email,
+		BrowserHash: browserHash,
+		ExpiresAt:   timestamp(now.Add(a.Config.Auth.MagicLinkTTL)),
+		CreatedAt:   timestamp(now),
+	})
+	if err != nil {
+		return err
+	}
+
+	where := ""
+	if browserHash != "" {
+		where = " in the browser you asked for it from"
+	}
+	return a.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Open the link below%s to sign in. It expires in %s and works once.\n\n"+
+			"%s\n\n"+
+			"If you did not ask for this, you can ignore this mail.\n",
+			user.Name, where, a.Config.Auth.MagicLinkTTL, frontendLink(a, "/magic-link", secret)),
+	})
+}
+
+// RedeemMagicLink returns the handler that signs in with a token from
+// RequestMagicLink. It sets the same session cookies as Login, and likewise
+// asks users with a second factor for it first. As the link proves that
+// the user receives mail at their address, the address counts as verified.
+func RedeemMagicLink(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		var browserHash string
+		if c, err := r.Cookie(magicLinkCookie); err == nil && c.Value != "" {
+			browserHash = hashToken(c.Value)
+		}
+
+		link, err := a.Queries.UseMagicLink(r.Context(), db.UseMagicLinkParams{
+			TokenHash:   hashToken(req.Token),
+			ExpiresAt:   timestamp(a.Clock()),
+			BrowserHash: browserHash,
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Invalid or expired sign-in link", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to use magic link", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), link.UserID)
+		if err != nil {
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if user.Email != link.Email {
+			problem.Error(w, "Invalid or expired sign-in link", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := a.Queries.VerifyUserEmail(r.Context(), db.VerifyUserEmailParams{
+			ID:    user.ID,
+			Email: user.Email,
+		}); err != nil {
+			a.Logger.Error("failed to verify email", "error", err)
+		}
+		if err := a.Queries.DeleteUserMagicLinks(r.Context(), user.ID); err != nil {
+			a.Logger.Error("failed to delete magic links", "error", err)
+		}
+		clearMagicLinkCookie(w, a)
+
+		enrolled, err := hasTOTP(r.Context(), a, user.ID)
+		if err != nil {
+			a.Logger.Error("failed to look up second factor", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if enrolled {
+			startMFAChallenge(w, r, a, user)
+			return
+		}
+
+		if err := startSession(w, r, a, user, false); err != nil {
+			a.Logger.Error("failed to start session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func clearMagicLinkCookie(w http.ResponseWriter, a *app.App) {
+	c := authCookie(a, magicLinkCookie, "", magicLinkCookiePath, time.Unix(0, 0))
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
@@ -0,0 +1,157 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var magicLink = regexp.MustCompile(`/magic-link\?token=([A-Za-z0-9_-]+)`)
+
+func TestMagicLink(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("forgotten password")
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email)
+        VALUES ($1, $2, $3)
+    `, "testuser", hashedPassword, "test@example.com")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+
+	post := func(path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			if c != nil {
+				req.AddCookie(c)
+			}
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	redeem := func(secret string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return post("/api/v1/auth/magic-link/redeem", auth.VerifyEmailRequest{Token: secret}, cookies...)
+	}
+
+	unknown := post("/api/v1/auth/magic-link", auth.MagicLinkRequest{Email: "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, unknown.Code)
+
+	// request asks for a link and waits for the mail, returning its token
+	// and the cookie of the requesting browser.
+	request := func(browser ...*http.Cookie) (string, *http.Cookie) {
+		sent := len(testhelpers.SentMail(t, a))
+		rec := post("/api/v1/auth/magic-link", auth.MagicLinkRequest{Email: " Test@Example.com"}, browser...)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, unknown.Body.String(), rec.Body.String(), "existing accounts are not revealed")
+
+		assert.Eventually(t, func() bool {
+			return len(testhelpers.SentMail(t, a)) > sent
+		}, 5*time.Second, 10*time.Millisecond)
+		messages := testhelpers.SentMail(t, a)
+		match := magicLink.FindStringSubmatch(messages[len(messages)-1])
+		if match == nil {
+			t.Fatalf("no sign-in link in mail: %s", messages[len(messages)-1])
+		}
+		return match[1], cookiesByName(rec)["magic_link"]
+	}
+
+	secret, browser := request()
+	// Only the requested account got mail
+	assert.Len(t, testhelpers.SentMail(t, a), 1)
+	if browser == nil {
+		t.Fatal("no browser cookie set")
+	}
+
+	// Only the hash of the token is stored
+	var stored int
+	err = conn.QueryRow(context.Background(),
+		`SELECT count(*) FROM magic_links WHERE token_hash = $1`, secret).Scan(&stored)
+	assert.NoError(t, err)
+	assert.Zero(t, stored)
+
+	// Links only work in the browser that asked for them, and trying
+	// elsewhere does not use them up
+	assert.Equal(t, http.StatusBadRequest, redeem(secret).Code)
+	assert.Equal(t, http.StatusBadRequest, redeem(secret, &http.Cookie{Name: "magic_link", Value: "other browser"}).Code)
+	assert.Equal(t, http.StatusBadRequest, redeem("wrong", browser).Code)
+
+	rec := redeem(secret, browser)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	session := cookiesByName(rec)
+	assert.NotEmpty(t, session["token"].Value, "the same session cookies as Login")
+	assert.NotEmpty(t, session["refresh_token"].Value)
+
+	req := httptest.NewRequest("GET", "/api/v1/me", nil)
+	req.AddCookie(session["token"])
+	me := httptest.NewRecorder()
+	sut.ServeHTTP(me, req)
+	assert.Equal(t, http.StatusOK, me.Code)
+
+	// The link proves the address
+	var verified bool
+	err = conn.QueryRow(context.Background(), `SELECT email_verified FROM users WHERE id = 1`).Scan(&verified)
+	assert.NoError(t, err)
+	assert.True(t, verified)
+
+	// Links are single use
+	assert.Equal(t, http.StatusBadRequest, redeem(secret, browser).Code)
+
+	// A browser keeps its cookie across requests
+	secret, again := request(browser)
+	assert.Equal(t, browser.Value, again.Value)
+
+	// Links expire
+	a.Clock = func() time.Time { return time.Now().Add(a.Config.Auth.MagicLinkTTL + time.Minute) }
+	assert.Equal(t, http.StatusBadRequest, redeem(secret, browser).Code)
+	a.Clock = time.Now
+
+	// Requests are limited per address
+	request(browser)
+	rec = post("/api/v1/auth/magic-link", auth.MagicLinkRequest{Email: "test@example.com"}, browser)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// and per client IP
+	a.Config.Auth.MagicLinkIPLimit = 4
+	rec = post("/api/v1/auth/magic-link", auth.MagicLinkRequest{Email: "other@example.com"})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// Without browser binding, links work anywhere
+	a.Config.Auth.MagicLinkBindBrowser = false
+	a.Clock = func() time.Time { return time.Now().Add(a.Config.Auth.MagicLinkWindow + time.Minute) }
+	secret, browser = request()
+	assert.Nil(t, browser)
+	assert.Equal(t, http.StatusOK, redeem(secret).Code)
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+)
+
+// mailTimeout bounds how long sending a mail may take once the request
+// that triggered it has been answered.
+const mailTimeout = 30 * time.Second
+
+// inBackground runs send after the request has been answered, so that slow
+// mail delivery neither delays the response nor reveals anything by timing.
+// Failures are logged as what.
+func inBackground(a *app.App, what string, send func(ctx context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := send(ctx); err != nil {
+			a.Logger.Error("failed to send "+what, "error", err)
+		}
+	}()
+}
+
+// frontendLink returns the frontend page path carrying secret as its token.
+func frontendLink(a *app.App, path, secret string) string {
+	return strings.TrimSuffix(a.Config.Server.FrontendURL, "/") + path + "?token=" + url.QueryEscape(secret)
+}
@@ -0,0 +1,472 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/totp"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes a user gets at once.
+	recoveryCodeCount = 10
+	// maxMFAAttempts is how many wrong codes an MFA token survives.
+	maxMFAAttempts = 5
+)
+
+// grantedRoles returns the roles session may act with. Roles that require
+// MFA are withheld from sessions started without a second factor.
+func grantedRoles(ctx context.Context, a *app.App, session db.Session, user db.User) ([]string, error) {
+	if session.MfaVerified {
+		return user.Roles, nil
+	}
+
+	required, err := a.Queries.GetMFARequiredRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(slices.Clone(user.Roles), func(role string) bool {
+		return slices.Contains(required, role)
+	}), nil
+}
+
+// hasTOTP reports whether the user has confirmed a TOTP secret.
+func hasTOTP(ctx context.Context, a *app.App, userID int32) (bool, error) {
+	credential, err := a.Queries.GetTOTPCredential(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return credential.ConfirmedAt.Valid, nil
+}
+
+// startMFAChallenge answers a login whose password was correct with an MFA
+// token instead of a session.
+func startMFAChallenge(w http.ResponseWriter, r *http.Request, a *app.App, user db.User) {
+	secret, err := newOpaqueToken()
+	if err != nil {
+		problem.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := a.Clock().Add(a.Config.Auth.MFAChallengeTTL)
+	err = a.Queries.CreateMFAChallenge(r.Context(), db.CreateMFAChallengeParams{
+		TokenHash: hashToken(secret),
+		UserID:    user.ID,
+		ExpiresAt: timestamp(expiresAt),
+	})
+	if err != nil {
+		a.Logger.Error("failed to create MFA challenge", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    secret,
+		ExpiresAt:   expiresAt.UTC(),
+	})
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code
+// of the user. Either is used up by a successful check.
+func verifySecondFactor(ctx context.Context, a *app.App, userID int32, req SecondFactorRequest) (bool, error) {
+	now := a.Clock()
+
+	if req.Code != "" {
+		credential, err := a.Queries.GetTOTPCredential(ctx, userID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return false, nil
+			}
+			return false, err
+		}
+		if !credential.ConfirmedAt.Valid {
+			return false, nil
+		}
+
+		step, ok := totp.Validate(credential.Secret, req.Code, now)
+		if !ok {
+			return false, nil
+		}
+		used, err := a.Queries.UseTOTPStep(ctx, db.UseTOTPStepParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		return used == 1, err
+	}
+
+	if req.RecoveryCode != "" {
+		used, err := a.Queries.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(req.RecoveryCode)),
+			UsedAt:   timestamp(now),
+		})
+		return used == 1, err
+	}
+
+	return false, nil
+}
+
+// LoginMFA returns the handler that completes a login started by Login with
+// the MFA token and a TOTP or recovery code. The token is discarded after
+// maxMFAAttempts wrong codes.
+func LoginMFA(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		hash := hashToken(req.MFAToken)
+
+		challenge, err := a.Queries.GetMFAChallenge(r.Context(), hash)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			a.Logger.Error("failed to load MFA challenge", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !a.Clock().Before(challenge.ExpiresAt.Time) {
+			discardMFAChallenge(r.Context(), a, hash)
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), challenge.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		// Wrong codes count as failed logins, so that asking for new MFA
+		// tokens does not give unlimited guesses.
+		ip := ClientIP(r, a.Config.Server.TrustProxyHeaders)
+		keys := []loginKey{userLoginKey(a, user.Name), ipLoginKey(a, ip)}
+		wait, err := loginRetryAfter(r.Context(), a, keys...)
+		if err != nil {
+			a.Logger.Error("failed to check login throttling", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+
+		ok, err := verifySecondFactor(r.Context(), a, challenge.UserID, SecondFactorRequest{
+			Code:         req.Code,
+			RecoveryCode: req.RecoveryCode,
+		})
+		if err != nil {
+			a.Logger.Error("failed to verify second factor", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			attempts, err := a.Queries.CountMFAChallengeAttempt(r.Context(), hash)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				a.Logger.Error("failed to count MFA attempt", "error", err)
+			}
+			if attempts >= maxMFAAttempts {
+				discardMFAChallenge(r.Context(), a, hash)
+			}
+			recordLoginFailure(r.Context(), a, ip, keys...)
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		discardMFAChallenge(r.Context(), a, hash)
+
+		if err := ClearLoginFailures(r.Context(), a, user.Name); err != nil {
+			a.Logger.Error("failed to clear login failures", "error", err)
+		}
+
+		if err := startSession(w, r, a, user, true); err != nil {
+			a.Logger.Error("failed to start session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func discardMFAChallenge(ctx context.Context, a *app.App, hash string) {
+	if err := a.Queries.DeleteMFAChallenge(ctx, hash); err != nil {
+		a.Logger.Error("failed to delete MFA challenge", "error", err)
+	}
+}
+
+// sessionPrincipal returns the caller if they are signed in with a session.
+// Changing second factors with a personal access token is refused, as a
+// leaked token must not be able to lock the owner out. So is everything an
+// admin might try while impersonating the user.
+func sessionPrincipal(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok {
+		problem.Error(w, "", http.StatusUnauthorized)
+		return Principal{}, false
+	}
+	if p.Impersonated() {
+		problem.Error(w, errImpersonationDenied, http.StatusForbidden)
+		return Principal{}, false
+	}
+	if p.SessionID == "" {
+		problem.Error(w, "Forbidden", http.StatusForbidden)
+		return Principal{}, false
+	}
+	return p, true
+}
+
+// SetupTOTP returns the handler that generates a new TOTP secret for the
+// signed-in user. It has no effect until confirmed with ConfirmTOTP.
+func SetupTOTP(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), p.UserID)
+		if err != nil {
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		created, err := a.Queries.CreateTOTPCredential(r.Context(), db.CreateTOTPCredentialParams{
+			UserID: user.ID,
+			Secret: secret,
+		})
+		if err != nil {
+			a.Logger.Error("failed to create TOTP credential", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if created == 0 {
+			problem.Error(w, "TOTP is already enabled", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(TOTPSetupResponse{
+			Secret: secret,
+			URI:    totp.URI(a.Config.Auth.MFAIssuer, user.Email, secret),
+		})
+	}
+}
+
+// ConfirmTOTP returns the handler that enables the secret from SetupTOTP
+// once the user enters a code from it, and hands out recovery codes.
+func ConfirmTOTP(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		var req SecondFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		credential, err := a.Queries.GetTOTPCredential(r.Context(), p.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "TOTP setup has not been started", http.StatusConflict)
+				return
+			}
+			a.Logger.Error("failed to load TOTP credential", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if credential.ConfirmedAt.Valid {
+			problem.Error(w, "TOTP is already enabled", http.StatusConflict)
+			return
+		}
+
+		now := a.Clock()
+		step, ok := totp.Validate(credential.Secret, req.Code, now)
+		if !ok {
+			problem.Error(w, "Invalid code", http.StatusBadRequest)
+			return
+		}
+
+		confirmed, err := a.Queries.ConfirmTOTPCredential(r.Context(), db.ConfirmTOTPCredentialParams{
+			UserID:       p.UserID,
+			ConfirmedAt:  timestamp(now),
+			LastUsedStep: step,
+		})
+		if err != nil {
+			a.Logger.Error("failed to confirm TOTP credential", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if confirmed == 0 {
+			problem.Error(w, "TOTP is already enabled", http.StatusConflict)
+			return
+		}
+
+		codes, err := replaceRecoveryCodes(r.Context(), a, p.UserID)
+		if err != nil {
+			a.Logger.Error("failed to create recovery codes", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableTOTP returns the handler that removes the user's TOTP secret and
+// recovery codes. It requires a current code or a recovery code.
+func DisableTOTP(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		var req SecondFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		ok, err := verifySecondFactor(r.Context(), a, p.UserID, req)
+		if err != nil {
+			a.Logger.Error("failed to verify second factor", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			problem.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if err := a.Queries.DeleteTOTPCredential(r.Context(), p.UserID); err != nil {
+			a.Logger.Error("failed to delete TOTP credential", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if err := a.Queries.DeleteRecoveryCodes(r.Context(), p.UserID); err != nil {
+			a.Logger.Error("failed to delete recovery codes", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and returns
+// recoveryCodeCount new ones. Only their hashes are stored.
+func replaceRecoveryCodes(ctx context.Context, a *app.App, userID int32) ([]string, error) {
+	if err := a.Queries.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		err = a.Queries.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(code)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns 80 random bits as four dash separated groups,
+// e.g. "abcd-efgh-ijkl-mnop".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// normalizeRecoveryCode makes recovery codes match however they are typed.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// GetMFAPolicy returns the handler that lists the roles requiring MFA.
+func GetMFAPolicy(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles, err := a.Queries.GetMFARequiredRoles(r.Context())
+		if err != nil {
+			a.Logger.Error("failed to load MFA policy", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFAPolicy{RequiredRoles: append([]string{}, roles...)})
+	}
+}
+
+// UpdateMFAPolicy returns the handler that replaces the roles requiring MFA.
+// Sessions started without a second factor lose those roles when their
+// access token is next refreshed.
+func UpdateMFAPolicy(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MFAPolicy
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		for _, role := range req.RequiredRoles {
+			if !ValidRole(role) {
+				problem.Error(w, "Unknown role "+role, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := a.Queries.SetMFARequiredRoles(r.Context(), append([]string{}, req.RequiredRoles...)); err != nil {
+			a.Logger.Error("failed to update MFA policy", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		GetMFAPolicy(a)(w, r)
+	}
+}
@@ -0,0 +1,174 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/Modul-306/backend/totp"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFA(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	now := time.Now()
+	a.Clock = func() time.Time { return now }
+	// nextPeriod moves the clock on so that a fresh TOTP code is accepted.
+	nextPeriod := func() { now = now.Add(totp.Period) }
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("adminpass")
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email, roles)
+        VALUES ($1, $2, $3, '{customer,admin}'), ($4, $2, $5, '{customer,admin}')
+    `, "admin", hashedPassword, "admin@example.com", "otheradmin", "other@example.com")
+	if err != nil {
+		t.Fatalf("failed to create test users: %v", err)
+	}
+
+	do := func(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(username string) *httptest.ResponseRecorder {
+		rec := do("POST", "/api/v1/auth/login", auth.Credentials{Username: username, Password: "adminpass"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+	challenge := func() string {
+		rec := login("admin")
+		var response auth.MFAChallengeResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.True(t, response.MFARequired)
+		assert.Empty(t, rec.Result().Cookies(), "no session before the second factor")
+		return response.MFAToken
+	}
+
+	session := cookiesByName(login("admin"))["token"]
+
+	// Enroll
+	rec := do("POST", "/api/v1/auth/mfa/totp", nil, session)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var setup auth.TOTPSetupResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&setup))
+	assert.Contains(t, setup.URI, "otpauth://totp/")
+
+	code := func() string {
+		c, err := totp.Code(setup.Secret, now)
+		assert.NoError(t, err)
+		return c
+	}
+
+	rec = do("POST", "/api/v1/auth/mfa/totp/confirm", auth.SecondFactorRequest{Code: "000000"}, session)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do("POST", "/api/v1/auth/mfa/totp/confirm", auth.SecondFactorRequest{Code: code()}, session)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var recovery auth.RecoveryCodesResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&recovery))
+	assert.Len(t, recovery.RecoveryCodes, 10)
+
+	// The code used to confirm cannot be replayed
+	mfaToken := challenge()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: code()})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	nextPeriod()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: code()})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	mfaSession := cookiesByName(rec)["token"]
+	if !assert.NotNil(t, mfaSession) {
+		t.FailNow()
+	}
+
+	// MFA tokens work once
+	nextPeriod()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: code()})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Recovery codes work once, however they are typed
+	mfaToken = challenge()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, RecoveryCode: strings.ToUpper(recovery.RecoveryCodes[0])})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	mfaToken = challenge()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, RecoveryCode: recovery.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Too many wrong codes discard the MFA token
+	for range 5 {
+		rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: "000000"})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	nextPeriod()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: code()})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// MFA tokens expire
+	mfaToken = challenge()
+	now = now.Add(a.Config.Auth.MFAChallengeTTL)
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: code()})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Require MFA for admins
+	mfaSession = testhelpers.SessionCookie(t, a, 1, "customer", "admin")
+	rec = do("UPDATE", "/api/v1/auth/mfa/policy", auth.MFAPolicy{RequiredRoles: []string{"admin"}}, mfaSession)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do("UPDATE", "/api/v1/auth/mfa/policy", auth.MFAPolicy{RequiredRoles: []string{"root"}}, mfaSession)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// An admin without a second factor is signed in as a customer only
+	other := cookiesByName(login("otheradmin"))["token"]
+	rec = do("GET", "/api/v1/auth/mfa/policy", nil, other)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// The enrolled admin keeps the role after MFA
+	mfaToken = challenge()
+	nextPeriod()
+	rec = do("POST", "/api/v1/auth/login/mfa", auth.MFALoginRequest{MFAToken: mfaToken, Code: code()})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do("GET", "/api/v1/auth/mfa/policy", nil, cookiesByName(rec)["token"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var policy auth.MFAPolicy
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&policy))
+	assert.Equal(t, []string{"admin"}, policy.RequiredRoles)
+
+	// Disabling needs a valid second factor
+	session = testhelpers.SessionCookie(t, a, 1, "customer")
+	rec = do("DELETE", "/api/v1/auth/mfa/totp", auth.SecondFactorRequest{Code: "000000"}, session)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do("DELETE", "/api/v1/auth/mfa/totp", auth.SecondFactorRequest{RecoveryCode: recovery.RecoveryCodes[1]}, session)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, login("admin").Body.String(), "login no longer asks for a second factor")
+}
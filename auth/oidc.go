@@ -0,0 +1,464 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/oidc"
+	"github.com/Modul-306/backend/problem"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// oidcCookie binds a login at a provider to the browser that started
+	// it, so that a code and state cannot be replayed in another one.
+	oidcCookie     = "oidc_state"
+	oidcCookiePath = "/api/v1/auth/oidc"
+)
+
+// Codes of 409 Conflict responses for linked identities.
+const (
+	CodeIdentityTaken  = "identity_taken"
+	CodeProviderLinked = "provider_linked"
+)
+
+// maxGeneratedNameLength bounds user names taken from a provider.
+const maxGeneratedNameLength = 64
+
+// ListOIDCProviders returns the handler that lists the names of the
+// providers users can sign in with.
+func ListOIDCProviders(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names := slices.Sorted(maps.Keys(a.OIDC))
+		if names == nil {
+			names = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OIDCProvidersResponse{Providers: names})
+	}
+}
+
+// StartOIDCLogin returns the handler that begins signing in with the
+// provider named in the URL. It answers with the URL to send the user to;
+// the frontend page the provider sends them back to passes the code on to
+// OIDCCallback.
+func StartOIDCLogin(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startOIDC(w, r, a, pgtype.Int4{})
+	}
+}
+
+// LinkIdentity returns the handler that begins linking the provider named
+// in the URL to the signed-in user's account. It works like StartOIDCLogin,
+// after which OIDCCallback links the identity instead of signing in.
+func LinkIdentity(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+		startOIDC(w, r, a, pgtype.Int4{Int32: p.UserID, Valid: true})
+	}
+}
+
+func startOIDC(w http.ResponseWriter, r *http.Request, a *app.App, userID pgtype.Int4) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := a.OIDC[name]
+	if !ok {
+		problem.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		var err error
+		if secrets[i], err = oidc.NewSecret(); err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		a.Logger.Error("failed to reach OpenID Connect provider", "provider", name, "error", err)
+		problem.Error(w, "The provider is not available", http.StatusBadGateway)
+		return
+	}
+
+	// A browser keeps its cookie, so that logins started in two tabs both
+	// work.
+	browser := ""
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		browser = c.Value
+	}
+	if browser == "" {
+		if browser, err = newOpaqueToken(); err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	now := a.Clock()
+	if err := a.Queries.DeleteOIDCStatesBefore(r.Context(), timestamp(now)); err != nil {
+		a.Logger.Error("failed to delete expired OpenID Connect states", "error", err)
+	}
+	err = a.Queries.CreateOIDCState(r.Context(), db.CreateOIDCStateParams{
+		StateHash:    hashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BrowserHash:  hashToken(browser),
+		UserID:       userID,
+		ExpiresAt:    timestamp(now.Add(a.Config.Auth.OIDCStateTTL)),
+	})
+	if err != nil {
+		a.Logger.Error("failed to store OpenID Connect state", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, authCookie(a, oidcCookie, browser, oidcCookiePath, now.Add(a.Config.Auth.OIDCStateTTL)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OIDCStartResponse{AuthorizationURL: authURL})
+}
+
+// OIDCCallback returns the handler that completes a login started by
+// StartOIDCLogin or LinkIdentity with the code and state the provider sent
+// back.
+//
+// A login signs in to the account the identity is linked to. An identity
+// that is not linked yet is linked to the account with its address if both
+// the provider and this service have verified the address, and gets a new
+// account if there is none. Users with a second factor are asked for it,
+// as with Login.
+func OIDCCallback(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["provider"]
+		provider, ok := a.OIDC[name]
+		if !ok {
+			problem.Error(w, "Unknown provider", http.StatusNotFound)
+			return
+		}
+
+		var req OIDCCallbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.State == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		var browserHash string
+		if c, err := r.Cookie(oidcCookie); err == nil && c.Value != "" {
+			browserHash = hashToken(c.Value)
+		}
+
+		state, err := a.Queries.UseOIDCState(r.Context(), db.UseOIDCStateParams{
+			StateHash:   hashToken(req.State),
+			Provider:    name,
+			BrowserHash: browserHash,
+			ExpiresAt:   timestamp(a.Clock()),
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Invalid or expired login", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to use OpenID Connect state", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		clearOIDCCookie(w, a)
+
+		id, err := provider.Exchange(r.Context(), req.Code, state.CodeVerifier, state.Nonce)
+		if err != nil {
+			if errors.Is(err, oidc.ErrInvalidIDToken) || errors.Is(err, oidc.ErrCodeRejected) {
+				a.Logger.Warn("rejected OpenID Connect login", "provider", name, "error", err)
+				problem.Error(w, "Sign-in with the provider failed", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to reach OpenID Connect provider", "provider", name, "error", err)
+			problem.Error(w, "The provider is not available", http.StatusBadGateway)
+			return
+		}
+
+		if state.UserID.Valid {
+			user, err := a.Queries.GetUser(r.Context(), state.UserID.Int32)
+			if err != nil {
+				a.Logger.Error("failed to load user", "error", err)
+				problem.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			if !addIdentity(w, r, a, name, id, user) {
+				return
+			}
+			sendIdentityNotice(a, user, name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		user, created, ok := identityUser(w, r, a, name, id)
+		if !ok {
+			return
+		}
+
+		enrolled, err := hasTOTP(r.Context(), a, user.ID)
+		if err != nil {
+			a.Logger.Error("failed to look up second factor", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if enrolled {
+			startMFAChallenge(w, r, a, user)
+			return
+		}
+
+		if err := startSession(w, r, a, user, false); err != nil {
+			a.Logger.Error("failed to start session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}
+}
+
+// identityUser returns the account to sign in to with the identity id of
+// provider, linking or creating one if needed. created reports whether the
+// account is new.
+func identityUser(w http.ResponseWriter, r *http.Request, a *app.App, provider string, id *oidc.IDToken) (user db.User, created, ok bool) {
+	identity, err := a.Queries.GetUserIdentity(r.Context(), db.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  id.Subject,
+	})
+	switch {
+	case err == nil:
+		user, err := a.Queries.GetUser(r.Context(), identity.UserID)
+		if err != nil {
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return db.User{}, false, false
+		}
+		return user, false, true
+	case !errors.Is(err, pgx.ErrNoRows):
+		a.Logger.Error("failed to look up identity", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return db.User{}, false, false
+	}
+
+	email := NormalizeEmail(id.Email)
+	if email == "" {
+		problem.Error(w, "The provider did not share an email address", http.StatusBadRequest)
+		return db.User{}, false, false
+	}
+
+	user, err = a.Queries.GetUserByEmail(r.Context(), email)
+	switch {
+	case err == nil:
+		// Unless both sides checked the address, whoever registered it
+		// first at one of them could take over the account at the other.
+		if !id.EmailVerified || !user.EmailVerified {
+			conflict(w, CodeEmailTaken, "Email is already in use; sign in and link the provider from your account")
+			return db.User{}, false, false
+		}
+	case errors.Is(err, pgx.ErrNoRows):
+		if user, ok = createIdentityUser(w, r, a, id, email); !ok {
+			return db.User{}, false, false
+		}
+		created = true
+	default:
+		a.Logger.Error("failed to look up user", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return db.User{}, false, false
+	}
+
+	if !addIdentity(w, r, a, provider, id, user) {
+		return db.User{}, false, false
+	}
+	if !created {
+		sendIdentityNotice(a, user, provider)
+	}
+	return user, created, true
+}
+
+// createIdentityUser creates the account of a new user of a provider. It
+// has no password, so it is only reachable through a provider or a sign-in
+// link until the user sets one by resetting it. The address counts as
+// verified if the provider says so.
+func createIdentityUser(w http.ResponseWriter, r *http.Request, a *app.App, id *oidc.IDToken, email string) (db.User, bool) {
+	base := identityUserName(id, email)
+	name := base
+	for attempt := 1; ; attempt++ {
+		var user db.User
+		var err error
+		if id.EmailVerified {
+			user, err = a.Queries.CreateVerifiedUser(r.Context(), db.CreateVerifiedUserParams{Name: name, Email: email})
+		} else {
+			user, err = a.Queries.CreateUser(r.Context(), db.CreateUserParams{Name: name, Email: email})
+		}
+		if err == nil {
+			if !user.EmailVerified {
+				if err := StartEmailVerification(r.Context(), a, user); err != nil {
+					a.Logger.Error("failed to start email verification", "error", err)
+				}
+			}
+			return user, true
+		}
+
+		// Names are not important enough to ask for, so a taken one just
+		// gets a number.
+		if db.UniqueViolation(err) == db.UsersNameKey && attempt < 5 {
+			name = fmt.Sprintf("%s-%d", base, rand.IntN(10000))
+			continue
+		}
+		if UserConflict(w, err) {
+			return db.User{}, false
+		}
+		a.Logger.Error("failed to create user", "error", err)
+		problem.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return db.User{}, false
+	}
+}
+
+// identityUserName proposes a user name for the account of id.
+func identityUserName(id *oidc.IDToken, email string) string {
+	name := strings.TrimSpace(id.PreferredUsername)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if name == "" {
+		name = "user"
+	}
+	if runes := []rune(name); len(runes) > maxGeneratedNameLength {
+		name = string(runes[:maxGeneratedNameLength])
+	}
+	return name
+}
+
+// addIdentity links the identity id of provider to user. It answers 409 Conflict if the identity belongs to another account
+// or the account already has one of the provider; linking the same identity
+// again is not an error.
+func addIdentity(w http.ResponseWriter, r *http.Request, a *app.App, provider string, id *oidc.IDToken, user db.User) bool {
+	_, err := a.Queries.CreateUserIdentity(r.Context(), db.CreateUserIdentityParams{
+		Provider:  provider,
+		Subject:   id.Subject,
+		UserID:    user.ID,
+		Email:     NormalizeEmail(id.Email),
+		CreatedAt: timestamp(a.Clock()),
+	})
+	switch db.UniqueViolation(err) {
+	case db.UserIdentitiesPkey:
+		identity, err := a.Queries.GetUserIdentity(r.Context(), db.GetUserIdentityParams{
+			Provider: provider,
+			Subject:  id.Subject,
+		})
+		if err == nil && identity.UserID == user.ID {
+			return true
+		}
+		conflict(w, CodeIdentityTaken, "This account of the provider is linked to another user")
+		return false
+	case db.UserIdentitiesUserIDProviderKey:
+		conflict(w, CodeProviderLinked, "Another account of this provider is already linked")
+		return false
+	}
+	if err != nil {
+		a.Logger.Error("failed to link identity", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// sendIdentityNotice tells user that provider can now be used to sign in to
+// their account.
+func sendIdentityNotice(a *app.App, user db.User, provider string) {
+	inBackground(a, "identity notice", func(ctx context.Context) error {
+		return a.Mailer.Send(ctx, mail.Message{
+			To:      user.Email,
+			Subject: "A sign-in provider was linked to your account",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"You can now sign in with %s. If this was not you, unlink it from your account and change your password.\n",
+				user.Name, provider),
+		})
+	})
+}
+
+func clearOIDCCookie(w http.ResponseWriter, a *app.App) {
+	c := authCookie(a, oidcCookie, "", oidcCookiePath, time.Unix(0, 0))
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+// GetIdentities returns the handler that lists the providers linked to the
+// caller's account.
+func GetIdentities(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		identities, err := a.Queries.GetUserIdentities(r.Context(), p.UserID)
+		if err != nil {
+			a.Logger.Error("failed to load identities", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]IdentityResponse, len(identities))
+		for i, identity := range identities {
+			response[i] = IdentityResponse{
+				Provider:  identity.Provider,
+				Email:     identity.Email,
+				CreatedAt: identity.CreatedAt.Time,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// UnlinkIdentity returns the handler that unlinks the provider named in the
+// URL from the signed-in user's account. Users without a password can still
+// sign in with a sign-in link.
+func UnlinkIdentity(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		deleted, err := a.Queries.DeleteUserIdentity(r.Context(), db.DeleteUserIdentityParams{
+			UserID:   p.UserID,
+			Provider: mux.Vars(r)["provider"],
+		})
+		if err != nil {
+			a.Logger.Error("failed to unlink identity", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			problem.Error(w, "Provider is not linked", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,235 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/config"
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/oidc"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/oidcmock"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/golang-jwt/jwt"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDC(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	mock := oidcmock.New(t)
+	a.OIDC = map[string]*oidc.Provider{
+		"mock": app.NewOIDCProvider(a.Config.Server, config.OIDCProviderConfig{
+			Name:         "mock",
+			Issuer:       mock.Issuer(),
+			ClientID:     mock.ClientID,
+			ClientSecret: mock.ClientSecret,
+		}, func() time.Time { return a.Clock() }),
+	}
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("old password")
+	for _, u := range []struct {
+		name, email string
+		verified    bool
+	}{{"testuser", "test@example.com", true}, {"unverified", "unverified@example.com", false}} {
+		_, err = conn.Exec(context.Background(), `
+            INSERT INTO users (name, password, email, email_verified)
+            VALUES ($1, $2, $3, $4)
+        `, u.name, hashedPassword, u.email, u.verified)
+		if err != nil {
+			t.Fatalf("failed to create test user: %v", err)
+		}
+	}
+
+	do := func(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			if c != nil {
+				req.AddCookie(c)
+			}
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	// authorize starts a login or link at path, signs in at the provider
+	// and returns the code and state it sends back, with the cookie of the
+	// browser that started it.
+	authorize := func(path string, cookies ...*http.Cookie) (auth.OIDCCallbackRequest, *http.Cookie) {
+		rec := do("POST", path, nil, cookies...)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("failed to start login: %d %s", rec.Code, rec.Body.String())
+		}
+		var start auth.OIDCStartResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&start))
+
+		code, state, err := mock.Authorize(start.AuthorizationURL)
+		if err != nil {
+			t.Fatalf("failed to sign in at provider: %v", err)
+		}
+		return auth.OIDCCallbackRequest{Code: code, State: state}, cookiesByName(rec)["oidc_state"]
+	}
+	callback := func(req auth.OIDCCallbackRequest, browser *http.Cookie) *httptest.ResponseRecorder {
+		return do("POST", "/api/v1/auth/oidc/mock/callback", req, browser)
+	}
+	me := func(session map[string]*http.Cookie) handlers.UserResponse {
+		rec := do("GET", "/api/v1/me", nil, session["token"])
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var user handlers.UserResponse
+		json.NewDecoder(rec.Body).Decode(&user)
+		return user
+	}
+	conflictCode := func(rec *httptest.ResponseRecorder) string {
+		var response problem.Problem
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response.Code
+	}
+
+	rec := do("GET", "/api/v1/auth/oidc", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"providers": ["mock"]}`, rec.Body.String())
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/auth/oidc/unknown", nil).Code)
+
+	t.Run("new users get an account", func(t *testing.T) {
+		mock.SignInAs(oidcmock.User{Subject: "carol-1", Email: "Carol@Example.com", EmailVerified: true})
+		req, browser := authorize("/api/v1/auth/oidc/mock")
+		if browser == nil {
+			t.Fatal("no browser cookie set")
+		}
+
+		rec := callback(req, browser)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		user := me(cookiesByName(rec))
+		assert.Equal(t, "carol", user.Name)
+		assert.Equal(t, "carol@example.com", user.Email)
+		assert.True(t, user.EmailVerified, "the provider verified the address")
+
+		// A code and state work once
+		assert.Equal(t, http.StatusBadRequest, callback(req, browser).Code)
+
+		// and sign in to the same account afterwards
+		req, browser = authorize("/api/v1/auth/oidc/mock")
+		rec = callback(req, browser)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, user.ID, me(cookiesByName(rec)).ID)
+	})
+
+	t.Run("logins only complete in the browser that started them", func(t *testing.T) {
+		req, _ := authorize("/api/v1/auth/oidc/mock")
+		assert.Equal(t, http.StatusBadRequest, callback(req, nil).Code)
+		assert.Equal(t, http.StatusBadRequest, callback(req, &http.Cookie{Name: "oidc_state", Value: "attacker"}).Code)
+	})
+
+	t.Run("logins expire", func(t *testing.T) {
+		req, browser := authorize("/api/v1/auth/oidc/mock")
+		a.Clock = func() time.Time { return time.Now().Add(a.Config.Auth.OIDCStateTTL + time.Minute) }
+		defer func() { a.Clock = time.Now }()
+		assert.Equal(t, http.StatusBadRequest, callback(req, browser).Code)
+	})
+
+	t.Run("invalid ID tokens are refused", func(t *testing.T) {
+		mock.Tamper = func(claims jwt.MapClaims) { claims["aud"] = "other-client" }
+		defer func() { mock.Tamper = nil }()
+		req, browser := authorize("/api/v1/auth/oidc/mock")
+		assert.Equal(t, http.StatusBadRequest, callback(req, browser).Code)
+	})
+
+	t.Run("verified addresses link to existing accounts", func(t *testing.T) {
+		mock.SignInAs(oidcmock.User{Subject: "test-1", Email: "test@example.com", EmailVerified: true})
+		req, browser := authorize("/api/v1/auth/oidc/mock")
+		rec := callback(req, browser)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		session := cookiesByName(rec)
+		assert.Equal(t, "testuser", me(session).Name)
+
+		rec = do("GET", "/api/v1/me/identities", nil, session["token"])
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var identities []auth.IdentityResponse
+		json.NewDecoder(rec.Body).Decode(&identities)
+		if assert.Len(t, identities, 1) {
+			assert.Equal(t, "mock", identities[0].Provider)
+			assert.Equal(t, "test@example.com", identities[0].Email)
+		}
+	})
+
+	t.Run("unverified addresses do not", func(t *testing.T) {
+		mock.SignInAs(oidcmock.User{Subject: "unverified-1", Email: "unverified@example.com", EmailVerified: true})
+		req, browser := authorize("/api/v1/auth/oidc/mock")
+		rec := callback(req, browser)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, auth.CodeEmailTaken, conflictCode(rec))
+
+		mock.SignInAs(oidcmock.User{Subject: "test-2", Email: "test@example.com", EmailVerified: false})
+		req, browser = authorize("/api/v1/auth/oidc/mock")
+		rec = callback(req, browser)
+		assert.Equal(t, http.StatusConflict, rec.Code, "the provider did not verify the address")
+	})
+
+	t.Run("users link and unlink providers", func(t *testing.T) {
+		session := testhelpers.SessionCookie(t, a, 2)
+
+		// An identity belongs to one account
+		mock.SignInAs(oidcmock.User{Subject: "carol-1", Email: "carol@example.com", EmailVerified: true})
+		req, browser := authorize("/api/v1/me/identities/mock", session)
+		rec := callback(req, browser)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, auth.CodeIdentityTaken, conflictCode(rec))
+
+		// The address of the identity does not need to match
+		mock.SignInAs(oidcmock.User{Subject: "unverified-1", Email: "someone@example.com"})
+		req, browser = authorize("/api/v1/me/identities/mock", session)
+		assert.Equal(t, http.StatusNoContent, callback(req, browser).Code)
+
+		// An account has one identity per provider
+		mock.SignInAs(oidcmock.User{Subject: "unverified-2", Email: "someone@example.com"})
+		req, browser = authorize("/api/v1/me/identities/mock", session)
+		rec = callback(req, browser)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, auth.CodeProviderLinked, conflictCode(rec))
+
+		// The linked identity signs in to the account
+		mock.SignInAs(oidcmock.User{Subject: "unverified-1"})
+		req, browser = authorize("/api/v1/auth/oidc/mock")
+		rec = callback(req, browser)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "unverified", me(cookiesByName(rec)).Name)
+
+		assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/me/identities/mock", nil, session).Code)
+		assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/me/identities/mock", nil, session).Code)
+
+		// Unlinked identities no longer sign in, and without an address
+		// no account can be created for them
+		req, browser = authorize("/api/v1/auth/oidc/mock")
+		assert.Equal(t, http.StatusBadRequest, callback(req, browser).Code)
+	})
+}
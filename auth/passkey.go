@@ -0,0 +1,384 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/audit"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/webauthn"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Ceremonies a WebAuthn challenge is issued for.
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+// CodePasskeyRegistered is the code of the 409 Conflict response to
+// registering a passkey that is already registered.
+const CodePasskeyRegistered = "passkey_registered"
+
+const (
+	defaultPasskeyName   = "Passkey"
+	maxPasskeyNameLength = 255
+)
+
+// userHandle is the WebAuthn user handle of the user with id, which
+// passkeys hand back at login.
+func userHandle(id int32) []byte {
+	return []byte(strconv.Itoa(int(id)))
+}
+
+// newWebAuthnChallenge stores a challenge for ceremony, bound to userID if
+// it is valid.
+func newWebAuthnChallenge(ctx context.Context, a *app.App, ceremony string, userID pgtype.Int4) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.Clock()
+	if err := a.Queries.DeleteWebAuthnChallengesBefore(ctx, timestamp(now)); err != nil {
+		a.Logger.Error("failed to delete expired WebAuthn challenges", "error", err)
+	}
+	err = a.Queries.CreateWebAuthnChallenge(ctx, db.CreateWebAuthnChallengeParams{
+		ChallengeHash: hashChallenge(challenge),
+		UserID:        userID,
+		Ceremony:      ceremony,
+		ExpiresAt:     timestamp(now.Add(a.Config.Auth.WebAuthnTimeout)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// useWebAuthnChallenge consumes the challenge of ceremony that
+// clientDataJSON answers. It answers the request and returns false if there
+// is none.
+func useWebAuthnChallenge(w http.ResponseWriter, r *http.Request, a *app.App, ceremony string, clientDataJSON []byte) (db.WebauthnChallenge, []byte, bool) {
+	challenge, err := webauthn.ResponseChallenge(clientDataJSON)
+	if err != nil {
+		problem.Error(w, "", http.StatusBadRequest)
+		return db.WebauthnChallenge{}, nil, false
+	}
+
+	stored, err := a.Queries.UseWebAuthnChallenge(r.Context(), db.UseWebAuthnChallengeParams{
+		ChallengeHash: hashChallenge(challenge),
+		Ceremony:      ceremony,
+		ExpiresAt:     timestamp(a.Clock()),
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			problem.Error(w, "Invalid or expired passkey challenge", http.StatusBadRequest)
+			return db.WebauthnChallenge{}, nil, false
+		}
+		a.Logger.Error("failed to use WebAuthn challenge", "error", err)
+		problem.Error(w, "", http.StatusInternalServerError)
+		return db.WebauthnChallenge{}, nil, false
+	}
+	return stored, challenge, true
+}
+
+func hashChallenge(challenge []byte) string {
+	return hashToken(base64.RawURLEncoding.EncodeToString(challenge))
+}
+
+// BeginPasskeyRegistration returns the handler that starts registering a
+// passkey for the signed-in user. The frontend passes the options to
+// navigator.credentials.create() and the result to
+// FinishPasskeyRegistration.
+func BeginPasskeyRegistration(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := sessionUser(w, r, a)
+		if !ok {
+			return
+		}
+
+		credentials, err := a.Queries.GetUserWebAuthnCredentials(r.Context(), user.ID)
+		if err != nil {
+			a.Logger.Error("failed to load passkeys", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		exclude := make([][]byte, len(credentials))
+		for i, c := range credentials {
+			exclude[i] = c.ID
+		}
+
+		challenge, err := newWebAuthnChallenge(r.Context(), a, ceremonyRegistration, pgtype.Int4{Int32: user.ID, Valid: true})
+		if err != nil {
+			a.Logger.Error("failed to create WebAuthn challenge", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		options := a.WebAuthn.CreationOptions(challenge, webauthn.User{
+			ID:          userHandle(user.ID),
+			Name:        user.Name,
+			DisplayName: user.Name,
+		}, exclude)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PasskeyCreationResponse{PublicKey: options})
+	}
+}
+
+// FinishPasskeyRegistration returns the handler that verifies and stores
+// the passkey created with the options from BeginPasskeyRegistration. Only
+// attestation "none" is accepted, so any authenticator that verifies the
+// user will do.
+func FinishPasskeyRegistration(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := sessionUser(w, r, a)
+		if !ok {
+			return
+		}
+
+		var req PasskeyRegistrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = defaultPasskeyName
+		}
+		if utf8.RuneCountInString(name) > maxPasskeyNameLength {
+			problem.Error(w, fmt.Sprintf("Name must be at most %d characters long", maxPasskeyNameLength), http.StatusBadRequest)
+			return
+		}
+
+		stored, challenge, ok := useWebAuthnChallenge(w, r, a, ceremonyRegistration, req.Credential.Response.ClientDataJSON)
+		if !ok {
+			return
+		}
+		if stored.UserID.Int32 != user.ID {
+			problem.Error(w, "Invalid or expired passkey challenge", http.StatusBadRequest)
+			return
+		}
+
+		credential, err := a.WebAuthn.VerifyRegistration(req.Credential, challenge)
+		if err != nil {
+			a.Logger.Warn("rejected passkey registration", "user_id", user.ID, "error", err)
+			problem.Error(w, "Invalid passkey", http.StatusBadRequest)
+			return
+		}
+
+		created, err := a.Queries.CreateWebAuthnCredential(r.Context(), db.CreateWebAuthnCredentialParams{
+			ID:        credential.ID,
+			UserID:    user.ID,
+			Name:      name,
+			PublicKey: credential.PublicKey,
+			SignCount: int64(credential.SignCount),
+			CreatedAt: timestamp(a.Clock()),
+		})
+		if db.UniqueViolation(err) == db.WebauthnCredentialsPkey {
+			conflict(w, CodePasskeyRegistered, "This passkey is already registered")
+			return
+		}
+		if err != nil {
+			a.Logger.Error("failed to store passkey", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		inBackground(a, "passkey notice", func(ctx context.Context) error {
+			return a.Mailer.Send(ctx, mail.Message{
+				To:      user.Email,
+				Subject: "A passkey was added to your account",
+				Body: fmt.Sprintf("Hello %s,\n\n"+
+					"The passkey %q can now be used to sign in. If this was not you, remove it from your account and change your password.\n",
+					user.Name, name),
+			})
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(passkeyResponse(created))
+	}
+}
+
+func passkeyResponse(c db.WebauthnCredential) PasskeyResponse {
+	response := PasskeyResponse{
+		ID:        base64.RawURLEncoding.EncodeToString(c.ID),
+		Name:      c.Name,
+		CreatedAt: c.CreatedAt.Time,
+	}
+	if c.LastUsedAt.Valid {
+		response.LastUsedAt = &c.LastUsedAt.Time
+	}
+	return response
+}
+
+// GetPasskeys returns the handler that lists the caller's passkeys.
+func GetPasskeys(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		credentials, err := a.Queries.GetUserWebAuthnCredentials(r.Context(), p.UserID)
+		if err != nil {
+			a.Logger.Error("failed to load passkeys", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]PasskeyResponse, len(credentials))
+		for i, c := range credentials {
+			response[i] = passkeyResponse(c)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// DeletePasskey returns the handler that removes the signed-in user's
+// passkey with the ID in the URL.
+func DeletePasskey(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		id, err := base64.RawURLEncoding.DecodeString(mux.Vars(r)["id"])
+		if err != nil {
+			problem.Error(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+
+		deleted, err := a.Queries.DeleteWebAuthnCredential(r.Context(), db.DeleteWebAuthnCredentialParams{
+			ID:     id,
+			UserID: p.UserID,
+		})
+		if err != nil {
+			a.Logger.Error("failed to delete passkey", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if deleted == 0 {
+			problem.Error(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// BeginPasskeyLogin returns the handler that starts signing in with a
+// passkey. No user name is needed: the user picks one of their passkeys
+// for this site, whose response names the account.
+func BeginPasskeyLogin(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := newWebAuthnChallenge(r.Context(), a, ceremonyLogin, pgtype.Int4{})
+		if err != nil {
+			a.Logger.Error("failed to create WebAuthn challenge", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PasskeyRequestResponse{PublicKey: a.WebAuthn.RequestOptions(challenge)})
+	}
+}
+
+// FinishPasskeyLogin returns the handler that signs in with the response of
+// navigator.credentials.get() to the options from BeginPasskeyLogin. It
+// sets the same session cookies as Login. Passkeys verify the user, e.g. by
+// fingerprint or PIN, on top of proving possession, so the session counts
+// as signed in with a second factor.
+//
+// A signature counter that does not increase means a copy of the passkey
+// is in use elsewhere. The login is refused and the event audited.
+func FinishPasskeyLogin(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp webauthn.AssertionResponse
+		if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		_, challenge, ok := useWebAuthnChallenge(w, r, a, ceremonyLogin, resp.Response.ClientDataJSON)
+		if !ok {
+			return
+		}
+
+		credential, err := a.Queries.GetWebAuthnCredential(r.Context(), resp.RawID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Unknown passkey", http.StatusUnauthorized)
+				return
+			}
+			a.Logger.Error("failed to load passkey", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if len(resp.Response.UserHandle) > 0 && string(resp.Response.UserHandle) != string(userHandle(credential.UserID)) {
+			problem.Error(w, "Unknown passkey", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), credential.UserID)
+		if err != nil {
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		signCount, err := a.WebAuthn.VerifyAssertion(resp, challenge, webauthn.Credential{
+			ID:        credential.ID,
+			PublicKey: credential.PublicKey,
+			SignCount: uint32(credential.SignCount),
+		})
+		if errors.Is(err, webauthn.ErrSignCount) {
+			audit.Record(r.Context(), a, audit.Entry{
+				Action: audit.PasskeyCloneSuspected,
+				Target: "user:" + strings.ToLower(user.Name),
+				IP:     ClientIP(r, a.Config.Server.TrustProxyHeaders),
+			})
+			problem.Error(w, "Passkey rejected", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			a.Logger.Warn("rejected passkey login", "user_id", user.ID, "error", err)
+			problem.Error(w, "Passkey rejected", http.StatusUnauthorized)
+			return
+		}
+
+		err = a.Queries.UpdateWebAuthnCredentialUse(r.Context(), db.UpdateWebAuthnCredentialUseParams{
+			ID:         credential.ID,
+			SignCount:  int64(signCount),
+			LastUsedAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			a.Logger.Error("failed to update passkey", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		if err := startSession(w, r, a, user, true); err != nil {
+			a.Logger.Error("failed to start session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/Modul-306/backend/tests/webauthnmock"
+	"github.com/Modul-306/backend/webauthn"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasskeys(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("old password")
+	var userID, otherID int32
+	for _, u := range []struct {
+		name string
+		id   *int32
+	}{{"testuser", &userID}, {"otheruser", &otherID}} {
+		err = conn.QueryRow(context.Background(), `
+            INSERT INTO users (name, password, email, email_verified)
+            VALUES ($1, $2, $1 || '@example.com', TRUE)
+            RETURNING id
+        `, u.name, hashedPassword).Scan(u.id)
+		if err != nil {
+			t.Fatalf("failed to create test user: %v", err)
+		}
+	}
+	session := testhelpers.SessionCookie(t, a, userID)
+
+	_, origins := a.Config.WebAuthnRelyingParty()
+	authenticator := webauthnmock.New(origins[0])
+
+	do := func(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			if c != nil {
+				req.AddCookie(c)
+			}
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	beginRegistration := func(session *http.Cookie) webauthn.CreationOptions {
+		rec := do("POST", "/api/v1/me/passkeys/begin", nil, session)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("failed to begin registration: %d %s", rec.Code, rec.Body.String())
+		}
+		var response struct {
+			PublicKey webauthn.CreationOptions `json:"publicKey"`
+		}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		return response.PublicKey
+	}
+	beginLogin := func() webauthn.RequestOptions {
+		rec := do("POST", "/api/v1/auth/passkey/begin", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("failed to begin login: %d %s", rec.Code, rec.Body.String())
+		}
+		var response struct {
+			PublicKey webauthn.RequestOptions `json:"publicKey"`
+		}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		return response.PublicKey
+	}
+	passkeys := func() []auth.PasskeyResponse {
+		rec := do("GET", "/api/v1/me/passkeys", nil, session)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response []auth.PasskeyResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response
+	}
+
+	var registered auth.PasskeyResponse
+	t.Run("register", func(t *testing.T) {
+		options := beginRegistration(session)
+		assert.Equal(t, "none", options.Attestation)
+		assert.Empty(t, options.ExcludeCredentials)
+
+		credential, err := authenticator.Create(options)
+		assert.NoError(t, err)
+
+		// The challenge belongs to the user who started the ceremony
+		other := testhelpers.SessionCookie(t, a, otherID)
+		rec := do("POST", "/api/v1/me/passkeys/finish", auth.PasskeyRegistrationRequest{Credential: credential}, other)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		// and works once
+		credential, _ = authenticator.Create(beginRegistration(session))
+		rec = do("POST", "/api/v1/me/passkeys/finish", auth.PasskeyRegistrationRequest{Name: "Laptop", Credential: credential}, session)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		json.NewDecoder(rec.Body).Decode(&registered)
+		assert.Equal(t, "Laptop", registered.Name)
+		assert.Nil(t, registered.LastUsedAt)
+		assert.Equal(t, http.StatusBadRequest,
+			do("POST", "/api/v1/me/passkeys/finish", auth.PasskeyRegistrationRequest{Credential: credential}, session).Code)
+
+		assert.Equal(t, []auth.PasskeyResponse{registered}, passkeys())
+		assert.Eventually(t, func() bool { return len(testhelpers.SentMail(t, a)) == 1 }, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, testhelpers.SentMail(t, a)[0], "The passkey \"Laptop\" can now be used to sign in")
+
+		// Registered passkeys are excluded from later ceremonies
+		options = beginRegistration(session)
+		if assert.Len(t, options.ExcludeCredentials, 1) {
+			assert.Equal(t, registered.ID, base64.RawURLEncoding.EncodeToString(options.ExcludeCredentials[0].ID))
+		}
+	})
+
+	t.Run("reject registration", func(t *testing.T) {
+		unverified := webauthnmock.New(origins[0])
+		unverified.SkipUserVerification = true
+		credential, _ := unverified.Create(beginRegistration(session))
+		rec := do("POST", "/api/v1/me/passkeys/finish", auth.PasskeyRegistrationRequest{Credential: credential}, session)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		phished := webauthnmock.New("https://evil.example.net")
+		credential, _ = phished.Create(beginRegistration(session))
+		rec = do("POST", "/api/v1/me/passkeys/finish", auth.PasskeyRegistrationRequest{Credential: credential}, session)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		assert.Len(t, passkeys(), 1)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		options := beginLogin()
+		assert.Empty(t, options.AllowCredentials)
+
+		assertion, err := authenticator.Get(options)
+		assert.NoError(t, err)
+		rec := do("POST", "/api/v1/auth/passkey/finish", assertion)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		cookies := cookiesByName(rec)
+		if assert.NotNil(t, cookies["token"]) {
+			rec := do("GET", "/api/v1/me", nil, cookies["token"])
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var user handlers.UserResponse
+			json.NewDecoder(rec.Body).Decode(&user)
+			assert.Equal(t, "testuser", user.Name)
+		}
+		assert.NotNil(t, cookies["refresh_token"])
+
+		var mfaVerified bool
+		err = conn.QueryRow(context.Background(),
+			`SELECT mfa_verified FROM sessions WHERE user_id = $1 AND id NOT LIKE 'test-session-%'`, userID).Scan(&mfaVerified)
+		assert.NoError(t, err)
+		assert.True(t, mfaVerified, "passkeys verify the user")
+
+		// The challenge works once
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/auth/passkey/finish", assertion).Code)
+
+		assert.NotNil(t, passkeys()[0].LastUsedAt)
+	})
+
+	t.Run("reject login", func(t *testing.T) {
+		stranger := webauthnmock.New(origins[0])
+		stranger.Create(webauthn.CreationOptions{
+			Challenge: []byte("unused"),
+			RP:        webauthn.RelyingPartyEntity{ID: beginLogin().RPID},
+			User:      webauthn.User{ID: []byte("1")},
+		})
+		assertion, _ := stranger.Get(beginLogin())
+		assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/passkey/finish", assertion).Code, "unknown passkey")
+
+		assertion, _ = authenticator.Get(beginLogin())
+		assertion.Response.UserHandle = []byte("0")
+		assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/passkey/finish", assertion).Code, "other user")
+
+		assertion, _ = authenticator.Get(beginLogin())
+		assertion.Response.Signature[len(assertion.Response.Signature)-1]++
+		assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/passkey/finish", assertion).Code, "bad signature")
+
+		assertion, _ = authenticator.Get(webauthn.RequestOptions{Challenge: []byte("made up"), RPID: beginLogin().RPID})
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/auth/passkey/finish", assertion).Code, "unknown challenge")
+	})
+
+	t.Run("cloned passkey", func(t *testing.T) {
+		authenticator.SetSignCount(0)
+		assertion, _ := authenticator.Get(beginLogin())
+		rec := do("POST", "/api/v1/auth/passkey/finish", assertion)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Nil(t, cookiesByName(rec)["token"])
+
+		var entries int
+		err := conn.QueryRow(context.Background(),
+			`SELECT count(*) FROM audit_log WHERE action = 'passkey_clone_suspected' AND target = 'user:testuser'`).Scan(&entries)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, entries)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		other := testhelpers.SessionCookie(t, a, otherID)
+		assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/me/passkeys/"+registered.ID, nil, other).Code)
+		assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/me/passkeys/not-base64!", nil, session).Code)
+
+		assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/me/passkeys/"+registered.ID, nil, session).Code)
+		assert.Empty(t, passkeys())
+
+		assertion, _ := authenticator.Get(beginLogin())
+		assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/passkey/finish", assertion).Code)
+	})
+}
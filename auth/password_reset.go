@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+)
+
+// ForgotPassword returns the handler that mails a password reset link. It
+// answers the same way whether or not the address belongs to an account,
+// and does all lookups after responding so that timing does not tell either.
+func ForgotPassword(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		inBackground(a, "password reset", func(ctx context.Context) error {
+			return sendPasswordReset(ctx, a, req.Email)
+		})
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account with that email exists, a password reset link has been sent.",
+		})
+	}
+}
+
+func sendPasswordReset(ctx context.Context, a *app.App, email string) error {
+	user, err := a.Queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := a.Clock().Add(a.Config.Auth.PasswordResetTTL)
+	err = a.Queries.CreatePasswordResetToken(ctx, db.CreatePasswordResetTokenParams{
+		TokenHash: hashToken(secret),
+		UserID:    user.ID,
+		ExpiresAt: timestamp(expiresAt),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := frontendLink(a, "/reset-password", secret)
+	return a.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Open the link below to choose a new password. It expires in %s and works once.\n\n"+
+			"%s\n\n"+
+			"If you did not ask for this, you can ignore this mail.\n",
+			user.Name, a.Config.Auth.PasswordResetTTL, link),
+	})
+}
+
+// ResetPassword returns the handler that sets a new password using a token
+// from ForgotPassword. Every session of the user is revoked afterwards.
+func ResetPassword(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err := a.Passwords.Check(req.Password); err != nil {
+			problem.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := a.Clock()
+		reset, err := a.Queries.UsePasswordResetToken(r.Context(), db.UsePasswordResetTokenParams{
+			TokenHash: hashToken(req.Token),
+			UsedAt:    timestamp(now),
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to use password reset token", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		hashedPassword, err := a.Passwords.Hash(req.Password)
+		if err != nil {
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		err = a.Queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+			ID:       reset.UserID,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			a.Logger.Error("failed to update password", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		// Whoever knew the old password must not stay signed in, and links
+		// requested before the reset are of no further use.
+		err = a.Queries.RevokeUserSessions(r.Context(), db.RevokeUserSessionsParams{
+			UserID:    reset.UserID,
+			RevokedAt: timestamp(now),
+		})
+		if err != nil {
+			a.Logger.Error("failed to revoke sessions", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if err := a.Queries.DeleteUserPasswordResetTokens(r.Context(), reset.UserID); err != nil {
+			a.Logger.Error("failed to delete password reset tokens", "error", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,126 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var resetLink = regexp.MustCompile(`/reset-password\?token=([A-Za-z0-9_-]+)`)
+
+func TestPasswordReset(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("oldpass")
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email)
+        VALUES ($1, $2, $3)
+    `, "testuser", hashedPassword, "test@example.com")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+
+	post := func(path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		return post("/api/v1/auth/login", auth.Credentials{Username: "testuser", Password: password})
+	}
+
+	session := cookiesByName(login("oldpass"))
+
+	unknown := post("/api/v1/auth/forgot-password", auth.ForgotPasswordRequest{Email: "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, unknown.Code)
+
+	// forgot requests a reset and waits for the mail, returning its token.
+	forgot := func() string {
+		sent := len(testhelpers.SentMail(t, a))
+		rec := post("/api/v1/auth/forgot-password", auth.ForgotPasswordRequest{Email: "test@example.com"})
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, unknown.Body.String(), rec.Body.String(), "existing accounts are not revealed")
+
+		assert.Eventually(t, func() bool {
+			return len(testhelpers.SentMail(t, a)) > sent
+		}, 5*time.Second, 10*time.Millisecond)
+		messages := testhelpers.SentMail(t, a)
+		match := resetLink.FindStringSubmatch(messages[len(messages)-1])
+		if match == nil {
+			t.Fatalf("no reset link in mail: %s", messages[len(messages)-1])
+		}
+		return match[1]
+	}
+
+	secret := forgot()
+	// Only the requested account got mail
+	assert.Len(t, testhelpers.SentMail(t, a), 1)
+
+	// Only the hash of the token is stored
+	var stored int
+	err = conn.QueryRow(context.Background(),
+		`SELECT count(*) FROM password_reset_tokens WHERE token_hash = $1`, secret).Scan(&stored)
+	assert.NoError(t, err)
+	assert.Zero(t, stored)
+
+	rec := post("/api/v1/auth/reset-password", auth.ResetPasswordRequest{Token: "wrong", Password: "a brand new password"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A password the policy refuses does not use up the token
+	rec = post("/api/v1/auth/reset-password", auth.ResetPasswordRequest{Token: secret, Password: "short"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = post("/api/v1/auth/reset-password", auth.ResetPasswordRequest{Token: secret, Password: "a brand new password"})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// Tokens are single use
+	rec = post("/api/v1/auth/reset-password", auth.ResetPasswordRequest{Token: secret, Password: "another new password"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, login("oldpass").Code)
+	assert.Equal(t, http.StatusOK, login("a brand new password").Code)
+
+	// Sessions from before the reset are revoked
+	rec = post("/api/v1/auth/refresh", nil, session["refresh_token"])
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Tokens expire
+	secret = forgot()
+	a.Clock = func() time.Time { return time.Now().Add(a.Config.Auth.PasswordResetTTL + time.Minute) }
+	rec = post("/api/v1/auth/reset-password", auth.ResetPasswordRequest{Token: secret, Password: "another new password"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// patPrefix marks personal access tokens, so that they can be told apart
+// from JWT access tokens in the Authorization header.
+const patPrefix = "pat_"
+
+// NewPersonalAccessToken returns a new personal access token and the hash
+// to store for it. The token itself is shown to the user once and never stored.
+func NewPersonalAccessToken() (token, hash string, err error) {
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = patPrefix + secret
+	return token, hashToken(token), nil
+}
+
+// authenticatePersonalAccessToken resolves the caller of a personal access
+// token and records its use.
+func authenticatePersonalAccessToken(ctx context.Context, a *app.App, secret string) (Principal, error) {
+	pat, err := a.Queries.GetPersonalAccessTokenByHash(ctx, hashToken(secret))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Principal{}, errUnauthenticated
+		}
+		return Principal{}, err
+	}
+
+	now := a.Clock()
+	if pat.ExpiresAt.Valid && !now.Before(pat.ExpiresAt.Time) {
+		return Principal{}, errUnauthenticated
+	}
+
+	// Roles are read on every use, so revoking a role applies at once.
+	user, err := a.Queries.GetUser(ctx, pat.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Principal{}, errUnauthenticated
+		}
+		return Principal{}, err
+	}
+
+	err = a.Queries.TouchPersonalAccessToken(ctx, db.TouchPersonalAccessTokenParams{
+		ID:         pat.ID,
+		LastUsedAt: timestamp(now),
+	})
+	if err != nil {
+		return Principal{}, err
+	}
+
+	scopes := make([]Permission, 0, len(pat.Scopes))
+	for _, scope := range pat.Scopes {
+		scopes = append(scopes, Permission(scope))
+	}
+
+	return Principal{
+		UserID: user.ID,
+		Roles:  RolesFromStrings(user.Roles),
+		scopes: scopes,
+	}, nil
+}
+
+func isPersonalAccessToken(credential string) bool {
+	return strings.HasPrefix(credential, patPrefix)
+}
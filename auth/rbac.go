@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/Modul-306/backend/problem"
+)
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleEditor   Role = "editor"
+	RoleAdmin    Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermOrdersRead      Permission = "orders:read"
+	PermOrdersWrite     Permission = "orders:write"
+	PermOrdersReadAll   Permission = "orders:read_all"
+	PermOrdersManageAll Permission = "orders:manage_all"
+	PermBlogsWrite      Permission = "blogs:write"
+	PermBlogsManageAll  Permission = "blogs:manage_all"
+	PermProductsWrite   Permission = "products:write"
+	PermUsersManage     Permission = "users:manage"
+)
+
+// rolePermissions lists what each role may do. Roles do not inherit from each
+// other; a user holding several roles gets the union.
+var rolePermissions = map[Role][]Permission{
+	RoleCustomer: {PermOrdersRead, PermOrdersWrite},
+	RoleEditor:   {PermOrdersRead, PermOrdersWrite, PermBlogsWrite},
+	RoleAdmin: {
+		PermOrdersRead, PermOrdersWrite, PermOrdersReadAll, PermOrdersManageAll,
+		PermBlogsWrite, PermBlogsManageAll, PermProductsWrite, PermUsersManage,
+	},
+}
+
+// ValidRole reports whether name is a known role.
+func ValidRole(name string) bool {
+	_, ok := rolePermissions[Role(name)]
+	return ok
+}
+
+// ValidPermission reports whether name is a known permission.
+func ValidPermission(name string) bool {
+	for _, perms := range rolePermissions {
+		if slices.Contains(perms, Permission(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID    int32
+	Roles     []Role
+	SessionID string
+	// ActorID is the admin acting as UserID through an impersonation
+	// token, or 0.
+	ActorID int32
+	// scopes restricts a caller authenticated by a personal access token to
+	// a subset of its roles' permissions. nil means no restriction.
+	scopes []Permission
+}
+
+// HasRole reports whether the principal holds role.
+func (p Principal) HasRole(role Role) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Impersonated reports whether an admin is acting as the principal.
+func (p Principal) Impersonated() bool {
+	return p.ActorID != 0
+}
+
+// Can reports whether any of the principal's roles grants perm and, for
+// personal access tokens, whether the token is scoped to it.
+func (p Principal) Can(perm Permission) bool {
+	if !p.Scoped(perm) {
+		return false
+	}
+	for _, role := range p.Roles {
+		if slices.Contains(rolePermissions[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scoped reports whether a principal authenticated by a personal access
+// token may use perm. Other principals are not restricted by scopes.
+func (p Principal) Scoped(perm Permission) bool {
+	return p.scopes == nil || slices.Contains(p.scopes, perm)
+}
+
+// RolesFromStrings converts the roles column of a user or the roles claim of a token.
+func RolesFromStrings(names []string) []Role {
+	roles := make([]Role, 0, len(names))
+	for _, name := range names {
+		roles = append(roles, Role(name))
+	}
+	return roles
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal stored by IsAuthorized.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// RequirePermission responds 403 unless the caller resolved by IsAuthorized holds perm.
+func RequirePermission(perm Permission, endpoint http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		if !p.Can(perm) {
+			problem.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		endpoint(w, r)
+	}
+}
@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/token"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	accessCookie  = "token"
+	refreshCookie = "refresh_token"
+	// refreshCookiePath keeps the refresh token from being sent with every API call.
+	refreshCookiePath = "/api/v1/auth"
+
+	// maxUserAgentLength bounds the User-Agent stored with a session.
+	maxUserAgentLength = 512
+	// sessionTouchInterval is how often the last activity of a session is
+	// written, so that not every request is a write.
+	sessionTouchInterval = time.Minute
+)
+
+// newOpaqueToken returns a random, URL-safe secret for tokens that are
+// looked up in the database rather than verified by signature.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what is stored for opaque tokens, so that a database leak
+// does not hand out working credentials.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func timestamp(t time.Time) pgtype.Timestamp {
+	return pgtype.Timestamp{Time: t.UTC(), Valid: true}
+}
+
+// startSession creates a session for user, recording the device and IP of
+// r, and sets its cookies on w. mfaVerified records whether the user
+// presented a second factor.
+//
+// Signing in cancels a pending deletion of the account.
+func startSession(w http.ResponseWriter, r *http.Request, a *app.App, user db.User, mfaVerified bool) error {
+	ctx := r.Context()
+	if user.DeletionScheduledAt.Valid {
+		if _, err := a.Queries.CancelUserDeletion(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	id, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	now := a.Clock()
+	session, err := a.Queries.CreateSession(ctx, db.CreateSessionParams{
+		ID:          id,
+		UserID:      user.ID,
+		ExpiresAt:   timestamp(now.Add(a.Config.Auth.RefreshTokenTTL)),
+		MfaVerified: mfaVerified,
+		UserAgent:   sessionUserAgent(r),
+		Ip:          ClientIP(r, a.Config.Server.TrustProxyHeaders),
+		CreatedAt:   timestamp(now),
+	})
+	if err != nil {
+		return err
+	}
+
+	return issueTokens(ctx, w, a, session, user)
+}
+
+// sessionUserAgent returns the User-Agent of r to store with a session.
+func sessionUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return userAgent
+}
+
+// issueTokens hands out a new access and refresh token for session.
+func issueTokens(ctx context.Context, w http.ResponseWriter, a *app.App, session db.Session, user db.User) error {
+	refresh, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = a.Queries.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{
+		TokenHash: hashToken(refresh),
+		SessionID: session.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	roles, err := grantedRoles(ctx, a, session, user)
+	if err != nil {
+		return err
+	}
+
+	expirationTime := a.Clock().Add(a.Config.Auth.TokenTTL)
+	access, err := a.Tokens.Create(token.Subject{
+		UserID:    user.ID,
+		Roles:     roles,
+		SessionID: session.ID,
+	}, expirationTime)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, authCookie(a, accessCookie, access, "/", expirationTime))
+	http.SetCookie(w, authCookie(a, refreshCookie, refresh, refreshCookiePath, session.ExpiresAt.Time))
+	setCSRFCookie(w, a, session.ID, session.ExpiresAt.Time)
+	return nil
+}
+
+func authCookie(a *app.App, name, value, path string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.Config.Auth.CookieSecure,
+		SameSite: a.Config.Auth.SameSite(),
+	}
+}
+
+func clearSessionCookies(w http.ResponseWriter, a *app.App) {
+	for _, c := range []*http.Cookie{
+		authCookie(a, accessCookie, "", "/", time.Unix(0, 0)),
+		authCookie(a, refreshCookie, "", refreshCookiePath, time.Unix(0, 0)),
+		authCookie(a, csrfCookie, "", "/", time.Unix(0, 0)),
+	} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+// activeSession loads the session id and reports whether it may still be
+// used. Using an active session counts as activity of it.
+func activeSession(ctx context.Context, a *app.App, id string) (db.Session, bool, error) {
+	session, err := a.Queries.GetSession(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Session{}, false, nil
+		}
+		return db.Session{}, false, err
+	}
+
+	now := a.Clock()
+	active := !session.RevokedAt.Valid && now.Before(session.ExpiresAt.Time)
+	if active && now.Sub(session.LastSeenAt.Time) >= sessionTouchInterval {
+		err := a.Queries.TouchSession(ctx, db.TouchSessionParams{
+			ID:         session.ID,
+			LastSeenAt: timestamp(now),
+		})
+		if err != nil {
+			a.Logger.Error("failed to update session activity", "error", err)
+		}
+	}
+	return session, active, nil
+}
+
+// Refresh returns the handler that trades a refresh token for a new access
+// and refresh token. Each refresh token works once; presenting one again
+// means it leaked, so the whole session is revoked.
+func Refresh(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(refreshCookie)
+		if err != nil {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		hash := hashToken(c.Value)
+
+		refresh, err := a.Queries.UseRefreshToken(r.Context(), db.UseRefreshTokenParams{
+			TokenHash: hash,
+			UsedAt:    timestamp(a.Clock()),
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			revokeReusedToken(r.Context(), a, hash)
+			clearSessionCookies(w, a)
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			a.Logger.Error("failed to use refresh token", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		session, active, err := activeSession(r.Context(), a, refresh.SessionID)
+		if err != nil {
+			a.Logger.Error("failed to load session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			clearSessionCookies(w, a)
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		// Reload the user so that role changes take effect on refresh.
+		user, err := a.Queries.GetUser(r.Context(), session.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		if err := issueTokens(r.Context(), w, a, session, user); err != nil {
+			a.Logger.Error("failed to issue tokens", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+		}
+	}
+}
+
+// revokeReusedToken revokes the session of a refresh token that was already
+// used. Unknown tokens are ignored.
+func revokeReusedToken(ctx context.Context, a *app.App, hash string) {
+	refresh, err := a.Queries.GetRefreshToken(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			a.Logger.Error("failed to look up refresh token", "error", err)
+		}
+		return
+	}
+
+	a.Logger.Warn("refresh token reused, revoking session", "session_id", refresh.SessionID)
+	err = a.Queries.RevokeSession(ctx, db.RevokeSessionParams{
+		ID:        refresh.SessionID,
+		RevokedAt: timestamp(a.Clock()),
+	})
+	if err != nil {
+		a.Logger.Error("failed to revoke session", "error", err)
+	}
+}
+
+// Logout returns the handler that revokes the caller's session. It works
+// with either cookie, so that a client whose access token has expired can
+// still log out, or with an access token as Bearer token, and always clears
+// both cookies.
+func Logout(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sessionID := requestSessionID(r, a); sessionID != "" {
+			err := a.Queries.RevokeSession(r.Context(), db.RevokeSessionParams{
+				ID:        sessionID,
+				RevokedAt: timestamp(a.Clock()),
+			})
+			if err != nil {
+				a.Logger.Error("failed to revoke session", "error", err)
+				problem.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		clearSessionCookies(w, a)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func requestSessionID(r *http.Request, a *app.App) string {
+	// Clients sending an Authorization header may have no cookies at all.
+	if r.Header.Get("Authorization") != "" {
+		if credential := requestCredential(r); credential != "" && !isPersonalAccessToken(credential) {
+			if p, err := authenticateAccessToken(r.Context(), a, credential); err == nil {
+				return p.SessionID
+			}
+		}
+	}
+	if c, err := r.Cookie(refreshCookie); err == nil {
+		refresh, err := a.Queries.GetRefreshToken(r.Context(), hashToken(c.Value))
+		if err == nil {
+			return refresh.SessionID
+		}
+	}
+	if c, err := r.Cookie(accessCookie); err == nil {
+		claims, err := a.Tokens.Parse(c.Value)
+		if err == nil {
+			return claims.SessionID
+		}
+	}
+	return ""
+}
+
+// LogoutAll returns the handler that revokes every session of the caller.
+// It must run behind IsAuthorized.
+func LogoutAll(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		err := a.Queries.RevokeUserSessions(r.Context(), db.RevokeUserSessionsParams{
+			UserID:    p.UserID,
+			RevokedAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			a.Logger.Error("failed to revoke sessions", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		clearSessionCookies(w, a)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sessionHandle is the ID under which a session is shown to its user. The
+// session ID itself stays secret, as the CSRF token is derived from it.
+func sessionHandle(id string) string {
+	return hashToken("session:" + id)
+}
+
+// GetSessions returns the handler that lists the caller's active sessions,
+// most recently used first.
+func GetSessions(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		sessions, err := a.Queries.GetUserSessions(r.Context(), db.GetUserSessionsParams{
+			UserID:    p.UserID,
+			ExpiresAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			a.Logger.Error("failed to load sessions", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]SessionResponse, len(sessions))
+		for i, session := range sessions {
+			response[i] = SessionResponse{
+				ID:         sessionHandle(session.ID),
+				UserAgent:  session.UserAgent,
+				IP:         session.Ip,
+				CreatedAt:  session.CreatedAt.Time,
+				LastSeenAt: session.LastSeenAt.Time,
+				Current:    session.ID == p.SessionID,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// RevokeSession returns the handler that signs out the caller's session
+// with the ID from GetSessions in the URL. Its tokens stop working with the
+// next request. Revoking the current session also clears its cookies.
+func RevokeSession(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := sessionPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		sessions, err := a.Queries.GetUserSessions(r.Context(), db.GetUserSessionsParams{
+			UserID:    p.UserID,
+			ExpiresAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			a.Logger.Error("failed to load sessions", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		handle := mux.Vars(r)["id"]
+		i := slices.IndexFunc(sessions, func(s db.Session) bool { return sessionHandle(s.ID) == handle })
+		if i < 0 {
+			problem.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		err = a.Queries.RevokeSession(r.Context(), db.RevokeSessionParams{
+			ID:        sessions[i].ID,
+			RevokedAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			a.Logger.Error("failed to revoke session", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		if sessions[i].ID == p.SessionID {
+			clearSessionCookies(w, a)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,267 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func cookiesByName(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	return cookies
+}
+
+func TestSessions(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("testpass")
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email)
+        VALUES ($1, $2, $3)
+    `, "testuser", hashedPassword, "test@example.com")
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+
+	do := func(method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+
+	login := func() map[string]*http.Cookie {
+		body, _ := json.Marshal(auth.Credentials{Username: "testuser", Password: "testpass"})
+		req := httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return cookiesByName(rec)
+	}
+
+	// Login sets hardened access and refresh cookies
+	first := login()
+	for _, name := range []string{"token", "refresh_token"} {
+		c := first[name]
+		if !assert.NotNil(t, c, name) {
+			t.FailNow()
+		}
+		assert.True(t, c.HttpOnly, name)
+		assert.True(t, c.Secure, name)
+		assert.Equal(t, http.SameSiteStrictMode, c.SameSite, name)
+	}
+	assert.Equal(t, "/api/v1/auth", first["refresh_token"].Path)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/order", first["token"]).Code)
+
+	// The CSRF token is readable by scripts and required along with the
+	// session cookie
+	if assert.NotNil(t, first["csrf_token"]) {
+		assert.False(t, first["csrf_token"].HttpOnly)
+	}
+	forged := httptest.NewRequest("POST", "/api/v1/auth/logout-all", nil)
+	forged.AddCookie(first["token"])
+	rec := httptest.NewRecorder()
+	sut.ServeHTTP(rec, forged)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = do("GET", "/api/v1/auth/csrf", first["token"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var csrf auth.CSRFTokenResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&csrf))
+	assert.Equal(t, first["csrf_token"].Value, csrf.CSRFToken)
+
+	// Refreshing rotates both tokens
+	rec = do("POST", "/api/v1/auth/refresh", first["refresh_token"])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	second := cookiesByName(rec)
+	assert.NotEqual(t, first["refresh_token"].Value, second["refresh_token"].Value)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/order", second["token"]).Code)
+
+	// Reusing a rotated refresh token revokes the session
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/refresh", first["refresh_token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/refresh", second["refresh_token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/order", second["token"]).Code)
+
+	// Logout revokes only the current session
+	third, fourth := login(), login()
+	rec = do("POST", "/api/v1/auth/logout", third["token"], third["refresh_token"])
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "", cookiesByName(rec)["token"].Value)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/order", third["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/refresh", third["refresh_token"]).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/order", fourth["token"]).Code)
+
+	// Clients without cookies log out with their Bearer token
+	bearer := login()
+	req := httptest.NewRequest("POST", "/api/v1/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+bearer["token"].Value)
+	rec = httptest.NewRecorder()
+	sut.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/order", bearer["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/refresh", bearer["refresh_token"]).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/order", fourth["token"]).Code)
+
+	// Logout-all revokes every session of the user
+	fifth := login()
+	assert.Equal(t, http.StatusNoContent, do("POST", "/api/v1/auth/logout-all", fifth["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/order", fourth["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/order", fifth["token"]).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/auth/refresh", fourth["refresh_token"]).Code)
+}
+
+func TestSessionManagement(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	now := time.Now()
+	a.Clock = func() time.Time { return now }
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("testpass")
+	var userID, adminID int32
+	for _, u := range []struct {
+		name string
+		id   *int32
+	}{{"testuser", &userID}, {"admin", &adminID}} {
+		err = conn.QueryRow(context.Background(), `
+            INSERT INTO users (name, password, email)
+            VALUES ($1, $2, $1 || '@example.com')
+            RETURNING id
+        `, u.name, hashedPassword).Scan(u.id)
+		if err != nil {
+			t.Fatalf("failed to create test user: %v", err)
+		}
+	}
+	if _, err := conn.Exec(context.Background(), `UPDATE users SET roles = $1 WHERE id = $2`, []string{"customer", "admin"}, adminID); err != nil {
+		t.Fatalf("failed to grant admin role: %v", err)
+	}
+	admin := testhelpers.SessionCookie(t, a, adminID, "customer", "admin")
+
+	do := func(method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(userAgent string) *http.Cookie {
+		body, _ := json.Marshal(auth.Credentials{Username: "testuser", Password: "testpass"})
+		req := httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return cookiesByName(rec)["token"]
+	}
+	sessions := func(session *http.Cookie) []auth.SessionResponse {
+		rec := do("GET", "/api/v1/me/sessions", session)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response []auth.SessionResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response
+	}
+
+	laptop := login("Laptop Browser")
+	now = now.Add(time.Minute)
+	phone := login("Phone Browser")
+
+	// Sessions record the device and are listed by last activity
+	now = now.Add(2 * time.Minute)
+	listed := sessions(laptop)
+	if assert.Len(t, listed, 2) {
+		assert.Equal(t, "Laptop Browser", listed[0].UserAgent)
+		assert.True(t, listed[0].Current)
+		assert.WithinDuration(t, now, listed[0].LastSeenAt, time.Second)
+		assert.Equal(t, "192.0.2.1", listed[0].IP)
+
+		assert.Equal(t, "Phone Browser", listed[1].UserAgent)
+		assert.False(t, listed[1].Current)
+		assert.WithinDuration(t, now.Add(-2*time.Minute), listed[1].CreatedAt, time.Second)
+	}
+
+	// Revoking a session turns its token away on the next request
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/me/sessions/unknown", laptop).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/me/sessions/"+listed[1].ID, laptop).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", phone).Code)
+	assert.Len(t, sessions(laptop), 1)
+
+	// Other users' sessions cannot be revoked
+	own := sessions(laptop)[0].ID
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/me/sessions/"+own, admin).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/me", laptop).Code)
+
+	// Revoking the current session signs out
+	rec := do("DELETE", "/api/v1/me/sessions/"+own, laptop)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "", cookiesByName(rec)["token"].Value)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", laptop).Code)
+
+	t.Run("admins force a logout", func(t *testing.T) {
+		desktop, tablet := login("Desktop Browser"), login("Tablet Browser")
+		path := fmt.Sprintf("/api/v1/user/%d/logout", userID)
+
+		assert.Equal(t, http.StatusForbidden, do("POST", path, desktop).Code)
+		assert.Equal(t, http.StatusNoContent, do("POST", path, admin).Code)
+		assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", desktop).Code)
+		assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me", tablet).Code)
+		assert.Equal(t, http.StatusOK, do("GET", "/api/v1/me", admin).Code)
+
+		var actorID int32
+		err := conn.QueryRow(context.Background(),
+			`SELECT actor_id FROM audit_log WHERE action = 'sessions_revoked' AND target = 'user:testuser'`).Scan(&actorID)
+		assert.NoError(t, err)
+		assert.Equal(t, adminID, actorID)
+	})
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/audit"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+)
+
+// loginKey is something failed logins are counted against: a user name or
+// a client IP.
+type loginKey struct {
+	key string
+	// free is how many failures pass before attempts have to back off.
+	free int
+	// threshold is the number of failures that locks the key.
+	threshold int
+	// action is the audit action recorded when the key is locked.
+	action string
+}
+
+func userLoginKey(a *app.App, username string) loginKey {
+	return loginKey{
+		key:       userKey(username),
+		free:      a.Config.Auth.LoginFreeAttempts,
+		threshold: a.Config.Auth.LockoutThreshold,
+		action:    audit.AccountLocked,
+	}
+}
+
+// IPs are shared behind NATs, so they get more room than user names.
+func ipLoginKey(a *app.App, ip string) loginKey {
+	return loginKey{
+		key:       "ip:" + ip,
+		free:      a.Config.Auth.LockoutThreshold,
+		threshold: a.Config.Auth.IPLockoutThreshold,
+		action:    audit.IPLocked,
+	}
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// loginRetryAfter returns how long the caller has to wait before the next
+// login attempt against keys, or zero if it may try now.
+func loginRetryAfter(ctx context.Context, a *app.App, keys ...loginKey) (time.Duration, error) {
+	now := a.Clock()
+
+	var wait time.Duration
+	for _, k := range keys {
+		failure, err := a.Queries.GetLoginFailure(ctx, k.key)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, k.wait(a, failure, now))
+	}
+	return wait, nil
+}
+
+func (k loginKey) wait(a *app.App, failure db.LoginFailure, now time.Time) time.Duration {
+	if failure.LockedUntil.Valid {
+		// An expired lockout is reset by the next failure.
+		return max(failure.LockedUntil.Time.Sub(now), 0)
+	}
+	if int(failure.Failures) < k.free {
+		return 0
+	}
+
+	backoff := a.Config.Auth.LoginBackoffBase
+	for i := k.free; i < int(failure.Failures) && backoff < a.Config.Auth.LoginBackoffMax; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, a.Config.Auth.LoginBackoffMax)
+
+	return max(failure.LastFailureAt.Time.Add(backoff).Sub(now), 0)
+}
+
+// recordLoginFailure counts a failed login against keys and locks those
+// that reach their threshold.
+func recordLoginFailure(ctx context.Context, a *app.App, ip string, keys ...loginKey) {
+	now := a.Clock()
+
+	for _, k := range keys {
+		failure, err := a.Queries.RecordLoginFailure(ctx, db.RecordLoginFailureParams{
+			Key:           k.key,
+			LastFailureAt: timestamp(now),
+		})
+		if err != nil {
+			a.Logger.Error("failed to record login failure", "error", err)
+			continue
+		}
+		if int(failure.Failures) < k.threshold || failure.LockedUntil.Valid {
+			continue
+		}
+
+		err = a.Queries.LockLoginKey(ctx, db.LockLoginKeyParams{
+			Key:         k.key,
+			LockedUntil: timestamp(now.Add(a.Config.Auth.LockoutDuration)),
+		})
+		if err != nil {
+			a.Logger.Error("failed to lock login", "error", err)
+			continue
+		}
+		audit.Record(ctx, a, audit.Entry{Action: k.action, Target: k.key, IP: ip})
+	}
+}
+
+// ClearLoginFailures forgets the failed logins of username, which also
+// lifts a lockout.
+func ClearLoginFailures(ctx context.Context, a *app.App, username string) error {
+	return a.Queries.ClearLoginFailures(ctx, userKey(username))
+}
+
+// tooManyAttempts answers a throttled login. Backoff and lockout look the
+// same, so that neither reveals more than the other.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	problem.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
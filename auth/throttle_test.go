@@ -0,0 +1,123 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottling(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	a.Config.Auth.LoginBackoffMax = time.Minute
+	a.Config.Auth.IPLockoutThreshold = 20
+	now := time.Now()
+	a.Clock = func() time.Time { return now }
+	sut := router.CreateRouter(a)
+
+	hashedPassword, _ := a.Passwords.Hash("testpass")
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email, roles)
+        VALUES ($1, $2, $3, '{customer}'), ($4, $2, $5, '{customer,admin}')
+    `, "testuser", hashedPassword, "test@example.com", "admin", "admin@example.com")
+	if err != nil {
+		t.Fatalf("failed to create test users: %v", err)
+	}
+
+	login := func(ip, username, password string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(auth.Credentials{Username: username, Password: password})
+		req := httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(data))
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	auditCount := func(action string) int {
+		var count int
+		err := conn.QueryRow(context.Background(),
+			"SELECT COUNT(*) FROM audit_log WHERE action = $1", action).Scan(&count)
+		assert.NoError(t, err)
+		return count
+	}
+
+	// The first failures are free, then attempts back off
+	for i := 0; i < a.Config.Auth.LoginFreeAttempts; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("10.0.0.1", "testuser", "wrong").Code)
+	}
+	rec := login("10.0.0.1", "testuser", "testpass")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "even the right password waits")
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, login("10.0.0.1", "testuser", "testpass").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("10.0.0.1", "testuser", "wrong").Code,
+		"a successful login clears the failures")
+
+	// Unknown users are throttled the same way
+	for i := 0; i < a.Config.Auth.LoginFreeAttempts; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("10.0.0.2", "nobody", "wrong").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, login("10.0.0.2", "nobody", "wrong").Code)
+
+	// Enough failures lock the account, whatever the IP. One failure is
+	// left over from above.
+	now = now.Add(a.Config.Auth.LoginBackoffMax)
+	for i := 1; i < a.Config.Auth.LockoutThreshold; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("10.0.0.3", "testuser", "wrong").Code)
+		now = now.Add(a.Config.Auth.LoginBackoffMax)
+	}
+	assert.Equal(t, 1, auditCount("account_locked"))
+	now = now.Add(a.Config.Auth.LoginBackoffMax)
+	rec = login("10.0.0.4", "testuser", "testpass")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Only user managers can lift the lockout early
+	unlock := func(cookie *http.Cookie) int {
+		req := httptest.NewRequest("POST", "/api/v1/user/1/unlock", nil)
+		req.AddCookie(cookie)
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusForbidden, unlock(testhelpers.SessionCookie(t, a, 1, "customer")))
+	assert.Equal(t, http.StatusNoContent, unlock(testhelpers.SessionCookie(t, a, 2, "customer", "admin")))
+	assert.Equal(t, 1, auditCount("account_unlocked"))
+	assert.Equal(t, http.StatusOK, login("10.0.0.4", "testuser", "testpass").Code)
+
+	// An IP trying many user names is locked on its own
+	for i := 0; i < a.Config.Auth.IPLockoutThreshold; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("10.0.0.5", fmt.Sprintf("user%d", i), "wrong").Code)
+		now = now.Add(a.Config.Auth.LoginBackoffMax)
+	}
+	assert.Equal(t, 1, auditCount("ip_locked"))
+	assert.Equal(t, http.StatusTooManyRequests, login("10.0.0.5", "testuser", "testpass").Code)
+	assert.Equal(t, http.StatusOK, login("10.0.0.6", "testuser", "testpass").Code)
+}
@@ -1,13 +1,171 @@
 package auth
 
+import (
+	"time"
+
+	"github.com/Modul-306/backend/webauthn"
+)
+
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+// SignUpCredentials is checked against its validate tags once the username
+// and email are normalized. The password is checked against the password
+// policy.
 type SignUpCredentials struct {
-	Username string `json:"username"`
+	Username string `json:"username" validate:"required,max=255"`
+	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,max=255,email"`
+}
+
+type CSRFTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
 	Password string `json:"password"`
-	Email    string `json:"email"`
-	IsAdmin  bool   `json:"is_admin"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// OIDCProvidersResponse lists the providers users can sign in with.
+type OIDCProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+// OIDCStartResponse tells the frontend where to send the user to sign in
+// at a provider.
+type OIDCStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// OIDCCallbackRequest passes on what the provider sent back to the
+// frontend.
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// IdentityResponse is a provider linked to an account, with the address it
+// gave when it was linked.
+type IdentityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PasskeyCreationResponse holds the options for
+// navigator.credentials.create(), under the key the browser expects them.
+type PasskeyCreationResponse struct {
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+// PasskeyRequestResponse holds the options for navigator.credentials.get().
+type PasskeyRequestResponse struct {
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+// PasskeyRegistrationRequest names the passkey created with the options
+// from PasskeyCreationResponse.
+type PasskeyRegistrationRequest struct {
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// PasskeyResponse is a registered passkey. ID is the unpadded base64url
+// credential ID.
+type PasskeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// SessionResponse is a signed-in device. Current marks the session of the
+// request.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountDeletionResponse tells when a deleted account is purged.
+type AccountDeletionResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// MFAChallengeResponse is returned by Login when a second factor is needed.
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// MFALoginRequest completes a login with either a TOTP code or a recovery code.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SecondFactorRequest proves possession of the second factor.
+type SecondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI to show as a QR code.
+	URI string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	// RecoveryCodes each work once in place of a TOTP code. They are only
+	// shown once.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAPolicy lists the roles that are only granted to sessions started with
+// a second factor.
+type MFAPolicy struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+// ImpersonationResponse carries a token to act as another user, to be sent
+// as a Bearer token. It cannot be refreshed.
+type ImpersonationResponse struct {
+	Token     string    `json:"token"`
+	UserID    int32     `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
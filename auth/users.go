@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+)
+
+// Codes of 409 Conflict responses for users.
+const (
+	CodeUsernameTaken = "username_taken"
+	CodeEmailTaken    = "email_taken"
+)
+
+// NormalizeEmail returns email the way it is stored: trimmed and in lower
+// case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UserConflict answers 409 Conflict if err is the result of creating or
+// updating a user whose name or email, ignoring case, is already taken. It
+// reports whether it did.
+func UserConflict(w http.ResponseWriter, err error) bool {
+	switch db.UniqueViolation(err) {
+	case db.UsersNameKey:
+		conflict(w, CodeUsernameTaken, "Username is already taken")
+	case db.UsersEmailKey:
+		conflict(w, CodeEmailTaken, "Email is already in use")
+	default:
+		return false
+	}
+	return true
+}
+
+// conflict answers 409 Conflict with code, one of the Code constants.
+func conflict(w http.ResponseWriter, code, detail string) {
+	problem.Write(w, problem.New(http.StatusConflict, code, detail))
+}
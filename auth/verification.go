@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/mail"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+)
+
+// StartEmailVerification issues a verification token for the current address
+// of user and mails the link in the background. Callers must have checked
+// that the user is not yet verified.
+func StartEmailVerification(ctx context.Context, a *app.App, user db.User) error {
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	now := a.Clock()
+	err = a.Queries.CreateEmailVerificationToken(ctx, db.CreateEmailVerificationTokenParams{
+		TokenHash: hashToken(secret),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: timestamp(now.Add(a.Config.Auth.EmailVerificationTTL)),
+		CreatedAt: timestamp(now),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Open the link below to confirm that this address belongs to you. It expires in %s.\n\n"+
+			"%s\n\n"+
+			"If you did not create an account, you can ignore this mail.\n",
+			user.Name, a.Config.Auth.EmailVerificationTTL, frontendLink(a, "/verify-email", secret)),
+	}
+	inBackground(a, "email verification", func(ctx context.Context) error {
+		return a.Mailer.Send(ctx, msg)
+	})
+	return nil
+}
+
+// VerifyEmail returns the handler that marks an address as verified using a
+// token from the verification mail. A token only confirms the address it
+// was sent to, so it stops working once the user changes their email.
+func VerifyEmail(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			problem.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		verification, err := a.Queries.UseEmailVerificationToken(r.Context(), db.UseEmailVerificationTokenParams{
+			TokenHash: hashToken(req.Token),
+			ExpiresAt: timestamp(a.Clock()),
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+				return
+			}
+			a.Logger.Error("failed to use verification token", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		verified, err := a.Queries.VerifyUserEmail(r.Context(), db.VerifyUserEmailParams{
+			ID:    verification.UserID,
+			Email: verification.Email,
+		})
+		if err != nil {
+			a.Logger.Error("failed to verify email", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if verified == 0 {
+			problem.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+			return
+		}
+
+		if err := a.Queries.DeleteUserEmailVerificationTokens(r.Context(), verification.UserID); err != nil {
+			a.Logger.Error("failed to delete verification tokens", "error", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResendVerification returns the handler that mails the signed-in user a new
+// verification link, at most once per VerificationResendInterval.
+func ResendVerification(a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), p.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if user.EmailVerified {
+			problem.Error(w, "Email is already verified", http.StatusConflict)
+			return
+		}
+
+		latest, err := a.Queries.GetLatestEmailVerificationToken(r.Context(), user.ID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			a.Logger.Error("failed to look up verification token", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			wait := latest.CreatedAt.Time.Add(a.Config.Auth.VerificationResendInterval).Sub(a.Clock())
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				problem.Error(w, "Verification mail was sent recently, try again later", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if err := StartEmailVerification(r.Context(), a, user); err != nil {
+			a.Logger.Error("failed to start email verification", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// RequireVerifiedEmail rejects callers whose email address is not verified,
+// unless RequireVerifiedEmail is switched off. It must run behind IsAuthorized.
+func RequireVerifiedEmail(a *app.App, endpoint http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.Config.Auth.RequireVerifiedEmail {
+			endpoint(w, r)
+			return
+		}
+
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			problem.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := a.Queries.GetUser(r.Context(), p.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problem.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			a.Logger.Error("failed to load user", "error", err)
+			problem.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !user.EmailVerified {
+			problem.Error(w, "Email address is not verified", http.StatusForbidden)
+			return
+		}
+
+		endpoint(w, r)
+	}
+}
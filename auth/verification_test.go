@@ -0,0 +1,136 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var verifyLink = regexp.MustCompile(`/verify-email\?token=([A-Za-z0-9_-]+)`)
+
+func TestEmailVerification(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+	sut := router.CreateRouter(a)
+
+	do := func(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(data))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		testhelpers.WithCSRF(a, req)
+		rec := httptest.NewRecorder()
+		sut.ServeHTTP(rec, req)
+		return rec
+	}
+	// awaitToken waits for a verification mail to address and returns its token.
+	awaitToken := func(address string) string {
+		var token string
+		assert.Eventually(t, func() bool {
+			for _, msg := range testhelpers.SentMail(t, a) {
+				match := verifyLink.FindStringSubmatch(msg)
+				if match != nil && strings.Contains(msg, "To: "+address) {
+					token = match[1]
+				}
+			}
+			return token != ""
+		}, 5*time.Second, 10*time.Millisecond)
+		return token
+	}
+	verified := func() bool {
+		var v bool
+		err := conn.QueryRow(context.Background(), `SELECT email_verified FROM users WHERE id = 1`).Scan(&v)
+		assert.NoError(t, err)
+		return v
+	}
+
+	rec := do("POST", "/api/v1/auth/sign-up", auth.SignUpCredentials{
+		Username: "newuser",
+		Password: "signup password",
+		Email:    "new@example.com",
+	})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	session := cookiesByName(rec)["token"]
+	assert.False(t, verified())
+
+	secret := awaitToken("new@example.com")
+
+	// Unverified users can sign in but not place orders
+	rec = do("POST", "/api/v1/order", handlers.OrderRequest{Address: "123 Test St"}, session)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Resending is throttled
+	rec = do("POST", "/api/v1/auth/verify/resend", nil, session)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	rec = do("POST", "/api/v1/auth/verify", auth.VerifyEmailRequest{Token: "wrong"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do("POST", "/api/v1/auth/verify", auth.VerifyEmailRequest{Token: secret})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.True(t, verified())
+
+	rec = do("POST", "/api/v1/auth/verify", auth.VerifyEmailRequest{Token: secret})
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "tokens are single use")
+
+	rec = do("POST", "/api/v1/auth/verify/resend", nil, session)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = do("POST", "/api/v1/order", handlers.OrderRequest{Address: "123 Test St"}, session)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	// Changing the address requires verifying it again
+	rec = do("UPDATE", "/api/v1/user/1", handlers.UserRequest{
+		Name:     "newuser",
+		Password: "signup password",
+		Email:    "changed@example.com",
+	}, session)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, verified())
+	changed := awaitToken("changed@example.com")
+
+	// A link for an address the user no longer has does nothing
+	rec = do("UPDATE", "/api/v1/user/1", handlers.UserRequest{
+		Name:     "newuser",
+		Password: "signup password",
+		Email:    "again@example.com",
+	}, session)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do("POST", "/api/v1/auth/verify", auth.VerifyEmailRequest{Token: changed})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.False(t, verified())
+
+	rec = do("POST", "/api/v1/auth/verify", auth.VerifyEmailRequest{Token: awaitToken("again@example.com")})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.True(t, verified())
+}
@@ -1,14 +1,102 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/config"
+	"github.com/Modul-306/backend/db"
 	"github.com/Modul-306/backend/router"
 )
 
 func main() {
-	router := router.CreateRouter()
+	cfg, rest, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stdout)
+		fmt.Println("\nCommands:\n  (none)\tserve the API\n  migrate up|down|status|to N\n  hash-passwords")
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	log.Fatal(http.ListenAndServe(":8000", router))
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	switch {
+	case len(rest) == 0:
+		err = serve(cfg, logger)
+	case rest[0] == "migrate":
+		err = migrate(context.Background(), cfg, rest[1:], os.Stdout)
+	case rest[0] == "hash-passwords" && len(rest) == 1:
+		err = hashPasswords(context.Background(), cfg, logger, os.Stdout)
+	default:
+		err = fmt.Errorf("unknown command %q", rest[0])
+	}
+
+	if err != nil {
+		logger.Error("exiting", "error", err)
+		os.Exit(1)
+	}
+}
+
+func serve(cfg *config.Config, logger *slog.Logger) error {
+	ctx := context.Background()
+
+	if cfg.Database.MigrateOnStart {
+		if err := migrate(ctx, cfg, []string{"up"}, nil); err != nil {
+			return err
+		}
+	}
+
+	pool, err := db.NewPool(ctx, cfg.Database.ConnString(), cfg.Database.PoolConfig())
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer pool.Close()
+
+	a, err := app.New(pool, *cfg, logger)
+	if err != nil {
+		return fmt.Errorf("loading signing keys: %w", err)
+	}
+	router := router.CreateRouter(a)
+
+	go purgeDeletedAccounts(ctx, a)
+
+	logger.Info("listening", "addr", cfg.Server.Addr)
+	return http.ListenAndServe(cfg.Server.Addr, router)
+}
+
+// purgeInterval is how often accounts past their deletion grace period are
+// looked for.
+const purgeInterval = time.Hour
+
+// purgeDeletedAccounts deletes accounts whose grace period is over, once at
+// start and then every purgeInterval.
+func purgeDeletedAccounts(ctx context.Context, a *app.App) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := auth.PurgeDeletedAccounts(ctx, a)
+		if err != nil {
+			a.Logger.Error("failed to purge deleted accounts", "error", err)
+		} else if purged > 0 {
+			a.Logger.Info("purged deleted accounts", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
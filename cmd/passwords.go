@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/config"
+	"github.com/Modul-306/backend/db"
+)
+
+// hashPasswords runs the hash-passwords command, which hashes the passwords
+// earlier versions stored in plaintext, and reports how many it found to
+// out. Run it once before turning off ALLOW_LEGACY_PLAINTEXT.
+func hashPasswords(ctx context.Context, cfg *config.Config, logger *slog.Logger, out io.Writer) error {
+	pool, err := db.NewPool(ctx, cfg.Database.ConnString(), cfg.Database.PoolConfig())
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer pool.Close()
+
+	a, err := app.New(pool, *cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	hashed, err := auth.HashPlaintextPasswords(ctx, a)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "hashed %d plaintext passwords\n", hashed)
+	return nil
+}
@@ -0,0 +1,620 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Modul-306/backend/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinJWTKeyLength is the shortest HS256 signing key accepted at startup.
+const MinJWTKeyLength = 32
+
+// JWTKeyID is the key ID of the HS256 key given by JWT_KEY.
+const JWTKeyID = "default"
+
+// providerName is the form of OpenID Connect provider names, which appear
+// in URLs.
+var providerName = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Mail     MailConfig     `yaml:"mail"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+	// TrustProxyHeaders takes the client IP from the last X-Forwarded-For
+	// entry. Only enable it behind a reverse proxy that sets the header.
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers"`
+	// FrontendURL is the base of the links sent by mail, e.g. for password resets.
+	FrontendURL string `yaml:"frontend_url"`
+	// CSRFTrustedOrigins are origins such as https://admin.example.com that
+	// may send unsafe requests besides the origin of FrontendURL.
+	CSRFTrustedOrigins []string `yaml:"csrf_trusted_origins"`
+	// MaxBodyBytes bounds the size of request bodies.
+	MaxBodyBytes int `yaml:"max_body_bytes"`
+}
+
+// TrustedOrigins returns the origins that may send unsafe requests
+// authenticated by cookies.
+func (c ServerConfig) TrustedOrigins() []string {
+	origins := slices.Clone(c.CSRFTrustedOrigins)
+	if u, err := url.Parse(c.FrontendURL); err == nil {
+		origins = append(origins, u.Scheme+"://"+u.Host)
+	}
+	for i, origin := range origins {
+		origins[i] = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	}
+	return origins
+}
+
+// WebAuthnRelyingParty returns the relying party ID and the origins of
+// passkey ceremonies, falling back to FrontendURL.
+func (c Config) WebAuthnRelyingParty() (id string, origins []string) {
+	frontend, err := url.Parse(c.Server.FrontendURL)
+	if err != nil {
+		frontend = &url.URL{}
+	}
+	id = c.Auth.WebAuthnRPID
+	if id == "" {
+		id = frontend.Hostname()
+	}
+	origins = slices.Clone(c.Auth.WebAuthnOrigins)
+	if len(origins) == 0 {
+		origins = []string{frontend.Scheme + "://" + frontend.Host}
+	}
+	for i, origin := range origins {
+		origins[i] = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	}
+	return strings.ToLower(id), origins
+}
+
+type DatabaseConfig struct {
+	Host     string     `yaml:"host"`
+	Port     int        `yaml:"port"`
+	User     string     `yaml:"user"`
+	Password string     `yaml:"password"`
+	Name     string     `yaml:"name"`
+	Pool     PoolConfig `yaml:"pool"`
+	// MigrateOnStart applies pending migrations before the server starts listening.
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+}
+
+type PoolConfig struct {
+	MaxConns          int32         `yaml:"max_conns"`
+	MinConns          int32         `yaml:"min_conns"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+	AcquireTimeout    time.Duration `yaml:"acquire_timeout"`
+}
+
+type AuthConfig struct {
+	// JWTKey is a shorthand for a single HS256 signing key with ID JWTKeyID.
+	JWTKey string `yaml:"jwt_key"`
+	// SigningKeys lists further keys, e.g. asymmetric ones or the
+	// successor of the current key during a rotation.
+	SigningKeys []SigningKeyConfig `yaml:"signing_keys"`
+	// Issuer and Audience are stamped into every token and required when
+	// verifying one, so tokens from other services are not accepted.
+	Issuer   string        `yaml:"issuer"`
+	Audience string        `yaml:"audience"`
+	TokenTTL time.Duration `yaml:"token_ttl"`
+	// RefreshTokenTTL bounds how long a session can be kept alive by refreshing.
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// PasswordResetTTL is how long a password reset link stays valid.
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl"`
+	// EmailVerificationTTL is how long an email verification link stays valid.
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl"`
+	// VerificationResendInterval is the minimum time between two
+	// verification mails to the same user.
+	VerificationResendInterval time.Duration `yaml:"verification_resend_interval"`
+	// AccountDeletionGrace is how long a deleted account can still be
+	// restored by signing in before it is purged.
+	AccountDeletionGrace time.Duration `yaml:"account_deletion_grace"`
+	// RequireVerifiedEmail enforces a verified address on routes that ask
+	// for one, such as placing orders.
+	RequireVerifiedEmail bool `yaml:"require_verified_email"`
+	// MagicLinkTTL is how long a sign-in link stays valid.
+	MagicLinkTTL time.Duration `yaml:"magic_link_ttl"`
+	// MagicLinkEmailLimit and MagicLinkIPLimit are how many sign-in links
+	// may be requested per address and per client IP within
+	// MagicLinkWindow.
+	MagicLinkEmailLimit int           `yaml:"magic_link_email_limit"`
+	MagicLinkIPLimit    int           `yaml:"magic_link_ip_limit"`
+	MagicLinkWindow     time.Duration `yaml:"magic_link_window"`
+	// MagicLinkBindBrowser makes sign-in links only work in the browser
+	// that requested them.
+	MagicLinkBindBrowser bool `yaml:"magic_link_bind_browser"`
+	// OIDCProviders are the OpenID Connect providers users can sign in
+	// with.
+	OIDCProviders []OIDCProviderConfig `yaml:"oidc_providers"`
+	// OIDCStateTTL is how long a user may take at the provider to sign in.
+	OIDCStateTTL time.Duration `yaml:"oidc_state_ttl"`
+	// WebAuthnRPID is the domain passkeys are bound to, the host of
+	// FrontendURL by default. Changing it invalidates every passkey.
+	WebAuthnRPID string `yaml:"webauthn_rp_id"`
+	// WebAuthnRPName is the name authenticators show next to passkeys.
+	WebAuthnRPName string `yaml:"webauthn_rp_name"`
+	// WebAuthnOrigins are the origins passkey ceremonies may run on, the
+	// origin of FrontendURL by default. Their hosts must be WebAuthnRPID or
+	// below it.
+	WebAuthnOrigins []string `yaml:"webauthn_origins"`
+	// WebAuthnTimeout is how long a user may take to use their
+	// authenticator.
+	WebAuthnTimeout time.Duration `yaml:"webauthn_timeout"`
+	// RecentLoginWindow is how long after signing in users without a
+	// password may change their email or delete their account, which
+	// others confirm with their password.
+	RecentLoginWindow time.Duration `yaml:"recent_login_window"`
+	// ImpersonationTTL is how long an admin may act as another user on one
+	// impersonation token.
+	ImpersonationTTL time.Duration `yaml:"impersonation_ttl"`
+	// MFAChallengeTTL is how long a login may wait for its second factor.
+	MFAChallengeTTL time.Duration `yaml:"mfa_challenge_ttl"`
+	// MFAIssuer is the name authenticator apps show next to TOTP codes.
+	MFAIssuer string `yaml:"mfa_issuer"`
+	// LoginFreeAttempts is how many failed logins a user name may have
+	// before each further attempt must wait, starting at LoginBackoffBase
+	// and doubling up to LoginBackoffMax.
+	LoginFreeAttempts int           `yaml:"login_free_attempts"`
+	LoginBackoffBase  time.Duration `yaml:"login_backoff_base"`
+	LoginBackoffMax   time.Duration `yaml:"login_backoff_max"`
+	// LockoutThreshold failed logins lock a user name for LockoutDuration.
+	// Client IPs back off after LockoutThreshold failures and are locked
+	// after IPLockoutThreshold.
+	LockoutThreshold   int           `yaml:"lockout_threshold"`
+	IPLockoutThreshold int           `yaml:"ip_lockout_threshold"`
+	LockoutDuration    time.Duration `yaml:"lockout_duration"`
+	// PasswordHash is the algorithm of new password hashes, "bcrypt" or
+	// "argon2id". Hashes of the other one still verify and are replaced
+	// on the next login.
+	PasswordHash string `yaml:"password_hash"`
+	BcryptCost   int    `yaml:"bcrypt_cost"`
+	// Argon2Memory is in KiB.
+	Argon2Memory      int `yaml:"argon2_memory"`
+	Argon2Iterations  int `yaml:"argon2_iterations"`
+	Argon2Parallelism int `yaml:"argon2_parallelism"`
+	// PasswordMinLength is counted in characters, PasswordMaxLength in
+	// bytes, which bcrypt limits to 72.
+	PasswordMinLength int `yaml:"password_min_length"`
+	PasswordMaxLength int `yaml:"password_max_length"`
+	// PasswordBlocklist is a file of further passwords to refuse, one per
+	// line, on top of the built-in list of common ones.
+	PasswordBlocklist string `yaml:"password_blocklist"`
+	// AllowLegacyPlaintext accepts passwords that earlier versions stored
+	// in plaintext, hashing them on the next login. Leave it off once the
+	// hash-passwords command has converted them.
+	AllowLegacyPlaintext bool `yaml:"allow_legacy_plaintext"`
+	// CookieSecure marks auth cookies Secure. Only disable it for local
+	// development over plain HTTP.
+	CookieSecure bool `yaml:"cookie_secure"`
+	// CookieSameSite is "strict", "lax" or "none".
+	CookieSameSite string `yaml:"cookie_same_site"`
+}
+
+type MailConfig struct {
+	// Driver is "file", which writes .eml files to Dir, or "smtp".
+	Driver string     `yaml:"driver"`
+	From   string     `yaml:"from"`
+	Dir    string     `yaml:"dir"`
+	SMTP   SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// SigningKeyConfig describes one key of the signing key ring.
+type SigningKeyConfig struct {
+	ID string `yaml:"id"`
+	// Algorithm is HS256, RS256, ES256 or EdDSA.
+	Algorithm string `yaml:"algorithm"`
+	// Secret is the shared secret of HS256 keys.
+	Secret string `yaml:"secret"`
+	// PrivateKeyFile is a PEM file holding the private key of asymmetric keys.
+	PrivateKeyFile string `yaml:"private_key_file"`
+	// NotBefore is when the key starts signing; it is published before that.
+	NotBefore time.Time `yaml:"not_before"`
+	// NotAfter is when tokens signed with the key stop being accepted.
+	NotAfter time.Time `yaml:"not_after"`
+}
+
+// OIDCProviderConfig describes an OpenID Connect provider and this
+// service's registration with it.
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities, e.g.
+	// "google". Changing it unlinks every account.
+	Name string `yaml:"name"`
+	// Issuer is the provider's issuer URL, from which its endpoints and
+	// keys are discovered.
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// Scopes are requested besides openid; the default is email and
+	// profile.
+	Scopes []string `yaml:"scopes"`
+}
+
+// SameSite returns the SameSite attribute for auth cookies.
+func (c AuthConfig) SameSite() http.SameSite {
+	switch strings.ToLower(c.CookieSameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+// Default returns the configuration used for every value that is not set
+// explicitly. It does not validate: secrets such as the JWT key have no default.
+func Default() Config {
+	pool := db.DefaultPoolConfig()
+
+	return Config{
+		Server: ServerConfig{
+			Addr:         ":8000",
+			FrontendURL:  "http://localhost:8000",
+			MaxBodyBytes: 1 << 20,
+		},
+		Database: DatabaseConfig{
+			Port:           5432,
+			MigrateOnStart: true,
+			Pool: PoolConfig{
+				MaxConns:          pool.MaxConns,
+				MinConns:          pool.MinConns,
+				HealthCheckPeriod: pool.HealthCheckPeriod,
+				AcquireTimeout:    pool.AcquireTimeout,
+			},
+		},
+		Auth: AuthConfig{
+			Issuer:                     "modul-306-backend",
+			Audience:                   "modul-306-api",
+			TokenTTL:                   5 * time.Minute,
+			RefreshTokenTTL:            30 * 24 * time.Hour,
+			PasswordResetTTL:           time.Hour,
+			EmailVerificationTTL:       24 * time.Hour,
+			VerificationResendInterval: time.Minute,
+			AccountDeletionGrace:       14 * 24 * time.Hour,
+			RequireVerifiedEmail:       true,
+			MagicLinkTTL:               15 * time.Minute,
+			MagicLinkEmailLimit:        3,
+			MagicLinkIPLimit:           20,
+			MagicLinkWindow:            time.Hour,
+			MagicLinkBindBrowser:       true,
+			OIDCStateTTL:               10 * time.Minute,
+			WebAuthnRPName:             "Modul 306",
+			WebAuthnTimeout:            5 * time.Minute,
+			RecentLoginWindow:          10 * time.Minute,
+			ImpersonationTTL:           15 * time.Minute,
+			MFAChallengeTTL:            5 * time.Minute,
+			MFAIssuer:                  "Modul 306",
+			LoginFreeAttempts:          3,
+			LoginBackoffBase:           time.Second,
+			LoginBackoffMax:            5 * time.Minute,
+			LockoutThreshold:           10,
+			IPLockoutThreshold:         100,
+			LockoutDuration:            15 * time.Minute,
+			PasswordHash:               "bcrypt",
+			BcryptCost:                 14,
+			Argon2Memory:               19 * 1024,
+			Argon2Iterations:           2,
+			Argon2Parallelism:          1,
+			PasswordMinLength:          8,
+			PasswordMaxLength:          72,
+			CookieSecure:               true,
+			CookieSameSite:             "strict",
+		},
+		Mail: MailConfig{
+			Driver: "file",
+			From:   "no-reply@localhost",
+			Dir:    "outbox",
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+		},
+	}
+}
+
+// ConnString builds the PostgreSQL connection URI.
+func (c DatabaseConfig) ConnString() string {
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   c.Host + ":" + strconv.Itoa(c.Port),
+		Path:   "/" + c.Name,
+	}
+	return u.String()
+}
+
+// PoolConfig converts the pool settings for db.NewPool.
+func (c DatabaseConfig) PoolConfig() db.PoolConfig {
+	return db.PoolConfig{
+		MaxConns:          c.Pool.MaxConns,
+		MinConns:          c.Pool.MinConns,
+		HealthCheckPeriod: c.Pool.HealthCheckPeriod,
+		AcquireTimeout:    c.Pool.AcquireTimeout,
+	}
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, p := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// Validate checks the whole configuration and reports all problems at once.
+func (c Config) Validate() error {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		fail("server.addr (SERVER_ADDR) is required")
+	}
+	if u, err := url.Parse(c.Server.FrontendURL); err != nil || u.Scheme == "" || u.Host == "" {
+		fail("server.frontend_url (FRONTEND_URL) must be an absolute URL, got %q", c.Server.FrontendURL)
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		fail("server.max_body_bytes (MAX_BODY_BYTES) must be positive")
+	}
+	for _, origin := range c.Server.CSRFTrustedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			fail("server.csrf_trusted_origins (CSRF_TRUSTED_ORIGINS) must hold origins like https://example.com, got %q", origin)
+		}
+	}
+
+	if c.Database.Host == "" {
+		fail("database.host (DB_HOST) is required")
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		fail("database.port (DB_PORT) must be between 1 and 65535, got %d", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		fail("database.user (DB_USER) is required")
+	}
+	if c.Database.Password == "" {
+		fail("database.password (DB_PASSWORD) is required")
+	}
+	if c.Database.Name == "" {
+		fail("database.name (DB_NAME) is required")
+	}
+
+	pool := c.Database.Pool
+	if pool.MaxConns < 1 {
+		fail("database.pool.max_conns (DB_POOL_MAX_CONNS) must be at least 1, got %d", pool.MaxConns)
+	}
+	if pool.MinConns < 0 || pool.MinConns > pool.MaxConns {
+		fail("database.pool.min_conns (DB_POOL_MIN_CONNS) must be between 0 and max_conns, got %d", pool.MinConns)
+	}
+	if pool.HealthCheckPeriod <= 0 {
+		fail("database.pool.health_check_period (DB_POOL_HEALTH_CHECK_PERIOD) must be positive")
+	}
+	if pool.AcquireTimeout <= 0 {
+		fail("database.pool.acquire_timeout (DB_POOL_ACQUIRE_TIMEOUT) must be positive")
+	}
+
+	switch {
+	case c.Auth.JWTKey == "" && len(c.Auth.SigningKeys) == 0:
+		fail("auth.jwt_key (JWT_KEY) or auth.signing_keys is required")
+	case c.Auth.JWTKey != "" && len(c.Auth.JWTKey) < MinJWTKeyLength:
+		fail("auth.jwt_key (JWT_KEY) must be at least %d bytes long", MinJWTKeyLength)
+	}
+	keyIDs := map[string]bool{}
+	if c.Auth.JWTKey != "" {
+		keyIDs[JWTKeyID] = true
+	}
+	for i, k := range c.Auth.SigningKeys {
+		name := fmt.Sprintf("auth.signing_keys[%d]", i)
+		switch {
+		case k.ID == "":
+			fail("%s.id is required", name)
+		case keyIDs[k.ID]:
+			fail("%s.id %q is used twice", name, k.ID)
+		}
+		keyIDs[k.ID] = true
+
+		switch k.Algorithm {
+		case "HS256":
+			if len(k.Secret) < MinJWTKeyLength {
+				fail("%s.secret must be at least %d bytes long", name, MinJWTKeyLength)
+			}
+		case "RS256", "ES256", "EdDSA":
+			if k.PrivateKeyFile == "" {
+				fail("%s.private_key_file is required for %s", name, k.Algorithm)
+			}
+		default:
+			fail("%s.algorithm must be HS256, RS256, ES256 or EdDSA, got %q", name, k.Algorithm)
+		}
+
+		if !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore) {
+			fail("%s.not_after must be after not_before", name)
+		}
+	}
+	if c.Auth.Issuer == "" {
+		fail("auth.issuer (JWT_ISSUER) is required")
+	}
+	if c.Auth.Audience == "" {
+		fail("auth.audience (JWT_AUDIENCE) is required")
+	}
+	if c.Auth.TokenTTL <= 0 {
+		fail("auth.token_ttl (TOKEN_TTL) must be positive")
+	}
+	if c.Auth.RefreshTokenTTL < c.Auth.TokenTTL {
+		fail("auth.refresh_token_ttl (REFRESH_TOKEN_TTL) must not be shorter than auth.token_ttl")
+	}
+	switch strings.ToLower(c.Auth.CookieSameSite) {
+	case "strict", "lax":
+	case "none":
+		if !c.Auth.CookieSecure {
+			fail("auth.cookie_same_site (COOKIE_SAME_SITE) none requires auth.cookie_secure")
+		}
+	default:
+		fail("auth.cookie_same_site (COOKIE_SAME_SITE) must be strict, lax or none, got %q", c.Auth.CookieSameSite)
+	}
+	if c.Auth.PasswordResetTTL <= 0 {
+		fail("auth.password_reset_ttl (PASSWORD_RESET_TTL) must be positive")
+	}
+	if c.Auth.EmailVerificationTTL <= 0 {
+		fail("auth.email_verification_ttl (EMAIL_VERIFICATION_TTL) must be positive")
+	}
+	if c.Auth.VerificationResendInterval < 0 {
+		fail("auth.verification_resend_interval (VERIFICATION_RESEND_INTERVAL) must not be negative")
+	}
+	if c.Auth.AccountDeletionGrace < 0 {
+		fail("auth.account_deletion_grace (ACCOUNT_DELETION_GRACE) must not be negative")
+	}
+	if c.Auth.MagicLinkTTL <= 0 {
+		fail("auth.magic_link_ttl (MAGIC_LINK_TTL) must be positive")
+	}
+	if c.Auth.MagicLinkEmailLimit < 1 || c.Auth.MagicLinkIPLimit < 1 {
+		fail("auth.magic_link_email_limit (MAGIC_LINK_EMAIL_LIMIT) and auth.magic_link_ip_limit (MAGIC_LINK_IP_LIMIT) must be at least 1")
+	}
+	if c.Auth.MagicLinkWindow <= 0 {
+		fail("auth.magic_link_window (MAGIC_LINK_WINDOW) must be positive")
+	}
+	providers := map[string]bool{}
+	for i, p := range c.Auth.OIDCProviders {
+		name := fmt.Sprintf("auth.oidc_providers[%d]", i)
+		switch {
+		case !providerName.MatchString(p.Name):
+			fail("%s.name must be lowercase letters, digits and dashes, got %q", name, p.Name)
+		case providers[p.Name]:
+			fail("%s.name %q is used twice", name, p.Name)
+		}
+		providers[p.Name] = true
+
+		if u, err := url.Parse(p.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("%s.issuer must be an absolute URL, got %q", name, p.Issuer)
+		}
+		if p.ClientID == "" {
+			fail("%s.client_id is required", name)
+		}
+	}
+	if c.Auth.OIDCStateTTL <= 0 {
+		fail("auth.oidc_state_ttl (OIDC_STATE_TTL) must be positive")
+	}
+	rpID, origins := c.WebAuthnRelyingParty()
+	if rpID == "" {
+		fail("auth.webauthn_rp_id (WEBAUTHN_RP_ID) is required if server.frontend_url has no host")
+	}
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			fail("auth.webauthn_origins (WEBAUTHN_ORIGINS) must hold origins like https://example.com, got %q", origin)
+			continue
+		}
+		if host := u.Hostname(); host != rpID && !strings.HasSuffix(host, "."+rpID) {
+			fail("auth.webauthn_origins (WEBAUTHN_ORIGINS) must be on auth.webauthn_rp_id (WEBAUTHN_RP_ID) %q or below it, got %q", rpID, origin)
+		}
+	}
+	if c.Auth.WebAuthnRPName == "" {
+		fail("auth.webauthn_rp_name (WEBAUTHN_RP_NAME) is required")
+	}
+	if c.Auth.WebAuthnTimeout <= 0 {
+		fail("auth.webauthn_timeout (WEBAUTHN_TIMEOUT) must be positive")
+	}
+	if c.Auth.RecentLoginWindow <= 0 {
+		fail("auth.recent_login_window (RECENT_LOGIN_WINDOW) must be positive")
+	}
+	if c.Auth.ImpersonationTTL <= 0 {
+		fail("auth.impersonation_ttl (IMPERSONATION_TTL) must be positive")
+	}
+	if c.Auth.MFAChallengeTTL <= 0 {
+		fail("auth.mfa_challenge_ttl (MFA_CHALLENGE_TTL) must be positive")
+	}
+	if c.Auth.MFAIssuer == "" || strings.Contains(c.Auth.MFAIssuer, ":") {
+		fail("auth.mfa_issuer (MFA_ISSUER) is required and must not contain a colon")
+	}
+	if c.Auth.LoginFreeAttempts < 1 {
+		fail("auth.login_free_attempts (LOGIN_FREE_ATTEMPTS) must be at least 1")
+	}
+	if c.Auth.LoginBackoffBase <= 0 || c.Auth.LoginBackoffMax < c.Auth.LoginBackoffBase {
+		fail("auth.login_backoff_base (LOGIN_BACKOFF_BASE) must be positive and at most auth.login_backoff_max (LOGIN_BACKOFF_MAX)")
+	}
+	if c.Auth.LockoutThreshold <= c.Auth.LoginFreeAttempts {
+		fail("auth.lockout_threshold (LOCKOUT_THRESHOLD) must be greater than auth.login_free_attempts (LOGIN_FREE_ATTEMPTS)")
+	}
+	if c.Auth.IPLockoutThreshold <= c.Auth.LockoutThreshold {
+		fail("auth.ip_lockout_threshold (IP_LOCKOUT_THRESHOLD) must be greater than auth.lockout_threshold (LOCKOUT_THRESHOLD)")
+	}
+	if c.Auth.LockoutDuration <= 0 {
+		fail("auth.lockout_duration (LOCKOUT_DURATION) must be positive")
+	}
+	switch c.Auth.PasswordHash {
+	case "bcrypt":
+		if c.Auth.PasswordMaxLength > 72 {
+			fail("auth.password_max_length (PASSWORD_MAX_LENGTH) must be at most 72 with bcrypt, got %d", c.Auth.PasswordMaxLength)
+		}
+	case "argon2id":
+	default:
+		fail("auth.password_hash (PASSWORD_HASH) must be bcrypt or argon2id, got %q", c.Auth.PasswordHash)
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		fail("auth.bcrypt_cost (BCRYPT_COST) must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.Auth.BcryptCost)
+	}
+	if c.Auth.Argon2Iterations < 1 {
+		fail("auth.argon2_iterations (ARGON2_ITERATIONS) must be at least 1")
+	}
+	if c.Auth.Argon2Parallelism < 1 || c.Auth.Argon2Parallelism > 255 {
+		fail("auth.argon2_parallelism (ARGON2_PARALLELISM) must be between 1 and 255, got %d", c.Auth.Argon2Parallelism)
+	}
+	if c.Auth.Argon2Memory < 8*c.Auth.Argon2Parallelism || c.Auth.Argon2Memory > 4*1024*1024 {
+		fail("auth.argon2_memory (ARGON2_MEMORY) must be between 8 KiB per thread and 4 GiB, got %d", c.Auth.Argon2Memory)
+	}
+	if c.Auth.PasswordMinLength < 1 {
+		fail("auth.password_min_length (PASSWORD_MIN_LENGTH) must be at least 1")
+	}
+	if c.Auth.PasswordMaxLength < c.Auth.PasswordMinLength {
+		fail("auth.password_max_length (PASSWORD_MAX_LENGTH) must not be less than auth.password_min_length")
+	}
+
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		fail("mail.from (MAIL_FROM) must be an email address, got %q", c.Mail.From)
+	}
+	switch c.Mail.Driver {
+	case "file":
+		if c.Mail.Dir == "" {
+			fail("mail.dir (MAIL_DIR) is required for the file driver")
+		}
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			fail("mail.smtp.host (SMTP_HOST) is required for the smtp driver")
+		}
+		if c.Mail.SMTP.Port < 1 || c.Mail.SMTP.Port > 65535 {
+			fail("mail.smtp.port (SMTP_PORT) must be between 1 and 65535, got %d", c.Mail.SMTP.Port)
+		}
+	default:
+		fail("mail.driver (MAIL_DRIVER) must be file or smtp, got %q", c.Mail.Driver)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LookupEnv has the signature of os.LookupEnv so that tests can supply their own environment.
+type LookupEnv func(key string) (string, bool)
+
+// setting binds one configuration value to its flag and environment variable.
+// Every environment variable may also be given as <NAME>_FILE, pointing to a
+// file that holds the value, e.g. a mounted Kubernetes secret.
+type setting struct {
+	flag   string
+	env    string
+	usage  string
+	isBool bool
+	set    func(c *Config, value string) error
+}
+
+func stringSetting(flag, env, usage string, field func(*Config) *string) setting {
+	return setting{flag: flag, env: env, usage: usage, set: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intSetting(flag, env, usage string, field func(*Config) *int) setting {
+	return setting{flag: flag, env: env, usage: usage, set: func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func int32Setting(flag, env, usage string, field func(*Config) *int32) setting {
+	return setting{flag: flag, env: env, usage: usage, set: func(c *Config, value string) error {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = int32(n)
+		return nil
+	}}
+}
+
+func boolSetting(flag, env, usage string, field func(*Config) *bool) setting {
+	return setting{flag: flag, env: env, usage: usage, isBool: true, set: func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+// listSetting takes a comma-separated list.
+func listSetting(flag, env, usage string, field func(*Config) *[]string) setting {
+	return setting{flag: flag, env: env, usage: usage, set: func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}}
+}
+
+func durationSetting(flag, env, usage string, field func(*Config) *time.Duration) setting {
+	return setting{flag: flag, env: env, usage: usage, set: func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func settings() []setting {
+	return []setting{
+		stringSetting("addr", "SERVER_ADDR", "address the HTTP server listens on",
+			func(c *Config) *string { return &c.Server.Addr }),
+		stringSetting("frontend-url", "FRONTEND_URL", "base URL of links sent by mail",
+			func(c *Config) *string { return &c.Server.FrontendURL }),
+		boolSetting("trust-proxy-headers", "TRUST_PROXY_HEADERS", "take the client IP from X-Forwarded-For",
+			func(c *Config) *bool { return &c.Server.TrustProxyHeaders }),
+		listSetting("csrf-trusted-origins", "CSRF_TRUSTED_ORIGINS", "comma-separated origins allowed to send cookie-authenticated requests besides the frontend URL",
+			func(c *Config) *[]string { return &c.Server.CSRFTrustedOrigins }),
+		intSetting("max-body-bytes", "MAX_BODY_BYTES", "largest request body accepted, in bytes",
+			func(c *Config) *int { return &c.Server.MaxBodyBytes }),
+
+		stringSetting("db-host", "DB_HOST", "database host",
+			func(c *Config) *string { return &c.Database.Host }),
+		intSetting("db-port", "DB_PORT", "database port",
+			func(c *Config) *int { return &c.Database.Port }),
+		stringSetting("db-user", "DB_USER", "database user",
+			func(c *Config) *string { return &c.Database.User }),
+		stringSetting("db-password", "DB_PASSWORD", "database password",
+			func(c *Config) *string { return &c.Database.Password }),
+		stringSetting("db-name", "DB_NAME", "database name",
+			func(c *Config) *string { return &c.Database.Name }),
+		int32Setting("db-pool-max-conns", "DB_POOL_MAX_CONNS", "maximum number of pooled connections",
+			func(c *Config) *int32 { return &c.Database.Pool.MaxConns }),
+		int32Setting("db-pool-min-conns", "DB_POOL_MIN_CONNS", "minimum number of pooled connections",
+			func(c *Config) *int32 { return &c.Database.Pool.MinConns }),
+		durationSetting("db-pool-health-check-period", "DB_POOL_HEALTH_CHECK_PERIOD", "interval between idle connection health checks",
+			func(c *Config) *time.Duration { return &c.Database.Pool.HealthCheckPeriod }),
+		durationSetting("db-pool-acquire-timeout", "DB_POOL_ACQUIRE_TIMEOUT", "maximum wait for a free connection",
+			func(c *Config) *time.Duration { return &c.Database.Pool.AcquireTimeout }),
+		boolSetting("db-migrate-on-start", "DB_MIGRATE_ON_START", "apply pending migrations before serving",
+			func(c *Config) *bool { return &c.Database.MigrateOnStart }),
+
+		stringSetting("jwt-key", "JWT_KEY", "key used to sign access tokens",
+			func(c *Config) *string { return &c.Auth.JWTKey }),
+		stringSetting("jwt-issuer", "JWT_ISSUER", "issuer stamped into and required of access tokens",
+			func(c *Config) *string { return &c.Auth.Issuer }),
+		stringSetting("jwt-audience", "JWT_AUDIENCE", "audience stamped into and required of access tokens",
+			func(c *Config) *string { return &c.Auth.Audience }),
+		durationSetting("token-ttl", "TOKEN_TTL", "lifetime of access tokens",
+			func(c *Config) *time.Duration { return &c.Auth.TokenTTL }),
+		durationSetting("refresh-token-ttl", "REFRESH_TOKEN_TTL", "lifetime of a session kept alive by refresh tokens",
+			func(c *Config) *time.Duration { return &c.Auth.RefreshTokenTTL }),
+		durationSetting("password-reset-ttl", "PASSWORD_RESET_TTL", "lifetime of password reset links",
+			func(c *Config) *time.Duration { return &c.Auth.PasswordResetTTL }),
+		durationSetting("email-verification-ttl", "EMAIL_VERIFICATION_TTL", "lifetime of email verification links",
+			func(c *Config) *time.Duration { return &c.Auth.EmailVerificationTTL }),
+		durationSetting("verification-resend-interval", "VERIFICATION_RESEND_INTERVAL", "minimum time between verification mails to one user",
+			func(c *Config) *time.Duration { return &c.Auth.VerificationResendInterval }),
+		durationSetting("account-deletion-grace", "ACCOUNT_DELETION_GRACE", "time before a deleted account is purged",
+			func(c *Config) *time.Duration { return &c.Auth.AccountDeletionGrace }),
+		boolSetting("require-verified-email", "REQUIRE_VERIFIED_EMAIL", "require a verified email address where routes ask for one",
+			func(c *Config) *bool { return &c.Auth.RequireVerifiedEmail }),
+		durationSetting("magic-link-ttl", "MAGIC_LINK_TTL", "lifetime of sign-in links",
+			func(c *Config) *time.Duration { return &c.Auth.MagicLinkTTL }),
+		intSetting("magic-link-email-limit", "MAGIC_LINK_EMAIL_LIMIT", "sign-in links per address within the magic link window",
+			func(c *Config) *int { return &c.Auth.MagicLinkEmailLimit }),
+		intSetting("magic-link-ip-limit", "MAGIC_LINK_IP_LIMIT", "sign-in links per client IP within the magic link window",
+			func(c *Config) *int { return &c.Auth.MagicLinkIPLimit }),
+		durationSetting("magic-link-window", "MAGIC_LINK_WINDOW", "period the sign-in link limits apply to",
+			func(c *Config) *time.Duration { return &c.Auth.MagicLinkWindow }),
+		boolSetting("magic-link-bind-browser", "MAGIC_LINK_BIND_BROWSER", "only accept sign-in links in the browser that requested them",
+			func(c *Config) *bool { return &c.Auth.MagicLinkBindBrowser }),
+		durationSetting("oidc-state-ttl", "OIDC_STATE_TTL", "time allowed to sign in at an OpenID Connect provider",
+			func(c *Config) *time.Duration { return &c.Auth.OIDCStateTTL }),
+		stringSetting("webauthn-rp-id", "WEBAUTHN_RP_ID", "domain passkeys are bound to",
+			func(c *Config) *string { return &c.Auth.WebAuthnRPID }),
+		stringSetting("webauthn-rp-name", "WEBAUTHN_RP_NAME", "name authenticators show next to passkeys",
+			func(c *Config) *string { return &c.Auth.WebAuthnRPName }),
+		listSetting("webauthn-origins", "WEBAUTHN_ORIGINS", "comma-separated origins passkeys may be used on",
+			func(c *Config) *[]string { return &c.Auth.WebAuthnOrigins }),
+		durationSetting("webauthn-timeout", "WEBAUTHN_TIMEOUT", "time allowed to use the authenticator",
+			func(c *Config) *time.Duration { return &c.Auth.WebAuthnTimeout }),
+		durationSetting("recent-login-window", "RECENT_LOGIN_WINDOW", "how long after signing in users without a password may change their email or delete their account",
+			func(c *Config) *time.Duration { return &c.Auth.RecentLoginWindow }),
+		durationSetting("impersonation-ttl", "IMPERSONATION_TTL", "lifetime of the tokens admins use to act as another user",
+			func(c *Config) *time.Duration { return &c.Auth.ImpersonationTTL }),
+		durationSetting("mfa-challenge-ttl", "MFA_CHALLENGE_TTL", "time allowed to enter the second factor after the password",
+			func(c *Config) *time.Duration { return &c.Auth.MFAChallengeTTL }),
+		stringSetting("mfa-issuer", "MFA_ISSUER", "name shown by authenticator apps",
+			func(c *Config) *string { return &c.Auth.MFAIssuer }),
+		intSetting("login-free-attempts", "LOGIN_FREE_ATTEMPTS", "failed logins per user name before backoff starts",
+			func(c *Config) *int { return &c.Auth.LoginFreeAttempts }),
+		durationSetting("login-backoff-base", "LOGIN_BACKOFF_BASE", "first wait after the free attempts, doubled on each failure",
+			func(c *Config) *time.Duration { return &c.Auth.LoginBackoffBase }),
+		durationSetting("login-backoff-max", "LOGIN_BACKOFF_MAX", "longest wait between failed logins",
+			func(c *Config) *time.Duration { return &c.Auth.LoginBackoffMax }),
+		intSetting("lockout-threshold", "LOCKOUT_THRESHOLD", "failed logins that lock a user name",
+			func(c *Config) *int { return &c.Auth.LockoutThreshold }),
+		intSetting("ip-lockout-threshold", "IP_LOCKOUT_THRESHOLD", "failed logins that lock a client IP",
+			func(c *Config) *int { return &c.Auth.IPLockoutThreshold }),
+		durationSetting("lockout-duration", "LOCKOUT_DURATION", "how long a lockout lasts",
+			func(c *Config) *time.Duration { return &c.Auth.LockoutDuration }),
+		boolSetting("cookie-secure", "COOKIE_SECURE", "mark auth cookies Secure",
+			func(c *Config) *bool { return &c.Auth.CookieSecure }),
+		stringSetting("cookie-same-site", "COOKIE_SAME_SITE", "SameSite mode of auth cookies: strict, lax or none",
+			func(c *Config) *string { return &c.Auth.CookieSameSite }),
+		stringSetting("password-hash", "PASSWORD_HASH", "algorithm of new password hashes: bcrypt or argon2id",
+			func(c *Config) *string { return &c.Auth.PasswordHash }),
+		intSetting("bcrypt-cost", "BCRYPT_COST", "bcrypt cost for new password hashes",
+			func(c *Config) *int { return &c.Auth.BcryptCost }),
+		intSetting("argon2-memory", "ARGON2_MEMORY", "argon2id memory in KiB",
+			func(c *Config) *int { return &c.Auth.Argon2Memory }),
+		intSetting("argon2-iterations", "ARGON2_ITERATIONS", "argon2id iterations",
+			func(c *Config) *int { return &c.Auth.Argon2Iterations }),
+		intSetting("argon2-parallelism", "ARGON2_PARALLELISM", "argon2id threads",
+			func(c *Config) *int { return &c.Auth.Argon2Parallelism }),
+		intSetting("password-min-length", "PASSWORD_MIN_LENGTH", "fewest characters of a new password",
+			func(c *Config) *int { return &c.Auth.PasswordMinLength }),
+		intSetting("password-max-length", "PASSWORD_MAX_LENGTH", "most bytes of a new password",
+			func(c *Config) *int { return &c.Auth.PasswordMaxLength }),
+		stringSetting("password-blocklist", "PASSWORD_BLOCKLIST", "file of further passwords to refuse, one per line",
+			func(c *Config) *string { return &c.Auth.PasswordBlocklist }),
+		boolSetting("allow-legacy-plaintext", "ALLOW_LEGACY_PLAINTEXT", "accept passwords stored in plaintext by earlier versions",
+			func(c *Config) *bool { return &c.Auth.AllowLegacyPlaintext }),
+
+		stringSetting("mail-driver", "MAIL_DRIVER", "how mail is delivered: file or smtp",
+			func(c *Config) *string { return &c.Mail.Driver }),
+		stringSetting("mail-from", "MAIL_FROM", "sender address of outgoing mail",
+			func(c *Config) *string { return &c.Mail.From }),
+		stringSetting("mail-dir", "MAIL_DIR", "directory the file driver writes .eml files to",
+			func(c *Config) *string { return &c.Mail.Dir }),
+		stringSetting("smtp-host", "SMTP_HOST", "SMTP server host",
+			func(c *Config) *string { return &c.Mail.SMTP.Host }),
+		intSetting("smtp-port", "SMTP_PORT", "SMTP server port",
+			func(c *Config) *int { return &c.Mail.SMTP.Port }),
+		stringSetting("smtp-username", "SMTP_USERNAME", "SMTP user name",
+			func(c *Config) *string { return &c.Mail.SMTP.Username }),
+		stringSetting("smtp-password", "SMTP_PASSWORD", "SMTP password",
+			func(c *Config) *string { return &c.Mail.SMTP.Password }),
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the YAML file named by -config or CONFIG_FILE, the environment and
+// the command-line flags in args. The result is validated; on failure the
+// returned error lists every problem. Arguments left after the flags are
+// returned as rest.
+func Load(args []string, lookupEnv LookupEnv) (cfg *Config, rest []string, err error) {
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+
+	all := settings()
+	var flagValues []flagValue
+
+	fs := flag.NewFlagSet("backend", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", "", "path to a YAML configuration file (CONFIG_FILE)")
+	for _, s := range all {
+		s := s
+		record := func(value string) error {
+			flagValues = append(flagValues, flagValue{setting: s, value: value})
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.flag, s.usage+" ("+s.env+")", record)
+		} else {
+			fs.Func(s.flag, s.usage+" ("+s.env+")", record)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c := Default()
+	var problems []string
+
+	path := *configPath
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(&c, path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range all {
+		value, ok, err := lookupSetting(s.env, lookupEnv)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := s.set(&c, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+		}
+	}
+
+	for _, fv := range flagValues {
+		if err := fv.setting.set(&c, fv.value); err != nil {
+			problems = append(problems, fmt.Sprintf("-%s: %v", fv.setting.flag, err))
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			problems = append(problems, verr.Problems...)
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+
+	return &c, fs.Args(), nil
+}
+
+// Usage describes every flag and its environment variable.
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Flags:")
+	fmt.Fprintf(w, "  -config string\n\tpath to a YAML configuration file (CONFIG_FILE)\n")
+	for _, s := range settings() {
+		fmt.Fprintf(w, "  -%s\n\t%s (%s, %s_FILE)\n", s.flag, s.usage, s.env, s.env)
+	}
+}
+
+// lookupSetting reads env, or the file named by env_FILE.
+func lookupSetting(env string, lookupEnv LookupEnv) (string, bool, error) {
+	value, hasValue := lookupEnv(env)
+	path, hasFile := lookupEnv(env + "_FILE")
+
+	switch {
+	case hasValue && hasFile:
+		return "", false, fmt.Errorf("%s and %s_FILE are both set", env, env)
+	case hasFile:
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %v", env, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	default:
+		return value, hasValue, nil
+	}
+}
+
+func loadFile(c *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
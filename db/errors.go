@@ -0,0 +1,28 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Unique indexes whose violations callers report to clients.
+const (
+	UsersNameKey  = "users_name_key"
+	UsersEmailKey = "users_email_key"
+
+	UserIdentitiesPkey              = "user_identities_pkey"
+	UserIdentitiesUserIDProviderKey = "user_identities_user_id_provider_key"
+
+	WebauthnCredentialsPkey = "webauthn_credentials_pkey"
+)
+
+// UniqueViolation returns the name of the unique index or constraint err
+// violates, or "" if err is not a unique violation.
+func UniqueViolation(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
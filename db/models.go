@@ -8,6 +8,16 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AuditLog struct {
+	ID        int32
+	Action    string
+	ActorID   pgtype.Int4
+	Target    string
+	Ip        string
+	CreatedAt pgtype.Timestamp
+	Detail    string
+}
+
 type Blog struct {
 	ID         int32
 	Title      string
@@ -18,6 +28,66 @@ type Blog struct {
 	CreatedAt  pgtype.Timestamp
 }
 
+type EmailChange struct {
+	TokenHash string
+	UserID    int32
+	NewEmail  string
+	ExpiresAt pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	UserID    int32
+	Email     string
+	ExpiresAt pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
+}
+
+type LoginFailure struct {
+	Key           string
+	Failures      int32
+	LastFailureAt pgtype.Timestamp
+	LockedUntil   pgtype.Timestamp
+}
+
+type MagicLink struct {
+	TokenHash   string
+	UserID      int32
+	Email       string
+	BrowserHash string
+	ExpiresAt   pgtype.Timestamp
+	CreatedAt   pgtype.Timestamp
+}
+
+type MagicLinkRequest struct {
+	ID        int32
+	Email     string
+	Ip        string
+	CreatedAt pgtype.Timestamp
+}
+
+type MfaChallenge struct {
+	TokenHash string
+	UserID    int32
+	Attempts  int32
+	ExpiresAt pgtype.Timestamp
+}
+
+type MfaRequiredRole struct {
+	Role string
+}
+
+type OidcState struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	BrowserHash  string
+	UserID       pgtype.Int4
+	ExpiresAt    pgtype.Timestamp
+}
+
 type Order struct {
 	ID          int32
 	Address     string
@@ -34,6 +104,25 @@ type OrderProduct struct {
 	CreatedAt pgtype.Timestamp
 }
 
+type PasswordResetToken struct {
+	TokenHash string
+	UserID    int32
+	ExpiresAt pgtype.Timestamp
+	UsedAt    pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
+}
+
+type PersonalAccessToken struct {
+	ID         int32
+	UserID     int32
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  pgtype.Timestamp
+	LastUsedAt pgtype.Timestamp
+	CreatedAt  pgtype.Timestamp
+}
+
 type Product struct {
 	ID          int32
 	Name        string
@@ -43,11 +132,73 @@ type Product struct {
 	CreatedAt   pgtype.Timestamp
 }
 
+type RecoveryCode struct {
+	ID       int32
+	UserID   int32
+	CodeHash string
+	UsedAt   pgtype.Timestamp
+}
+
+type RefreshToken struct {
+	TokenHash string
+	SessionID string
+	CreatedAt pgtype.Timestamp
+	UsedAt    pgtype.Timestamp
+}
+
+type Session struct {
+	ID             string
+	UserID         int32
+	CreatedAt      pgtype.Timestamp
+	ExpiresAt      pgtype.Timestamp
+	RevokedAt      pgtype.Timestamp
+	MfaVerified    bool
+	UserAgent      string
+	Ip             string
+	LastSeenAt     pgtype.Timestamp
+	ImpersonatorID pgtype.Int4
+}
+
+type TotpCredential struct {
+	UserID       int32
+	Secret       string
+	ConfirmedAt  pgtype.Timestamp
+	LastUsedStep int64
+	CreatedAt    pgtype.Timestamp
+}
+
 type User struct {
-	ID        int32
-	Name      string
-	Password  string
+	ID                  int32
+	Name                string
+	Password            string
+	Email               string
+	CreatedAt           pgtype.Timestamp
+	Roles               []string
+	EmailVerified       bool
+	DeletionScheduledAt pgtype.Timestamp
+}
+
+type UserIdentity struct {
+	Provider  string
+	Subject   string
+	UserID    int32
 	Email     string
-	IsAdmin   pgtype.Bool
 	CreatedAt pgtype.Timestamp
 }
+
+type WebauthnChallenge struct {
+	ChallengeHash string
+	UserID        pgtype.Int4
+	Ceremony      string
+	ExpiresAt     pgtype.Timestamp
+}
+
+type WebauthnCredential struct {
+	ID         []byte
+	UserID     int32
+	Name       string
+	PublicKey  []byte
+	SignCount  int64
+	CreatedAt  pgtype.Timestamp
+	LastUsedAt pgtype.Timestamp
+}
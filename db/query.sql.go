@@ -11,6 +11,119 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE users
+SET deletion_scheduled_at = NULL
+WHERE id = $1 AND deletion_scheduled_at IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const changeUserEmail = `-- name: ChangeUserEmail :one
+UPDATE users
+SET email = $2, email_verified = TRUE
+WHERE id = $1
+RETURNING id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at
+`
+
+type ChangeUserEmailParams struct {
+	ID    int32
+	Email string
+}
+
+func (q *Queries) ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) (User, error) {
+	row := q.db.QueryRow(ctx, changeUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Password,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
+	)
+	return i, err
+}
+
+const clearLoginFailures = `-- name: ClearLoginFailures :exec
+DELETE FROM login_failures
+WHERE key = $1
+`
+
+func (q *Queries) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := q.db.Exec(ctx, clearLoginFailures, key)
+	return err
+}
+
+const confirmTOTPCredential = `-- name: ConfirmTOTPCredential :execrows
+UPDATE totp_credentials
+SET confirmed_at = $2, last_used_step = $3
+WHERE user_id = $1 AND confirmed_at IS NULL
+`
+
+type ConfirmTOTPCredentialParams struct {
+	UserID       int32
+	ConfirmedAt  pgtype.Timestamp
+	LastUsedStep int64
+}
+
+func (q *Queries) ConfirmTOTPCredential(ctx context.Context, arg ConfirmTOTPCredentialParams) (int64, error) {
+	result, err := q.db.Exec(ctx, confirmTOTPCredential, arg.UserID, arg.ConfirmedAt, arg.LastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countMFAChallengeAttempt = `-- name: CountMFAChallengeAttempt :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1
+RETURNING attempts
+`
+
+func (q *Queries) CountMFAChallengeAttempt(ctx context.Context, tokenHash string) (int32, error) {
+	row := q.db.QueryRow(ctx, countMFAChallengeAttempt, tokenHash)
+	var attempts int32
+	err := row.Scan(&attempts)
+	return attempts, err
+}
+
+const createAuditEntry = `-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (action, actor_id, target, ip, detail, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type CreateAuditEntryParams struct {
+	Action    string
+	ActorID   pgtype.Int4
+	Target    string
+	Ip        string
+	Detail    string
+	CreatedAt pgtype.Timestamp
+}
+
+// Audit queries
+func (q *Queries) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) error {
+	_, err := q.db.Exec(ctx, createAuditEntry,
+		arg.Action,
+		arg.ActorID,
+		arg.Target,
+		arg.Ip,
+		arg.Detail,
+		arg.CreatedAt,
+	)
+	return err
+}
+
 const createBlog = `-- name: CreateBlog :one
 INSERT INTO blogs (title, content, user_id, path)
 VALUES ($1, $2, $3, $4)
@@ -44,6 +157,141 @@ func (q *Queries) CreateBlog(ctx context.Context, arg CreateBlogParams) (Blog, e
 	return i, err
 }
 
+const createEmailChange = `-- name: CreateEmailChange :exec
+INSERT INTO email_changes (token_hash, user_id, new_email, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateEmailChangeParams struct {
+	TokenHash string
+	UserID    int32
+	NewEmail  string
+	ExpiresAt pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateEmailChange(ctx context.Context, arg CreateEmailChangeParams) error {
+	_, err := q.db.Exec(ctx, createEmailChange,
+		arg.TokenHash,
+		arg.UserID,
+		arg.NewEmail,
+		arg.ExpiresAt,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createEmailVerificationToken = `-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateEmailVerificationTokenParams struct {
+	TokenHash string
+	UserID    int32
+	Email     string
+	ExpiresAt pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
+}
+
+// Email verification queries
+func (q *Queries) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error {
+	_, err := q.db.Exec(ctx, createEmailVerificationToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.Email,
+		arg.ExpiresAt,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createMFAChallenge = `-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3)
+`
+
+type CreateMFAChallengeParams struct {
+	TokenHash string
+	UserID    int32
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateMFAChallenge(ctx context.Context, arg CreateMFAChallengeParams) error {
+	_, err := q.db.Exec(ctx, createMFAChallenge, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const createMagicLink = `-- name: CreateMagicLink :exec
+INSERT INTO magic_links (token_hash, user_id, email, browser_hash, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type CreateMagicLinkParams struct {
+	TokenHash   string
+	UserID      int32
+	Email       string
+	BrowserHash string
+	ExpiresAt   pgtype.Timestamp
+	CreatedAt   pgtype.Timestamp
+}
+
+func (q *Queries) CreateMagicLink(ctx context.Context, arg CreateMagicLinkParams) error {
+	_, err := q.db.Exec(ctx, createMagicLink,
+		arg.TokenHash,
+		arg.UserID,
+		arg.Email,
+		arg.BrowserHash,
+		arg.ExpiresAt,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createMagicLinkRequest = `-- name: CreateMagicLinkRequest :exec
+INSERT INTO magic_link_requests (email, ip, created_at)
+VALUES ($1, $2, $3)
+`
+
+type CreateMagicLinkRequestParams struct {
+	Email     string
+	Ip        string
+	CreatedAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateMagicLinkRequest(ctx context.Context, arg CreateMagicLinkRequestParams) error {
+	_, err := q.db.Exec(ctx, createMagicLinkRequest, arg.Email, arg.Ip, arg.CreatedAt)
+	return err
+}
+
+const createOIDCState = `-- name: CreateOIDCState :exec
+INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, browser_hash, user_id, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateOIDCStateParams struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	BrowserHash  string
+	UserID       pgtype.Int4
+	ExpiresAt    pgtype.Timestamp
+}
+
+func (q *Queries) CreateOIDCState(ctx context.Context, arg CreateOIDCStateParams) error {
+	_, err := q.db.Exec(ctx, createOIDCState,
+		arg.StateHash,
+		arg.Provider,
+		arg.Nonce,
+		arg.CodeVerifier,
+		arg.BrowserHash,
+		arg.UserID,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const createOrder = `-- name: CreateOrder :one
 INSERT INTO orders (address, user_id, is_completed)
 VALUES ($1, $2, false)
@@ -93,6 +341,60 @@ func (q *Queries) CreateOrderProduct(ctx context.Context, arg CreateOrderProduct
 	return i, err
 }
 
+const createPasswordResetToken = `-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3)
+`
+
+type CreatePasswordResetTokenParams struct {
+	TokenHash string
+	UserID    int32
+	ExpiresAt pgtype.Timestamp
+}
+
+// Password reset queries
+func (q *Queries) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error {
+	_, err := q.db.Exec(ctx, createPasswordResetToken, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const createPersonalAccessToken = `-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at
+`
+
+type CreatePersonalAccessTokenParams struct {
+	UserID    int32
+	Name      string
+	TokenHash string
+	Scopes    []string
+	ExpiresAt pgtype.Timestamp
+}
+
+// Personal access token queries
+func (q *Queries) CreatePersonalAccessToken(ctx context.Context, arg CreatePersonalAccessTokenParams) (PersonalAccessToken, error) {
+	row := q.db.QueryRow(ctx, createPersonalAccessToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	var i PersonalAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const createProduct = `-- name: CreateProduct :one
 INSERT INTO products (name, price, image_url, is_available)
 VALUES ($1, $2, $3, $4)
@@ -125,34 +427,248 @@ func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (P
 	return i, err
 }
 
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2)
+`
+
+type CreateRecoveryCodeParams struct {
+	UserID   int32
+	CodeHash string
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.Exec(ctx, createRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
+const createRefreshToken = `-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens (token_hash, session_id)
+VALUES ($1, $2)
+`
+
+type CreateRefreshTokenParams struct {
+	TokenHash string
+	SessionID string
+}
+
+func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) error {
+	_, err := q.db.Exec(ctx, createRefreshToken, arg.TokenHash, arg.SessionID)
+	return err
+}
+
+const createSession = `-- name: CreateSession :one
+INSERT INTO sessions (id, user_id, expires_at, mfa_verified, user_agent, ip, created_at, last_seen_at, impersonator_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8)
+RETURNING id, user_id, created_at, expires_at, revoked_at, mfa_verified, user_agent, ip, last_seen_at, impersonator_id
+`
+
+type CreateSessionParams struct {
+	ID             string
+	UserID         int32
+	ExpiresAt      pgtype.Timestamp
+	MfaVerified    bool
+	UserAgent      string
+	Ip             string
+	CreatedAt      pgtype.Timestamp
+	ImpersonatorID pgtype.Int4
+}
+
+// Session queries
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, createSession,
+		arg.ID,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.MfaVerified,
+		arg.UserAgent,
+		arg.Ip,
+		arg.CreatedAt,
+		arg.ImpersonatorID,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.MfaVerified,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
+	)
+	return i, err
+}
+
+const createTOTPCredential = `-- name: CreateTOTPCredential :execrows
+INSERT INTO totp_credentials (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+WHERE totp_credentials.confirmed_at IS NULL
+`
+
+type CreateTOTPCredentialParams struct {
+	UserID int32
+	Secret string
+}
+
+// MFA queries
+func (q *Queries) CreateTOTPCredential(ctx context.Context, arg CreateTOTPCredentialParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createTOTPCredential, arg.UserID, arg.Secret)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (name, password, email, is_admin)
-VALUES ($1, $2, $3, $4)
-RETURNING id, name, password, email, is_admin, created_at
+INSERT INTO users (name, password, email)
+VALUES ($1, $2, $3)
+RETURNING id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at
 `
 
 type CreateUserParams struct {
 	Name     string
 	Password string
 	Email    string
-	IsAdmin  pgtype.Bool
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRow(ctx, createUser,
-		arg.Name,
-		arg.Password,
+	row := q.db.QueryRow(ctx, createUser, arg.Name, arg.Password, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Password,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING provider, subject, user_id, email, created_at
+`
+
+type CreateUserIdentityParams struct {
+	Provider  string
+	Subject   string
+	UserID    int32
+	Email     string
+	CreatedAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, createUserIdentity,
+		arg.Provider,
+		arg.Subject,
+		arg.UserID,
 		arg.Email,
-		arg.IsAdmin,
+		arg.CreatedAt,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.Provider,
+		&i.Subject,
+		&i.UserID,
+		&i.Email,
+		&i.CreatedAt,
 	)
+	return i, err
+}
+
+const createVerifiedUser = `-- name: CreateVerifiedUser :one
+INSERT INTO users (name, password, email, email_verified)
+VALUES ($1, $2, $3, TRUE)
+RETURNING id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at
+`
+
+type CreateVerifiedUserParams struct {
+	Name     string
+	Password string
+	Email    string
+}
+
+func (q *Queries) CreateVerifiedUser(ctx context.Context, arg CreateVerifiedUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, createVerifiedUser, arg.Name, arg.Password, arg.Email)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.Password,
 		&i.Email,
-		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
+	)
+	return i, err
+}
+
+const createWebAuthnChallenge = `-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge_hash, user_id, ceremony, expires_at)
+VALUES ($1, $2, $3, $4)
+`
+
+type CreateWebAuthnChallengeParams struct {
+	ChallengeHash string
+	UserID        pgtype.Int4
+	Ceremony      string
+	ExpiresAt     pgtype.Timestamp
+}
+
+// WebAuthn queries
+func (q *Queries) CreateWebAuthnChallenge(ctx context.Context, arg CreateWebAuthnChallengeParams) error {
+	_, err := q.db.Exec(ctx, createWebAuthnChallenge,
+		arg.ChallengeHash,
+		arg.UserID,
+		arg.Ceremony,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createWebAuthnCredential = `-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, name, public_key, sign_count, created_at, last_used_at
+`
+
+type CreateWebAuthnCredentialParams struct {
+	ID        []byte
+	UserID    int32
+	Name      string
+	PublicKey []byte
+	SignCount int64
+	CreatedAt pgtype.Timestamp
+}
+
+func (q *Queries) CreateWebAuthnCredential(ctx context.Context, arg CreateWebAuthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRow(ctx, createWebAuthnCredential,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.PublicKey,
+		arg.SignCount,
+		arg.CreatedAt,
+	)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.PublicKey,
+		&i.SignCount,
+		&i.CreatedAt,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
@@ -178,6 +694,36 @@ func (q *Queries) DeleteBlog(ctx context.Context, id int32) (Blog, error) {
 	return i, err
 }
 
+const deleteMFAChallenge = `-- name: DeleteMFAChallenge :exec
+DELETE FROM mfa_challenges
+WHERE token_hash = $1
+`
+
+func (q *Queries) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	_, err := q.db.Exec(ctx, deleteMFAChallenge, tokenHash)
+	return err
+}
+
+const deleteMagicLinkRequestsBefore = `-- name: DeleteMagicLinkRequestsBefore :exec
+DELETE FROM magic_link_requests
+WHERE created_at <= $1
+`
+
+func (q *Queries) DeleteMagicLinkRequestsBefore(ctx context.Context, createdAt pgtype.Timestamp) error {
+	_, err := q.db.Exec(ctx, deleteMagicLinkRequestsBefore, createdAt)
+	return err
+}
+
+const deleteOIDCStatesBefore = `-- name: DeleteOIDCStatesBefore :exec
+DELETE FROM oidc_states
+WHERE expires_at <= $1
+`
+
+func (q *Queries) DeleteOIDCStatesBefore(ctx context.Context, expiresAt pgtype.Timestamp) error {
+	_, err := q.db.Exec(ctx, deleteOIDCStatesBefore, expiresAt)
+	return err
+}
+
 const deleteOrder = `-- name: DeleteOrder :one
 DELETE FROM orders
 WHERE id = $1
@@ -216,6 +762,33 @@ func (q *Queries) DeleteOrderProduct(ctx context.Context, id int32) (OrderProduc
 	return i, err
 }
 
+const deletePersonalAccessToken = `-- name: DeletePersonalAccessToken :one
+DELETE FROM personal_access_tokens
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at
+`
+
+type DeletePersonalAccessTokenParams struct {
+	ID     int32
+	UserID int32
+}
+
+func (q *Queries) DeletePersonalAccessToken(ctx context.Context, arg DeletePersonalAccessTokenParams) (PersonalAccessToken, error) {
+	row := q.db.QueryRow(ctx, deletePersonalAccessToken, arg.ID, arg.UserID)
+	var i PersonalAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const deleteProduct = `-- name: DeleteProduct :one
 DELETE FROM products
 WHERE id = $1
@@ -236,10 +809,30 @@ func (q *Queries) DeleteProduct(ctx context.Context, id int32) (Product, error)
 	return i, err
 }
 
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteTOTPCredential = `-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credentials
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteTOTPCredential(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteTOTPCredential, userID)
+	return err
+}
+
 const deleteUser = `-- name: DeleteUser :one
 DELETE FROM users
 WHERE id = $1
-RETURNING id, name, password, email, is_admin, created_at
+RETURNING id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at
 `
 
 func (q *Queries) DeleteUser(ctx context.Context, id int32) (User, error) {
@@ -250,22 +843,120 @@ func (q *Queries) DeleteUser(ctx context.Context, id int32) (User, error) {
 		&i.Name,
 		&i.Password,
 		&i.Email,
-		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
 	)
 	return i, err
 }
 
-const getBlog = `-- name: GetBlog :one
-SELECT id, title, content, user_id, path, modified_at, created_at FROM blogs
-WHERE id = $1 LIMIT 1
+const deleteUserEmailChanges = `-- name: DeleteUserEmailChanges :exec
+DELETE FROM email_changes
+WHERE user_id = $1
 `
 
-// Blog queries
-func (q *Queries) GetBlog(ctx context.Context, id int32) (Blog, error) {
-	row := q.db.QueryRow(ctx, getBlog, id)
-	var i Blog
-	err := row.Scan(
+func (q *Queries) DeleteUserEmailChanges(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserEmailChanges, userID)
+	return err
+}
+
+const deleteUserEmailVerificationTokens = `-- name: DeleteUserEmailVerificationTokens :exec
+DELETE FROM email_verification_tokens
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserEmailVerificationTokens(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserEmailVerificationTokens, userID)
+	return err
+}
+
+const deleteUserIdentity = `-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities
+WHERE user_id = $1 AND provider = $2
+`
+
+type DeleteUserIdentityParams struct {
+	UserID   int32
+	Provider string
+}
+
+func (q *Queries) DeleteUserIdentity(ctx context.Context, arg DeleteUserIdentityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserIdentity, arg.UserID, arg.Provider)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserMagicLinks = `-- name: DeleteUserMagicLinks :exec
+DELETE FROM magic_links
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserMagicLinks(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserMagicLinks, userID)
+	return err
+}
+
+const deleteUserPasswordResetTokens = `-- name: DeleteUserPasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserPasswordResetTokens(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserPasswordResetTokens, userID)
+	return err
+}
+
+const deleteUserPersonalAccessTokens = `-- name: DeleteUserPersonalAccessTokens :exec
+DELETE FROM personal_access_tokens
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserPersonalAccessTokens(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserPersonalAccessTokens, userID)
+	return err
+}
+
+const deleteWebAuthnChallengesBefore = `-- name: DeleteWebAuthnChallengesBefore :exec
+DELETE FROM webauthn_challenges
+WHERE expires_at <= $1
+`
+
+func (q *Queries) DeleteWebAuthnChallengesBefore(ctx context.Context, expiresAt pgtype.Timestamp) error {
+	_, err := q.db.Exec(ctx, deleteWebAuthnChallengesBefore, expiresAt)
+	return err
+}
+
+const deleteWebAuthnCredential = `-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1 AND user_id = $2
+`
+
+type DeleteWebAuthnCredentialParams struct {
+	ID     []byte
+	UserID int32
+}
+
+func (q *Queries) DeleteWebAuthnCredential(ctx context.Context, arg DeleteWebAuthnCredentialParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebAuthnCredential, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getBlog = `-- name: GetBlog :one
+SELECT id, title, content, user_id, path, modified_at, created_at FROM blogs
+WHERE id = $1 LIMIT 1
+`
+
+// Blog queries
+func (q *Queries) GetBlog(ctx context.Context, id int32) (Blog, error) {
+	row := q.db.QueryRow(ctx, getBlog, id)
+	var i Blog
+	err := row.Scan(
 		&i.ID,
 		&i.Title,
 		&i.Content,
@@ -309,6 +1000,132 @@ func (q *Queries) GetBlogs(ctx context.Context) ([]Blog, error) {
 	return items, nil
 }
 
+const getLatestEmailVerificationToken = `-- name: GetLatestEmailVerificationToken :one
+SELECT token_hash, user_id, email, expires_at, created_at FROM email_verification_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestEmailVerificationToken(ctx context.Context, userID int32) (EmailVerificationToken, error) {
+	row := q.db.QueryRow(ctx, getLatestEmailVerificationToken, userID)
+	var i EmailVerificationToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.Email,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getLoginFailure = `-- name: GetLoginFailure :one
+SELECT key, failures, last_failure_at, locked_until FROM login_failures
+WHERE key = $1 LIMIT 1
+`
+
+// Login throttling queries
+func (q *Queries) GetLoginFailure(ctx context.Context, key string) (LoginFailure, error) {
+	row := q.db.QueryRow(ctx, getLoginFailure, key)
+	var i LoginFailure
+	err := row.Scan(
+		&i.Key,
+		&i.Failures,
+		&i.LastFailureAt,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const getMFAChallenge = `-- name: GetMFAChallenge :one
+SELECT token_hash, user_id, attempts, expires_at FROM mfa_challenges
+WHERE token_hash = $1 LIMIT 1
+`
+
+func (q *Queries) GetMFAChallenge(ctx context.Context, tokenHash string) (MfaChallenge, error) {
+	row := q.db.QueryRow(ctx, getMFAChallenge, tokenHash)
+	var i MfaChallenge
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.Attempts,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getMFARequiredRoles = `-- name: GetMFARequiredRoles :many
+SELECT role FROM mfa_required_roles
+ORDER BY role
+`
+
+func (q *Queries) GetMFARequiredRoles(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, getMFARequiredRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		items = append(items, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMagicLinkRequestsByEmail = `-- name: GetMagicLinkRequestsByEmail :one
+SELECT count(*) AS requests, min(created_at)::timestamp AS oldest
+FROM magic_link_requests
+WHERE email = $1 AND created_at > $2
+`
+
+type GetMagicLinkRequestsByEmailParams struct {
+	Email     string
+	CreatedAt pgtype.Timestamp
+}
+
+type GetMagicLinkRequestsByEmailRow struct {
+	Requests int64
+	Oldest   pgtype.Timestamp
+}
+
+func (q *Queries) GetMagicLinkRequestsByEmail(ctx context.Context, arg GetMagicLinkRequestsByEmailParams) (GetMagicLinkRequestsByEmailRow, error) {
+	row := q.db.QueryRow(ctx, getMagicLinkRequestsByEmail, arg.Email, arg.CreatedAt)
+	var i GetMagicLinkRequestsByEmailRow
+	err := row.Scan(&i.Requests, &i.Oldest)
+	return i, err
+}
+
+const getMagicLinkRequestsByIP = `-- name: GetMagicLinkRequestsByIP :one
+SELECT count(*) AS requests, min(created_at)::timestamp AS oldest
+FROM magic_link_requests
+WHERE ip = $1 AND created_at > $2
+`
+
+type GetMagicLinkRequestsByIPParams struct {
+	Ip        string
+	CreatedAt pgtype.Timestamp
+}
+
+type GetMagicLinkRequestsByIPRow struct {
+	Requests int64
+	Oldest   pgtype.Timestamp
+}
+
+func (q *Queries) GetMagicLinkRequestsByIP(ctx context.Context, arg GetMagicLinkRequestsByIPParams) (GetMagicLinkRequestsByIPRow, error) {
+	row := q.db.QueryRow(ctx, getMagicLinkRequestsByIP, arg.Ip, arg.CreatedAt)
+	var i GetMagicLinkRequestsByIPRow
+	err := row.Scan(&i.Requests, &i.Oldest)
+	return i, err
+}
+
 const getOrder = `-- name: GetOrder :one
 SELECT id, address, user_id, is_completed, created_at FROM orders
 WHERE id = $1 LIMIT 1
@@ -407,6 +1224,93 @@ func (q *Queries) GetOrders(ctx context.Context) ([]Order, error) {
 	return items, nil
 }
 
+const getOrdersByUser = `-- name: GetOrdersByUser :many
+SELECT id, address, user_id, is_completed, created_at FROM orders
+WHERE user_id = $1
+`
+
+func (q *Queries) GetOrdersByUser(ctx context.Context, userID int32) ([]Order, error) {
+	rows, err := q.db.Query(ctx, getOrdersByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Order
+	for rows.Next() {
+		var i Order
+		if err := rows.Scan(
+			&i.ID,
+			&i.Address,
+			&i.UserID,
+			&i.IsCompleted,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPersonalAccessTokenByHash = `-- name: GetPersonalAccessTokenByHash :one
+SELECT id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at FROM personal_access_tokens
+WHERE token_hash = $1 LIMIT 1
+`
+
+func (q *Queries) GetPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (PersonalAccessToken, error) {
+	row := q.db.QueryRow(ctx, getPersonalAccessTokenByHash, tokenHash)
+	var i PersonalAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPersonalAccessTokensByUser = `-- name: GetPersonalAccessTokensByUser :many
+SELECT id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at FROM personal_access_tokens
+WHERE user_id = $1
+ORDER BY id
+`
+
+func (q *Queries) GetPersonalAccessTokensByUser(ctx context.Context, userID int32) ([]PersonalAccessToken, error) {
+	rows, err := q.db.Query(ctx, getPersonalAccessTokensByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PersonalAccessToken
+	for rows.Next() {
+		var i PersonalAccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenHash,
+			&i.Scopes,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getProduct = `-- name: GetProduct :one
 SELECT id, name, price, image_url, is_available, created_at FROM products
 WHERE id = $1 LIMIT 1
@@ -458,8 +1362,66 @@ func (q *Queries) GetProducts(ctx context.Context) ([]Product, error) {
 	return items, nil
 }
 
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT token_hash, session_id, created_at, used_at FROM refresh_tokens
+WHERE token_hash = $1 LIMIT 1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, getRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.SessionID,
+		&i.CreatedAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const getSession = `-- name: GetSession :one
+SELECT id, user_id, created_at, expires_at, revoked_at, mfa_verified, user_agent, ip, last_seen_at, impersonator_id FROM sessions
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetSession(ctx context.Context, id string) (Session, error) {
+	row := q.db.QueryRow(ctx, getSession, id)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.MfaVerified,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
+	)
+	return i, err
+}
+
+const getTOTPCredential = `-- name: GetTOTPCredential :one
+SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM totp_credentials
+WHERE user_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetTOTPCredential(ctx context.Context, userID int32) (TotpCredential, error) {
+	row := q.db.QueryRow(ctx, getTOTPCredential, userID)
+	var i TotpCredential
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.ConfirmedAt,
+		&i.LastUsedStep,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, name, password, email, is_admin, created_at FROM users
+SELECT id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at FROM users
 WHERE id = $1 LIMIT 1
 `
 
@@ -472,15 +1434,38 @@ func (q *Queries) GetUser(ctx context.Context, id int32) (User, error) {
 		&i.Name,
 		&i.Password,
 		&i.Email,
-		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at FROM users
+WHERE lower(email) = lower($1) LIMIT 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Password,
+		&i.Email,
+		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
 	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, name, password, email, is_admin, created_at FROM users
-WHERE name = $1 LIMIT 1
+SELECT id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at FROM users
+WHERE lower(name) = lower($1) LIMIT 1
 `
 
 func (q *Queries) GetUserByUsername(ctx context.Context, name string) (User, error) {
@@ -491,14 +1476,147 @@ func (q *Queries) GetUserByUsername(ctx context.Context, name string) (User, err
 		&i.Name,
 		&i.Password,
 		&i.Email,
-		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
 	)
 	return i, err
 }
 
+const getUserIdentities = `-- name: GetUserIdentities :many
+SELECT provider, subject, user_id, email, created_at FROM user_identities
+WHERE user_id = $1
+ORDER BY provider
+`
+
+func (q *Queries) GetUserIdentities(ctx context.Context, userID int32) ([]UserIdentity, error) {
+	rows, err := q.db.Query(ctx, getUserIdentities, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserIdentity
+	for rows.Next() {
+		var i UserIdentity
+		if err := rows.Scan(
+			&i.Provider,
+			&i.Subject,
+			&i.UserID,
+			&i.Email,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserIdentity = `-- name: GetUserIdentity :one
+SELECT provider, subject, user_id, email, created_at FROM user_identities
+WHERE provider = $1 AND subject = $2
+`
+
+type GetUserIdentityParams struct {
+	Provider string
+	Subject  string
+}
+
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, getUserIdentity, arg.Provider, arg.Subject)
+	var i UserIdentity
+	err := row.Scan(
+		&i.Provider,
+		&i.Subject,
+		&i.UserID,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getUserSessions = `-- name: GetUserSessions :many
+SELECT id, user_id, created_at, expires_at, revoked_at, mfa_verified, user_agent, ip, last_seen_at, impersonator_id FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 AND impersonator_id IS NULL
+ORDER BY last_seen_at DESC, id
+`
+
+type GetUserSessionsParams struct {
+	UserID    int32
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) GetUserSessions(ctx context.Context, arg GetUserSessionsParams) ([]Session, error) {
+	rows, err := q.db.Query(ctx, getUserSessions, arg.UserID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.MfaVerified,
+			&i.UserAgent,
+			&i.Ip,
+			&i.LastSeenAt,
+			&i.ImpersonatorID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserWebAuthnCredentials = `-- name: GetUserWebAuthnCredentials :many
+SELECT id, user_id, name, public_key, sign_count, created_at, last_used_at FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at, id
+`
+
+func (q *Queries) GetUserWebAuthnCredentials(ctx context.Context, userID int32) ([]WebauthnCredential, error) {
+	rows, err := q.db.Query(ctx, getUserWebAuthnCredentials, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebauthnCredential
+	for rows.Next() {
+		var i WebauthnCredential
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.PublicKey,
+			&i.SignCount,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUsers = `-- name: GetUsers :many
-SELECT id, name, password, email, is_admin, created_at FROM users
+SELECT id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at FROM users
 `
 
 func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
@@ -515,8 +1633,10 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 			&i.Name,
 			&i.Password,
 			&i.Email,
-			&i.IsAdmin,
 			&i.CreatedAt,
+			&i.Roles,
+			&i.EmailVerified,
+			&i.DeletionScheduledAt,
 		); err != nil {
 			return nil, err
 		}
@@ -528,6 +1648,195 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
+const getWebAuthnCredential = `-- name: GetWebAuthnCredential :one
+SELECT id, user_id, name, public_key, sign_count, created_at, last_used_at FROM webauthn_credentials
+WHERE id = $1
+`
+
+func (q *Queries) GetWebAuthnCredential(ctx context.Context, id []byte) (WebauthnCredential, error) {
+	row := q.db.QueryRow(ctx, getWebAuthnCredential, id)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.PublicKey,
+		&i.SignCount,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const lockLoginKey = `-- name: LockLoginKey :exec
+UPDATE login_failures
+SET locked_until = $2
+WHERE key = $1
+`
+
+type LockLoginKeyParams struct {
+	Key         string
+	LockedUntil pgtype.Timestamp
+}
+
+func (q *Queries) LockLoginKey(ctx context.Context, arg LockLoginKeyParams) error {
+	_, err := q.db.Exec(ctx, lockLoginKey, arg.Key, arg.LockedUntil)
+	return err
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deletion_scheduled_at <= $1
+`
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, deletionScheduledAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedUsers, deletionScheduledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+INSERT INTO login_failures (key, failures, last_failure_at)
+VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE WHEN login_failures.locked_until <= $2 THEN 1 ELSE login_failures.failures + 1 END,
+    locked_until = CASE WHEN login_failures.locked_until <= $2 THEN NULL ELSE login_failures.locked_until END,
+    last_failure_at = $2
+RETURNING key, failures, last_failure_at, locked_until
+`
+
+type RecordLoginFailureParams struct {
+	Key           string
+	LastFailureAt pgtype.Timestamp
+}
+
+// An expired lockout starts the count afresh.
+func (q *Queries) RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (LoginFailure, error) {
+	row := q.db.QueryRow(ctx, recordLoginFailure, arg.Key, arg.LastFailureAt)
+	var i LoginFailure
+	err := row.Scan(
+		&i.Key,
+		&i.Failures,
+		&i.LastFailureAt,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const revokeOtherUserSessions = `-- name: RevokeOtherUserSessions :exec
+UPDATE sessions
+SET revoked_at = $3
+WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+`
+
+type RevokeOtherUserSessionsParams struct {
+	UserID    int32
+	ID        string
+	RevokedAt pgtype.Timestamp
+}
+
+func (q *Queries) RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) error {
+	_, err := q.db.Exec(ctx, revokeOtherUserSessions, arg.UserID, arg.ID, arg.RevokedAt)
+	return err
+}
+
+const revokeSession = `-- name: RevokeSession :exec
+UPDATE sessions
+SET revoked_at = $2
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	ID        string
+	RevokedAt pgtype.Timestamp
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) error {
+	_, err := q.db.Exec(ctx, revokeSession, arg.ID, arg.RevokedAt)
+	return err
+}
+
+const revokeUserSessions = `-- name: RevokeUserSessions :exec
+UPDATE sessions
+SET revoked_at = $2
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+type RevokeUserSessionsParams struct {
+	UserID    int32
+	RevokedAt pgtype.Timestamp
+}
+
+func (q *Queries) RevokeUserSessions(ctx context.Context, arg RevokeUserSessionsParams) error {
+	_, err := q.db.Exec(ctx, revokeUserSessions, arg.UserID, arg.RevokedAt)
+	return err
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = $2
+WHERE id = $1
+`
+
+type ScheduleUserDeletionParams struct {
+	ID                  int32
+	DeletionScheduledAt pgtype.Timestamp
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error {
+	_, err := q.db.Exec(ctx, scheduleUserDeletion, arg.ID, arg.DeletionScheduledAt)
+	return err
+}
+
+const setMFARequiredRoles = `-- name: SetMFARequiredRoles :exec
+WITH removed AS (
+    DELETE FROM mfa_required_roles
+    WHERE role <> ALL($1::text[])
+)
+INSERT INTO mfa_required_roles (role)
+SELECT unnest($1::text[])
+ON CONFLICT DO NOTHING
+`
+
+func (q *Queries) SetMFARequiredRoles(ctx context.Context, roles []string) error {
+	_, err := q.db.Exec(ctx, setMFARequiredRoles, roles)
+	return err
+}
+
+const touchPersonalAccessToken = `-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens
+SET last_used_at = $2
+WHERE id = $1
+`
+
+type TouchPersonalAccessTokenParams struct {
+	ID         int32
+	LastUsedAt pgtype.Timestamp
+}
+
+func (q *Queries) TouchPersonalAccessToken(ctx context.Context, arg TouchPersonalAccessTokenParams) error {
+	_, err := q.db.Exec(ctx, touchPersonalAccessToken, arg.ID, arg.LastUsedAt)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = $2
+WHERE id = $1
+`
+
+type TouchSessionParams struct {
+	ID         string
+	LastSeenAt pgtype.Timestamp
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.ID, arg.LastSeenAt)
+	return err
+}
+
 const updateBlog = `-- name: UpdateBlog :one
 UPDATE blogs
 SET title = $1, 
@@ -674,16 +1983,17 @@ func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) (P
 
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
-SET name = $1, password = $2, email = $3, is_admin = $4
+SET name = $1, password = $2, email = $3, roles = $4,
+    email_verified = email_verified AND email = $3
 WHERE id = $5
-RETURNING id, name, password, email, is_admin, created_at
+RETURNING id, name, password, email, created_at, roles, email_verified, deletion_scheduled_at
 `
 
 type UpdateUserParams struct {
 	Name     string
 	Password string
 	Email    string
-	IsAdmin  pgtype.Bool
+	Roles    []string
 	ID       int32
 }
 
@@ -692,7 +2002,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		arg.Name,
 		arg.Password,
 		arg.Email,
-		arg.IsAdmin,
+		arg.Roles,
 		arg.ID,
 	)
 	var i User
@@ -701,8 +2011,282 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Name,
 		&i.Password,
 		&i.Email,
-		&i.IsAdmin,
+		&i.CreatedAt,
+		&i.Roles,
+		&i.EmailVerified,
+		&i.DeletionScheduledAt,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET password = $2
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID       int32
+	Password string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.Password)
+	return err
+}
+
+const updateWebAuthnCredentialUse = `-- name: UpdateWebAuthnCredentialUse :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, last_used_at = $3
+WHERE id = $1
+`
+
+type UpdateWebAuthnCredentialUseParams struct {
+	ID         []byte
+	SignCount  int64
+	LastUsedAt pgtype.Timestamp
+}
+
+func (q *Queries) UpdateWebAuthnCredentialUse(ctx context.Context, arg UpdateWebAuthnCredentialUseParams) error {
+	_, err := q.db.Exec(ctx, updateWebAuthnCredentialUse, arg.ID, arg.SignCount, arg.LastUsedAt)
+	return err
+}
+
+const useEmailChange = `-- name: UseEmailChange :one
+DELETE FROM email_changes
+WHERE token_hash = $1 AND expires_at > $2
+RETURNING token_hash, user_id, new_email, expires_at, created_at
+`
+
+type UseEmailChangeParams struct {
+	TokenHash string
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) UseEmailChange(ctx context.Context, arg UseEmailChangeParams) (EmailChange, error) {
+	row := q.db.QueryRow(ctx, useEmailChange, arg.TokenHash, arg.ExpiresAt)
+	var i EmailChange
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.NewEmail,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const useEmailVerificationToken = `-- name: UseEmailVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE token_hash = $1 AND expires_at > $2
+RETURNING token_hash, user_id, email, expires_at, created_at
+`
+
+type UseEmailVerificationTokenParams struct {
+	TokenHash string
+	ExpiresAt pgtype.Timestamp
+}
+
+func (q *Queries) UseEmailVerificationToken(ctx context.Context, arg UseEmailVerificationTokenParams) (EmailVerificationToken, error) {
+	row := q.db.QueryRow(ctx, useEmailVerificationToken, arg.TokenHash, arg.ExpiresAt)
+	var i EmailVerificationToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.Email,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const useMagicLink = `-- name: UseMagicLink :one
+DELETE FROM magic_links
+WHERE token_hash = $1 AND expires_at > $2 AND browser_hash IN ('', $3)
+RETURNING token_hash, user_id, email, browser_hash, expires_at, created_at
+`
+
+type UseMagicLinkParams struct {
+	TokenHash   string
+	ExpiresAt   pgtype.Timestamp
+	BrowserHash string
+}
+
+// Links bound to a browser only work with the hash of its cookie.
+func (q *Queries) UseMagicLink(ctx context.Context, arg UseMagicLinkParams) (MagicLink, error) {
+	row := q.db.QueryRow(ctx, useMagicLink, arg.TokenHash, arg.ExpiresAt, arg.BrowserHash)
+	var i MagicLink
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.Email,
+		&i.BrowserHash,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const useOIDCState = `-- name: UseOIDCState :one
+DELETE FROM oidc_states
+WHERE state_hash = $1 AND provider = $2 AND browser_hash = $3 AND expires_at > $4
+RETURNING state_hash, provider, nonce, code_verifier, browser_hash, user_id, expires_at
+`
+
+type UseOIDCStateParams struct {
+	StateHash   string
+	Provider    string
+	BrowserHash string
+	ExpiresAt   pgtype.Timestamp
+}
+
+func (q *Queries) UseOIDCState(ctx context.Context, arg UseOIDCStateParams) (OidcState, error) {
+	row := q.db.QueryRow(ctx, useOIDCState,
+		arg.StateHash,
+		arg.Provider,
+		arg.BrowserHash,
+		arg.ExpiresAt,
+	)
+	var i OidcState
+	err := row.Scan(
+		&i.StateHash,
+		&i.Provider,
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.BrowserHash,
+		&i.UserID,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const usePasswordResetToken = `-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = $2
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+RETURNING token_hash, user_id, expires_at, used_at, created_at
+`
+
+type UsePasswordResetTokenParams struct {
+	TokenHash string
+	UsedAt    pgtype.Timestamp
+}
+
+func (q *Queries) UsePasswordResetToken(ctx context.Context, arg UsePasswordResetTokenParams) (PasswordResetToken, error) {
+	row := q.db.QueryRow(ctx, usePasswordResetToken, arg.TokenHash, arg.UsedAt)
+	var i PasswordResetToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.UsedAt,
 		&i.CreatedAt,
 	)
 	return i, err
 }
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = $3
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   int32
+	CodeHash string
+	UsedAt   pgtype.Timestamp
+}
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useRecoveryCode, arg.UserID, arg.CodeHash, arg.UsedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useRefreshToken = `-- name: UseRefreshToken :one
+UPDATE refresh_tokens
+SET used_at = $2
+WHERE token_hash = $1 AND used_at IS NULL
+RETURNING token_hash, session_id, created_at, used_at
+`
+
+type UseRefreshTokenParams struct {
+	TokenHash string
+	UsedAt    pgtype.Timestamp
+}
+
+func (q *Queries) UseRefreshToken(ctx context.Context, arg UseRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, useRefreshToken, arg.TokenHash, arg.UsedAt)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.SessionID,
+		&i.CreatedAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE totp_credentials
+SET last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+`
+
+type UseTOTPStepParams struct {
+	UserID       int32
+	LastUsedStep int64
+}
+
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useTOTPStep, arg.UserID, arg.LastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useWebAuthnChallenge = `-- name: UseWebAuthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge_hash = $1 AND ceremony = $2 AND expires_at > $3
+RETURNING challenge_hash, user_id, ceremony, expires_at
+`
+
+type UseWebAuthnChallengeParams struct {
+	ChallengeHash string
+	Ceremony      string
+	ExpiresAt     pgtype.Timestamp
+}
+
+func (q *Queries) UseWebAuthnChallenge(ctx context.Context, arg UseWebAuthnChallengeParams) (WebauthnChallenge, error) {
+	row := q.db.QueryRow(ctx, useWebAuthnChallenge, arg.ChallengeHash, arg.Ceremony, arg.ExpiresAt)
+	var i WebauthnChallenge
+	err := row.Scan(
+		&i.ChallengeHash,
+		&i.UserID,
+		&i.Ceremony,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = TRUE
+WHERE id = $1 AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    int32
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.Exec(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
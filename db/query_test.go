@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCreateSession runs the query against the migrated schema, as the
+// generated code is not checked by the compiler against the SQL it sends.
+func TestCreateSession(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	queries := db.New(conn)
+	for _, name := range []string{"admin", "customer"} {
+		_, err := queries.CreateUser(ctx, db.CreateUserParams{Name: name, Email: name + "@example.com"})
+		if err != nil {
+			t.Fatalf("failed to create test user: %v", err)
+		}
+	}
+
+	now := pgtype.Timestamp{Time: time.Now().UTC().Truncate(time.Microsecond), Valid: true}
+	session, err := queries.CreateSession(ctx, db.CreateSessionParams{
+		ID:        "session",
+		UserID:    2,
+		ExpiresAt: pgtype.Timestamp{Time: now.Time.Add(time.Hour), Valid: true},
+		UserAgent: "test",
+		Ip:        "192.0.2.1",
+		CreatedAt: now,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, now, session.LastSeenAt, "a new session was last seen when it was created")
+	assert.False(t, session.ImpersonatorID.Valid)
+
+	impersonation, err := queries.CreateSession(ctx, db.CreateSessionParams{
+		ID:             "impersonation",
+		UserID:         2,
+		ExpiresAt:      pgtype.Timestamp{Time: now.Time.Add(time.Minute), Valid: true},
+		CreatedAt:      now,
+		ImpersonatorID: pgtype.Int4{Int32: 1, Valid: true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, pgtype.Int4{Int32: 1, Valid: true}, impersonation.ImpersonatorID)
+}
@@ -3,39 +3,82 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/Modul-306/backend/app"
 	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
 	"github.com/gorilla/mux"
 )
 
 type BaseHandler struct {
-	w        http.ResponseWriter
-	r        *http.Request
-	id       string
-	username string
+	w  http.ResponseWriter
+	r  *http.Request
+	id string
+	// principal is the resolved caller; it is only set behind IsAuthorized.
+	principal auth.Principal
+	app       *app.App
+	queries   db.Querier
 }
 
-func NewBaseHandler(w http.ResponseWriter, r *http.Request) BaseHandler {
+func NewBaseHandler(w http.ResponseWriter, r *http.Request, a *app.App) BaseHandler {
 	vars := mux.Vars(r)
+	principal, _ := auth.PrincipalFrom(r.Context())
 	return BaseHandler{
-		w:        w,
-		r:        r,
-		id:       vars["id"],
-		username: auth.GetUsername(r),
+		w:         w,
+		r:         r,
+		id:        vars["id"],
+		principal: principal,
+		app:       a,
+		queries:   a.Queries,
 	}
 }
 
+// Principal returns the authenticated caller. It is the zero Principal on
+// routes that are not behind IsAuthorized.
+func (h BaseHandler) Principal() auth.Principal {
+	return h.principal
+}
+
+// fail answers the request with the problem err maps to; see
+// problem.FromError. Internal errors are logged, as the response does not
+// show them.
+func (h BaseHandler) fail(err error) {
+	p := problem.FromError(err)
+	if p.Internal() {
+		h.app.Logger.Error("request failed", "method", h.r.Method, "path", h.r.URL.Path, "error", err)
+	}
+	problem.Write(h.w, p)
+}
+
 // HandlerFunc is a function that takes a BaseHandler
 type HandlerFunc func(BaseHandler)
 
 // WithBaseHandler wraps a HandlerFunc with BaseHandler creation
-func WithBaseHandler(handler HandlerFunc) http.HandlerFunc {
+func WithBaseHandler(a *app.App, handler HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		h := NewBaseHandler(w, r)
+		h := NewBaseHandler(w, r, a)
 		handler(h)
 	}
 }
 
 // WithAuthAndBase combines auth check and BaseHandler creation
-func WithAuthAndBase(handler HandlerFunc) http.HandlerFunc {
-	return auth.IsAuthorized(WithBaseHandler(handler))
+func WithAuthAndBase(a *app.App, handler HandlerFunc) http.HandlerFunc {
+	return auth.IsAuthorized(a, WithBaseHandler(a, handler))
+}
+
+// WithOwnAuthAndBase is WithAuthAndBase for routes that change the account
+// or its credentials, which admins may not use while impersonating.
+func WithOwnAuthAndBase(a *app.App, handler HandlerFunc) http.HandlerFunc {
+	return auth.IsAuthorized(a, auth.DenyImpersonation(WithBaseHandler(a, handler)))
+}
+
+// WithPermission is WithAuthAndBase for routes restricted to callers holding perm
+func WithPermission(a *app.App, perm auth.Permission, handler HandlerFunc) http.HandlerFunc {
+	return auth.IsAuthorized(a, auth.RequirePermission(perm, WithBaseHandler(a, handler)))
+}
+
+// WithVerifiedPermission is WithPermission for routes that also require a
+// verified email address.
+func WithVerifiedPermission(a *app.App, perm auth.Permission, handler HandlerFunc) http.HandlerFunc {
+	return auth.IsAuthorized(a, auth.RequirePermission(perm, auth.RequireVerifiedEmail(a, WithBaseHandler(a, handler))))
 }
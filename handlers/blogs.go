@@ -2,17 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
 )
 
 type BlogRequest struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	Path    string `json:"path"`
+	Title   string `json:"title" validate:"required,max=255"`
+	Content string `json:"content" validate:"required"`
+	Path    string `json:"path" validate:"required,max=255,path"`
 }
 
 type BlogResponse struct {
@@ -24,16 +24,9 @@ type BlogResponse struct {
 }
 
 func GetBlogs(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+	blogs, err := h.queries.GetBlogs(h.r.Context())
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	blogs, err := db.New(conn).GetBlogs(h.r.Context())
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -41,63 +34,30 @@ func GetBlogs(h BaseHandler) {
 }
 
 func GetBlog(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
 	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, "Invalid blog ID", http.StatusBadRequest)
+		problem.Error(h.w, "Invalid blog ID", http.StatusBadRequest)
 		return
 	}
 
-	blog, err := db.New(conn).GetBlog(h.r.Context(), int32(id))
+	blog, err := h.queries.GetBlog(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusNotFound)
+		h.fail(err)
 		return
 	}
 
 	json.NewEncoder(h.w).Encode(blog)
 }
 
-func CreateBlog(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	var req BlogRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	dbConn := db.New(conn)
-	users, err := dbConn.GetUsers(h.r.Context())
-	fmt.Println(users)
-
-	fmt.Println(h.username)
-	user, err := dbConn.GetUserByUsername(h.r.Context(), h.username)
-	fmt.Println(user)
-	fmt.Println(err)
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	blog, err := dbConn.CreateBlog(h.r.Context(), db.CreateBlogParams{
+func CreateBlog(h BaseHandler, req BlogRequest) {
+	blog, err := h.queries.CreateBlog(h.r.Context(), db.CreateBlogParams{
 		Title:   req.Title,
 		Content: req.Content,
-		UserID:  user.ID,
+		UserID:  h.principal.UserID,
 		Path:    req.Path,
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -105,43 +65,32 @@ func CreateBlog(h BaseHandler) {
 	json.NewEncoder(h.w).Encode(blog)
 }
 
-func UpdateBlog(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+func UpdateBlog(h BaseHandler, req BlogRequest) {
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	var req BlogRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid blog ID", http.StatusBadRequest)
 		return
 	}
 
-	dbConn := db.New(conn)
-
-	user, err := dbConn.GetUserByUsername(h.r.Context(), h.username)
+	existing, err := h.queries.GetBlog(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
-
-	id, err := strconv.Atoi(h.id)
-	if err != nil {
-		http.Error(h.w, "Invalid blog ID", http.StatusBadRequest)
+	if !h.authorizeOwner(blogOwnership, existing.UserID) {
 		return
 	}
 
-	blog, err := db.New(conn).UpdateBlog(h.r.Context(), db.UpdateBlogParams{
+	// The author stays the same even when an admin edits the blog.
+	blog, err := h.queries.UpdateBlog(h.r.Context(), db.UpdateBlogParams{
 		ID:      int32(id),
 		Title:   req.Title,
 		Content: req.Content,
-		UserID:  user.ID,
+		UserID:  existing.UserID,
 		Path:    req.Path,
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -149,22 +98,24 @@ func UpdateBlog(h BaseHandler) {
 }
 
 func DeleteBlog(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		problem.Error(h.w, "Invalid blog ID", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close(h.r.Context())
 
-	id, err := strconv.Atoi(h.id)
+	existing, err := h.queries.GetBlog(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, "Invalid blog ID", http.StatusBadRequest)
+		h.fail(err)
+		return
+	}
+	if !h.authorizeOwner(blogOwnership, existing.UserID) {
 		return
 	}
 
-	blog, err := db.New(conn).DeleteBlog(h.r.Context(), int32(id))
+	blog, err := h.queries.DeleteBlog(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -8,15 +8,15 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
 
-	"github.com/Modul-306/backend/auth"
-	"github.com/Modul-306/backend/db"
 	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
 	"github.com/Modul-306/backend/router"
 	"github.com/Modul-306/backend/tests/containers"
 	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,34 +29,38 @@ func TestGetBlogs(t *testing.T) {
 	defer postgres.Cleanup(t)
 
 	// Setup database
-	conn, err := db.CreateDBConnection()
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
 	if err != nil {
-		t.Fatalf("failed to create db connection: %v", err)
-		return
+		t.Fatalf("failed to connect to database: %v", err)
 	}
 	defer conn.Close(context.Background())
 
 	testhelpers.SetupTestDB(t, conn)
 	defer testhelpers.CleanupTestDB(t, conn)
 
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
 	// Setup test user and get auth token
 	_, err = conn.Exec(context.Background(), `
-        INSERT INTO users (name, password, email) 
-        VALUES ('testuser', 'password', 'test@example.com')
+        INSERT INTO users (name, password, email, roles) 
+        VALUES ('testuser', 'password', 'test@example.com', '{customer,editor}')
     `)
 	if err != nil {
 		t.Fatalf("failed to create test user: %v", err)
 	}
 
-	token, err := auth.CreateToken("testuser", time.Now().Add(time.Hour))
+	authCookie := testhelpers.SessionCookie(t, a, 1, "customer", "editor")
+
+	// A second editor, who may write blogs but not someone else's
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email, roles) 
+        VALUES ('othereditor', 'password', 'other@example.com', '{customer,editor}')
+    `)
 	if err != nil {
-		t.Fatalf("failed to create auth token: %v", err)
+		t.Fatalf("failed to create test user: %v", err)
 	}
 
-	authCookie := &http.Cookie{
-		Name:  "token",
-		Value: token,
-	}
+	otherCookie := testhelpers.SessionCookie(t, a, 2, "customer", "editor")
 
 	tests := []struct {
 		name      string
@@ -111,6 +115,60 @@ func TestGetBlogs(t *testing.T) {
 				assert.Equal(t, "test", blog.Title)
 			},
 		},
+		{
+			name: "GetBlog missing",
+			setup: func() *http.Request {
+				return httptest.NewRequest("GET", "/api/v1/blogs/999", nil)
+			},
+			wantCode: http.StatusNotFound,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, problem.ContentType, rec.Header().Get("Content-Type"))
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, problem.CodeNotFound, response.Code)
+				assert.NotContains(t, response.Detail, "no rows")
+			},
+		},
+		{
+			name: "CreateBlog with too long title",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.BlogRequest{
+					Title:   strings.Repeat("a", 256),
+					Content: "test",
+					Path:    "/long",
+				})
+				req := httptest.NewRequest("POST", "/api/v1/blogs", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, problem.CodeValidation, response.Code)
+				assert.Equal(t, []string{"title"}, fields(response))
+				assert.Equal(t, "max", response.Errors[0].Rule)
+			},
+		},
+		{
+			name: "CreateBlog with invalid path",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.BlogRequest{
+					Title:   "test",
+					Content: "test",
+					Path:    "/../admin",
+				})
+				req := httptest.NewRequest("POST", "/api/v1/blogs", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, []string{"path"}, fields(response))
+			},
+		},
 		{
 			name: "UpdateBlog",
 			setup: func() *http.Request {
@@ -132,6 +190,31 @@ func TestGetBlogs(t *testing.T) {
 				assert.Equal(t, "Updated Blog", blog.Title)
 			},
 		},
+		{
+			name: "UpdateBlog as other editor",
+			setup: func() *http.Request {
+				blog := handlers.BlogRequest{
+					Title:   "Hijacked",
+					Content: "Hijacked",
+					Path:    "/hijacked",
+				}
+				body, _ := json.Marshal(blog)
+				req := httptest.NewRequest("UPDATE", "/api/v1/blogs/1", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(otherCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "DeleteBlog as other editor",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("DELETE", "/api/v1/blogs/1", nil)
+				req.AddCookie(otherCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
 		{
 			name: "DeleteBlog",
 			setup: func() *http.Request {
@@ -145,10 +228,10 @@ func TestGetBlogs(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := tt.setup()
+			req := testhelpers.WithCSRF(a, tt.setup())
 			rec := httptest.NewRecorder()
 
-			sut := router.CreateRouter()
+			sut := router.CreateRouter(a)
 
 			// Act
 			// changed act - calling GetById through production router
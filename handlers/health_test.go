@@ -0,0 +1,67 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestGetPoolStats(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email, roles)
+        VALUES ('admin', 'password', 'admin@example.com', '{customer,admin}'),
+               ('customer', 'password', 'customer@example.com', '{customer}')
+    `)
+	if err != nil {
+		t.Fatalf("failed to create test users: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		wantCode int
+	}{
+		{name: "anonymous", wantCode: http.StatusUnauthorized},
+		{name: "customer", cookie: testhelpers.SessionCookie(t, a, 2, "customer"), wantCode: http.StatusForbidden},
+		{name: "admin", cookie: testhelpers.SessionCookie(t, a, 1, "customer", "admin"), wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/health/db", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rec := httptest.NewRecorder()
+
+			router.CreateRouter(a).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("%s status = %v, want %v", tt.name, rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
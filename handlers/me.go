@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+)
+
+// MeRequest updates the caller's own profile. Password and email have
+// endpoints of their own, as both need the current password.
+type MeRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// GetMe returns the signed-in user.
+func GetMe(h BaseHandler) {
+	user, ok := h.me()
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(h.w).Encode(newUserResponse(user))
+}
+
+// UpdateMe changes the profile of the signed-in user.
+// Like the other changes to the account, it needs a session.
+func UpdateMe(h BaseHandler, req MeRequest) {
+	if h.principal.SessionID == "" {
+		problem.Error(h.w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	existing, ok := h.me()
+	if !ok {
+		return
+	}
+
+	user, err := h.queries.UpdateUser(h.r.Context(), db.UpdateUserParams{
+		ID:       existing.ID,
+		Name:     req.Name,
+		Password: existing.Password,
+		Email:    existing.Email,
+		Roles:    existing.Roles,
+	})
+	if auth.UserConflict(h.w, err) {
+		return
+	}
+	if err != nil {
+		h.fail(err)
+		return
+	}
+
+	json.NewEncoder(h.w).Encode(newUserResponse(user))
+}
+
+// me loads the caller. A principal whose user is gone is answered 401.
+func (h BaseHandler) me() (db.User, bool) {
+	user, err := h.queries.GetUser(h.r.Context(), h.principal.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		problem.Error(h.w, "", http.StatusUnauthorized)
+		return db.User{}, false
+	}
+	if err != nil {
+		h.fail(err)
+		return db.User{}, false
+	}
+	return user, true
+}
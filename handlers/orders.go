@@ -5,12 +5,14 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/Modul-306/backend/auth"
 	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type OrderRequest struct {
-	Address     string `json:"address"`
+	Address     string `json:"address" validate:"required,max=1000"`
 	IsCompleted bool   `json:"is_completed"`
 }
 
@@ -21,17 +23,18 @@ type OrderResponse struct {
 	IsCompleted bool   `json:"is_completed"`
 }
 
+// GetOrders lists every order for callers allowed to see all of them, and
+// the caller's own orders otherwise.
 func GetOrders(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
+	var orders []db.Order
+	var err error
+	if h.principal.Can(auth.PermOrdersReadAll) {
+		orders, err = h.queries.GetOrders(h.r.Context())
+	} else {
+		orders, err = h.queries.GetOrdersByUser(h.r.Context(), h.principal.UserID)
 	}
-	defer conn.Close(h.r.Context())
-
-	orders, err := db.New(conn).GetOrders(h.r.Context())
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -39,56 +42,31 @@ func GetOrders(h BaseHandler) {
 }
 
 func GetOrder(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		problem.Error(h.w, "Invalid order ID", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close(h.r.Context())
 
-	id, err := strconv.Atoi(h.id)
+	order, err := h.queries.GetOrder(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, "Invalid order ID", http.StatusBadRequest)
+		h.fail(err)
 		return
 	}
-
-	order, err := db.New(conn).GetOrder(h.r.Context(), int32(id))
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusNotFound)
+	if !h.authorizeOwner(orderReadOwnership, order.UserID) {
 		return
 	}
 
 	json.NewEncoder(h.w).Encode(order)
 }
 
-func CreateOrder(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	var req OrderRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	dbConn := db.New(conn)
-
-	user, err := dbConn.GetUserByUsername(h.r.Context(), h.username)
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	order, err := dbConn.CreateOrder(h.r.Context(), db.CreateOrderParams{
+func CreateOrder(h BaseHandler, req OrderRequest) {
+	order, err := h.queries.CreateOrder(h.r.Context(), db.CreateOrderParams{
 		Address: req.Address,
-		UserID:  user.ID,
+		UserID:  h.principal.UserID,
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -103,49 +81,37 @@ func CreateOrder(h BaseHandler) {
 	json.NewEncoder(h.w).Encode(response)
 }
 
-func UpdateOrder(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+func UpdateOrder(h BaseHandler, req OrderRequest) {
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	var req OrderRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid order ID", http.StatusBadRequest)
 		return
 	}
 
-	dbConn := db.New(conn)
-
-	user, err := dbConn.GetUserByUsername(h.r.Context(), h.username)
+	existing, err := h.queries.GetOrder(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
-
-	id, err := strconv.Atoi(h.id)
-	if err != nil {
-		http.Error(h.w, "Invalid order ID", http.StatusBadRequest)
+	if !h.authorizeOwner(orderOwnership, existing.UserID) {
 		return
 	}
 
 	var isCompleted pgtype.Bool
 	err = isCompleted.Scan(req.IsCompleted)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid completion state", http.StatusBadRequest)
 		return
 	}
 
-	order, err := dbConn.UpdateOrder(h.r.Context(), db.UpdateOrderParams{
+	order, err := h.queries.UpdateOrder(h.r.Context(), db.UpdateOrderParams{
 		ID:          int32(id),
 		Address:     req.Address,
-		UserID:      user.ID,
+		UserID:      existing.UserID,
 		IsCompleted: isCompleted,
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 	response := OrderResponse{
@@ -160,22 +126,24 @@ func UpdateOrder(h BaseHandler) {
 }
 
 func DeleteOrder(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		problem.Error(h.w, "Invalid order ID", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close(h.r.Context())
 
-	id, err := strconv.Atoi(h.id)
+	existing, err := h.queries.GetOrder(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, "Invalid order ID", http.StatusBadRequest)
+		h.fail(err)
+		return
+	}
+	if !h.authorizeOwner(orderOwnership, existing.UserID) {
 		return
 	}
 
-	order, err := db.New(conn).DeleteOrder(h.r.Context(), int32(id))
+	order, err := h.queries.DeleteOrder(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -7,10 +7,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
-	"time"
 
-	"github.com/Modul-306/backend/auth"
 	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
 	"github.com/Modul-306/backend/router"
 	"github.com/Modul-306/backend/tests/containers"
 	"github.com/Modul-306/backend/tests/testhelpers"
@@ -35,24 +34,30 @@ func TestOrderHandlers(t *testing.T) {
 	testhelpers.SetupTestDB(t, conn)
 	defer testhelpers.CleanupTestDB(t, conn)
 
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
 	// Setup test user and get auth token
 	_, err = conn.Exec(context.Background(), `
-        INSERT INTO users (name, password, email) 
-        VALUES ('testuser', 'password', 'test@example.com')
+        INSERT INTO users (name, password, email, email_verified) 
+        VALUES ('testuser', 'password', 'test@example.com', TRUE)
     `)
 	if err != nil {
 		t.Fatalf("failed to create test user: %v", err)
 	}
 
-	token, err := auth.CreateToken("testuser", time.Now().Add(time.Hour))
+	authCookie := testhelpers.SessionCookie(t, a, 1, "customer")
+
+	// A second customer, who must not see the first one's orders and has
+	// not verified their email
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email) 
+        VALUES ('othercustomer', 'password', 'other@example.com')
+    `)
 	if err != nil {
-		t.Fatalf("failed to create auth token: %v", err)
+		t.Fatalf("failed to create test user: %v", err)
 	}
 
-	authCookie := &http.Cookie{
-		Name:  "token",
-		Value: token,
-	}
+	otherCookie := testhelpers.SessionCookie(t, a, 2, "customer")
 
 	tests := []struct {
 		name      string
@@ -93,6 +98,34 @@ func TestOrderHandlers(t *testing.T) {
 				assert.Equal(t, "123 Test St", order.Address)
 			},
 		},
+		{
+			name: "CreateOrder without address",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.OrderRequest{})
+				req := httptest.NewRequest("POST", "/api/v1/order", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, []string{"address"}, fields(response))
+				assert.Equal(t, "required", response.Errors[0].Rule)
+			},
+		},
+		{
+			name: "CreateOrder with unverified email",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.OrderRequest{Address: "789 Other St"})
+				req := httptest.NewRequest("POST", "/api/v1/order", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(otherCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
 		{
 			name: "GetOrder",
 			setup: func() *http.Request {
@@ -128,6 +161,36 @@ func TestOrderHandlers(t *testing.T) {
 				assert.True(t, order.IsCompleted)
 			},
 		},
+		{
+			name: "GetOrder as other customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("GET", "/api/v1/order/1", nil)
+				req.AddCookie(otherCookie)
+				return req
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name: "UpdateOrder as other customer",
+			setup: func() *http.Request {
+				order := handlers.OrderRequest{Address: "789 Hijacked St"}
+				body, _ := json.Marshal(order)
+				req := httptest.NewRequest("UPDATE", "/api/v1/order/1", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(otherCookie)
+				return req
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name: "DeleteOrder as other customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("DELETE", "/api/v1/order/1", nil)
+				req.AddCookie(otherCookie)
+				return req
+			},
+			wantCode: http.StatusNotFound,
+		},
 		{
 			name: "DeleteOrder",
 			setup: func() *http.Request {
@@ -141,10 +204,10 @@ func TestOrderHandlers(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := tt.setup()
+			req := testhelpers.WithCSRF(a, tt.setup())
 			rec := httptest.NewRecorder()
 
-			sut := router.CreateRouter()
+			sut := router.CreateRouter(a)
 
 			// Act
 			// changed act - calling GetById through production router
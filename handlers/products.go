@@ -7,13 +7,15 @@ import (
 	"strconv"
 
 	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type ProductRequest struct {
-	Name        string  `json:"name"`
-	Price       float64 `json:"price"`
-	ImageURL    string  `json:"image_url"`
+	Name string `json:"name" validate:"required,max=255"`
+	// Price fits the DECIMAL(10, 2) column.
+	Price       float64 `json:"price" validate:"min=0,max=99999999.99"`
+	ImageURL    string  `json:"image_url" validate:"required,url"`
 	IsAvailable bool    `json:"is_available"`
 }
 
@@ -26,16 +28,9 @@ type ProductResponse struct {
 }
 
 func GetProducts(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	products, err := db.New(conn).GetProducts(h.r.Context())
+	products, err := h.queries.GetProducts(h.r.Context())
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -43,64 +38,44 @@ func GetProducts(h BaseHandler) {
 }
 
 func GetProduct(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
 	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, "Invalid product ID", http.StatusBadRequest)
+		problem.Error(h.w, "Invalid product ID", http.StatusBadRequest)
 		return
 	}
 
-	product, err := db.New(conn).GetProduct(h.r.Context(), int32(id))
+	product, err := h.queries.GetProduct(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusNotFound)
+		h.fail(err)
 		return
 	}
 
 	json.NewEncoder(h.w).Encode(product)
 }
 
-func CreateProduct(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	var req ProductRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
+func CreateProduct(h BaseHandler, req ProductRequest) {
 	var price pgtype.Numeric
-	err = price.Scan(fmt.Sprintf("%.2f", req.Price))
+	err := price.Scan(fmt.Sprintf("%.2f", req.Price))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid price", http.StatusBadRequest)
 		return
 	}
 
 	isAvailable := pgtype.Bool{}
 	err = isAvailable.Scan(req.IsAvailable)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid availability", http.StatusBadRequest)
 		return
 	}
 
-	product, err := db.New(conn).CreateProduct(h.r.Context(), db.CreateProductParams{
+	product, err := h.queries.CreateProduct(h.r.Context(), db.CreateProductParams{
 		Name:        req.Name,
 		Price:       price,
 		ImageUrl:    req.ImageURL,
 		IsAvailable: isAvailable,
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -108,41 +83,28 @@ func CreateProduct(h BaseHandler) {
 	json.NewEncoder(h.w).Encode(product)
 }
 
-func UpdateProduct(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
-	var req ProductRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
+func UpdateProduct(h BaseHandler, req ProductRequest) {
 	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, "Invalid product ID", http.StatusBadRequest)
+		problem.Error(h.w, "Invalid product ID", http.StatusBadRequest)
 		return
 	}
 
 	var price pgtype.Numeric
 	err = price.Scan(fmt.Sprintf("%.2f", req.Price))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid price", http.StatusBadRequest)
 		return
 	}
 
 	isAvailable := pgtype.Bool{}
 	err = isAvailable.Scan(req.IsAvailable)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		problem.Error(h.w, "Invalid availability", http.StatusBadRequest)
 		return
 	}
 
-	product, err := db.New(conn).UpdateProduct(h.r.Context(), db.UpdateProductParams{
+	product, err := h.queries.UpdateProduct(h.r.Context(), db.UpdateProductParams{
 		ID:          int32(id),
 		Name:        req.Name,
 		Price:       price,
@@ -150,7 +112,7 @@ func UpdateProduct(h BaseHandler) {
 		IsAvailable: isAvailable,
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
@@ -158,22 +120,15 @@ func UpdateProduct(h BaseHandler) {
 }
 
 func DeleteProduct(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer conn.Close(h.r.Context())
-
 	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, "Invalid product ID", http.StatusBadRequest)
+		problem.Error(h.w, "Invalid product ID", http.StatusBadRequest)
 		return
 	}
 
-	product, err := db.New(conn).DeleteProduct(h.r.Context(), int32(id))
+	product, err := h.queries.DeleteProduct(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
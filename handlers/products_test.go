@@ -6,11 +6,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
 
-	"github.com/Modul-306/backend/auth"
 	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
 	"github.com/Modul-306/backend/router"
 	"github.com/Modul-306/backend/tests/containers"
 	"github.com/Modul-306/backend/tests/testhelpers"
@@ -36,25 +36,29 @@ func TestProductHandlers(t *testing.T) {
 	testhelpers.SetupTestDB(t, conn)
 	defer testhelpers.CleanupTestDB(t, conn)
 
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
 	// Create test user and get token
-	hashedPassword, _ := auth.HashPassword("testpass")
+	hashedPassword, _ := a.Passwords.Hash("testpass")
 	_, err = conn.Exec(context.Background(), `
-        INSERT INTO users (name, password, email, is_admin) 
+        INSERT INTO users (name, password, email, roles) 
         VALUES ($1, $2, $3, $4)
-        RETURNING id`, "testuser", hashedPassword, "test@example.com", true)
+        RETURNING id`, "testuser", hashedPassword, "test@example.com", []string{"customer", "admin"})
 	if err != nil {
 		t.Fatalf("failed to create test user: %v", err)
 	}
 
-	token, err := auth.CreateToken("testuser", time.Now().Add(time.Hour))
+	authCookie := testhelpers.SessionCookie(t, a, 1, "customer", "admin")
+
+	// Create a customer without admin rights
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email) 
+        VALUES ($1, $2, $3)`, "customer", hashedPassword, "customer@example.com")
 	if err != nil {
-		t.Fatalf("failed to create auth token: %v", err)
+		t.Fatalf("failed to create customer: %v", err)
 	}
 
-	authCookie := &http.Cookie{
-		Name:  "token",
-		Value: token,
-	}
+	customerCookie := testhelpers.SessionCookie(t, a, 2, "customer")
 
 	tests := []struct {
 		name      string
@@ -73,13 +77,30 @@ func TestProductHandlers(t *testing.T) {
 				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&products))
 			},
 		},
+		{
+			name: "CreateProduct as customer",
+			setup: func() *http.Request {
+				product := handlers.ProductRequest{
+					Name:        "Forbidden Product",
+					Price:       1,
+					ImageURL:    "https://example.com/test.jpg",
+					IsAvailable: true,
+				}
+				body, _ := json.Marshal(product)
+				req := httptest.NewRequest("POST", "/api/v1/products", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
 		{
 			name: "CreateProduct",
 			setup: func() *http.Request {
 				product := handlers.ProductRequest{
 					Name:        "New Product",
 					Price:       29.99,
-					ImageURL:    "test.jpg",
+					ImageURL:    "https://example.com/test.jpg",
 					IsAvailable: true,
 				}
 				body, _ := json.Marshal(product)
@@ -95,6 +116,59 @@ func TestProductHandlers(t *testing.T) {
 				assert.Equal(t, "New Product", product.Name)
 			},
 		},
+		{
+			name: "CreateProduct with invalid fields",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.ProductRequest{
+					Price:    -1,
+					ImageURL: "test.jpg",
+				})
+				req := httptest.NewRequest("POST", "/api/v1/products", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.Equal(t, problem.ContentType, rec.Header().Get("Content-Type"))
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, problem.CodeValidation, response.Code)
+				assert.Equal(t, []string{"name", "price", "image_url"}, fields(response))
+			},
+		},
+		{
+			name: "CreateProduct with unknown field",
+			setup: func() *http.Request {
+				body := `{"name": "Product", "price": 1, "image_url": "https://example.com/a.jpg", "is_admin": true}`
+				req := httptest.NewRequest("POST", "/api/v1/products", strings.NewReader(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusBadRequest,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Contains(t, response.Detail, "is_admin")
+			},
+		},
+		{
+			name: "CreateProduct with too large body",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.ProductRequest{
+					Name:     strings.Repeat("a", a.Config.Server.MaxBodyBytes),
+					ImageURL: "https://example.com/a.jpg",
+				})
+				req := httptest.NewRequest("POST", "/api/v1/products", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusRequestEntityTooLarge,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, problem.CodeBodyTooLarge, response.Code)
+			},
+		},
 		{
 			name: "GetProduct",
 			setup: func() *http.Request {
@@ -113,7 +187,7 @@ func TestProductHandlers(t *testing.T) {
 				product := handlers.ProductRequest{
 					Name:        "Updated Product",
 					Price:       39.99,
-					ImageURL:    "updated.jpg",
+					ImageURL:    "https://example.com/updated.jpg",
 					IsAvailable: true,
 				}
 				body, _ := json.Marshal(product)
@@ -129,6 +203,15 @@ func TestProductHandlers(t *testing.T) {
 				assert.Equal(t, "Updated Product", product.Name)
 			},
 		},
+		{
+			name: "DeleteProduct as customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("DELETE", "/api/v1/products/1", nil)
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
 		{
 			name: "DeleteProduct",
 			setup: func() *http.Request {
@@ -142,10 +225,10 @@ func TestProductHandlers(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := tt.setup()
+			req := testhelpers.WithCSRF(a, tt.setup())
 			rec := httptest.NewRecorder()
 
-			sut := router.CreateRouter()
+			sut := router.CreateRouter(a)
 
 			// Act
 			// changed act - calling GetById through production router
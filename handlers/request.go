@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/validate"
+)
+
+// LimitBody returns middleware that refuses request bodies over max bytes
+// with 413. Bodies without a declared length fail once read past max.
+func LimitBody(max int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > int64(max) {
+				problem.Write(w, problem.FromError(&http.MaxBytesError{Limit: int64(max)}))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, int64(max))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithRequest decodes the JSON body of the request into a T and checks it
+// against the validate tags of T, so that handler only sees valid
+// requests. Unknown fields and trailing data are refused with 400; broken
+// rules are answered with a single 422 listing all of them.
+func WithRequest[T any](handler func(BaseHandler, T)) HandlerFunc {
+	return func(h BaseHandler) {
+		var req T
+		if err := decodeJSON(h.r.Body, &req); err != nil {
+			h.fail(err)
+			return
+		}
+		if err := validate.Struct(req); err != nil {
+			h.fail(err)
+			return
+		}
+		handler(h, req)
+	}
+}
+
+// decodeJSON strictly decodes a single JSON value from body into v. Errors
+// other than an oversized body are problems that say what is wrong
+// without echoing Go types.
+func decodeJSON(body io.Reader, v any) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		if dec.Decode(&struct{}{}) != io.EOF {
+			return problem.New(http.StatusBadRequest, "", "The body must hold a single JSON value")
+		}
+		return nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return err
+	case errors.Is(err, io.EOF):
+		return problem.New(http.StatusBadRequest, "", "The body must not be empty")
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return problem.New(http.StatusBadRequest, "", "The body is not valid JSON")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return problem.New(http.StatusBadRequest, "", fmt.Sprintf("Field %q must be a JSON %s", typeErr.Field, jsonType(typeErr.Type)))
+	}
+	// Unknown fields are only told apart by message.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return problem.New(http.StatusBadRequest, "", "Unknown field "+field)
+	}
+	return problem.New(http.StatusBadRequest, "", "The body does not match the expected fields")
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return "number"
+}
@@ -0,0 +1,64 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+// fields returns the fields of the broken rules in a validation problem.
+func fields(p problem.Problem) []string {
+	var names []string
+	for _, e := range p.Errors {
+		names = append(names, e.Field)
+	}
+	return names
+}
+
+func TestLimitBody(t *testing.T) {
+	echo := handlers.LimitBody(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			problem.Write(w, problem.FromError(err))
+			return
+		}
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		name     string
+		body     string
+		unsized  bool
+		wantCode int
+	}{
+		{name: "within limit", body: "12345678", wantCode: http.StatusOK},
+		{name: "declared too large", body: "123456789", wantCode: http.StatusRequestEntityTooLarge},
+		{name: "read too large", body: "123456789", unsized: true, wantCode: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.unsized {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+
+			echo.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusRequestEntityTooLarge {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, problem.CodeBodyTooLarge, response.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type PersonalAccessTokenRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	// Scopes are permissions such as orders:read. The token can never do
+	// more than its owner's roles allow.
+	Scopes    []string   `json:"scopes" validate:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type PersonalAccessTokenResponse struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Token is only returned when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func newPersonalAccessTokenResponse(pat db.PersonalAccessToken) PersonalAccessTokenResponse {
+	return PersonalAccessTokenResponse{
+		ID:         int(pat.ID),
+		Name:       pat.Name,
+		Scopes:     pat.Scopes,
+		ExpiresAt:  optionalTime(pat.ExpiresAt),
+		LastUsedAt: optionalTime(pat.LastUsedAt),
+		CreatedAt:  pat.CreatedAt.Time,
+	}
+}
+
+func optionalTime(ts pgtype.Timestamp) *time.Time {
+	if !ts.Valid {
+		return nil
+	}
+	return &ts.Time
+}
+
+// GetPersonalAccessTokens lists the caller's tokens without their secrets.
+func GetPersonalAccessTokens(h BaseHandler) {
+	pats, err := h.queries.GetPersonalAccessTokensByUser(h.r.Context(), h.principal.UserID)
+	if err != nil {
+		h.fail(err)
+		return
+	}
+
+	response := make([]PersonalAccessTokenResponse, 0, len(pats))
+	for _, pat := range pats {
+		response = append(response, newPersonalAccessTokenResponse(pat))
+	}
+
+	json.NewEncoder(h.w).Encode(response)
+}
+
+func CreatePersonalAccessToken(h BaseHandler, req PersonalAccessTokenRequest) {
+	for _, scope := range req.Scopes {
+		if !auth.ValidPermission(scope) {
+			problem.Error(h.w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+		// Also stops a token from minting a token with wider scopes.
+		if !h.principal.Can(auth.Permission(scope)) {
+			problem.Error(h.w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	var expiresAt pgtype.Timestamp
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(h.app.Clock()) {
+			problem.Error(h.w, "Expiry must be in the future", http.StatusBadRequest)
+			return
+		}
+		expiresAt = pgtype.Timestamp{Time: req.ExpiresAt.UTC(), Valid: true}
+	}
+
+	secret, hash, err := auth.NewPersonalAccessToken()
+	if err != nil {
+		h.fail(err)
+		return
+	}
+
+	pat, err := h.queries.CreatePersonalAccessToken(h.r.Context(), db.CreatePersonalAccessTokenParams{
+		UserID:    h.principal.UserID,
+		Name:      req.Name,
+		TokenHash: hash,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		h.fail(err)
+		return
+	}
+
+	response := newPersonalAccessTokenResponse(pat)
+	response.Token = secret
+
+	h.w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(h.w).Encode(response)
+}
+
+// DeletePersonalAccessToken revokes one of the caller's tokens.
+func DeletePersonalAccessToken(h BaseHandler) {
+	id, err := strconv.Atoi(h.id)
+	if err != nil {
+		problem.Error(h.w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.queries.DeletePersonalAccessToken(h.r.Context(), db.DeletePersonalAccessTokenParams{
+		ID:     int32(id),
+		UserID: h.principal.UserID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			problem.Error(h.w, "Token not found", http.StatusNotFound)
+			return
+		}
+		h.fail(err)
+		return
+	}
+
+	h.w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,249 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/router"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/Modul-306/backend/tests/testhelpers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersonalAccessTokenHandlers(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	conn, err := pgx.Connect(context.Background(), postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	testhelpers.SetupTestDB(t, conn)
+	defer testhelpers.CleanupTestDB(t, conn)
+
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
+	// Setup test user and get auth token
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email)
+        VALUES ('testuser', 'password', 'test@example.com')
+    `)
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+
+	authCookie := testhelpers.SessionCookie(t, a, 1, "customer")
+
+	// Filled in by the CreatePersonalAccessToken case
+	var pat string
+
+	bearer := func(req *http.Request, token string) *http.Request {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	tests := []struct {
+		name      string
+		setup     func() *http.Request
+		wantCode  int
+		validator func(*testing.T, *httptest.ResponseRecorder)
+	}{
+		{
+			name: "CreatePersonalAccessToken",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.PersonalAccessTokenRequest{
+					Name:   "script",
+					Scopes: []string{"orders:read"},
+				})
+				req := httptest.NewRequest("POST", "/api/v1/tokens", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusCreated,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response handlers.PersonalAccessTokenResponse
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.True(t, strings.HasPrefix(response.Token, "pat_"))
+				assert.Equal(t, []string{"orders:read"}, response.Scopes)
+				pat = response.Token
+			},
+		},
+		{
+			name: "CreatePersonalAccessToken beyond own roles",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.PersonalAccessTokenRequest{
+					Name:   "escalate",
+					Scopes: []string{"products:write"},
+				})
+				req := httptest.NewRequest("POST", "/api/v1/tokens", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "CreatePersonalAccessToken with unknown scope",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.PersonalAccessTokenRequest{
+					Name:   "typo",
+					Scopes: []string{"order:read"},
+				})
+				req := httptest.NewRequest("POST", "/api/v1/tokens", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "CreatePersonalAccessToken already expired",
+			setup: func() *http.Request {
+				expired := time.Now().Add(-time.Hour)
+				body, _ := json.Marshal(handlers.PersonalAccessTokenRequest{
+					Name:      "expired",
+					Scopes:    []string{"orders:read"},
+					ExpiresAt: &expired,
+				})
+				req := httptest.NewRequest("POST", "/api/v1/tokens", bytes.NewBuffer(body))
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "GetOrders with personal access token",
+			setup: func() *http.Request {
+				return bearer(httptest.NewRequest("GET", "/api/v1/order", nil), pat)
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "CreateOrder outside token scopes",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.OrderRequest{Address: "123 Test St"})
+				req := httptest.NewRequest("POST", "/api/v1/order", bytes.NewBuffer(body))
+				return bearer(req, pat)
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Token cannot mint wider token",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.PersonalAccessTokenRequest{
+					Name:   "wider",
+					Scopes: []string{"orders:write"},
+				})
+				req := httptest.NewRequest("POST", "/api/v1/tokens", bytes.NewBuffer(body))
+				return bearer(req, pat)
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "UpdateUser outside token scopes",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.UserRequest{Name: "renamed", Email: "test@example.com"})
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/1", bytes.NewBuffer(body))
+				return bearer(req, pat)
+			},
+			wantCode: http.StatusForbidden,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				user, err := a.Queries.GetUser(context.Background(), 1)
+				assert.NoError(t, err)
+				assert.Equal(t, "testuser", user.Name)
+			},
+		},
+		{
+			name: "UpdateMe outside token scopes",
+			setup: func() *http.Request {
+				body, _ := json.Marshal(handlers.MeRequest{Name: "renamed"})
+				req := httptest.NewRequest("UPDATE", "/api/v1/me", bytes.NewBuffer(body))
+				return bearer(req, pat)
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "DeleteUser outside token scopes",
+			setup: func() *http.Request {
+				return bearer(httptest.NewRequest("DELETE", "/api/v1/user/1", nil), pat)
+			},
+			wantCode: http.StatusForbidden,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				_, err := a.Queries.GetUser(context.Background(), 1)
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name: "GetOrders with access token as bearer",
+			setup: func() *http.Request {
+				return bearer(httptest.NewRequest("GET", "/api/v1/order", nil), authCookie.Value)
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "GetPersonalAccessTokens",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("GET", "/api/v1/tokens", nil)
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusOK,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.NotContains(t, rec.Body.String(), pat)
+				var response []handlers.PersonalAccessTokenResponse
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				if assert.Len(t, response, 1) {
+					assert.Empty(t, response[0].Token)
+					assert.NotNil(t, response[0].LastUsedAt, "last use is recorded")
+				}
+			},
+		},
+		{
+			name: "DeletePersonalAccessToken",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("DELETE", "/api/v1/tokens/1", nil)
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name: "GetOrders with revoked personal access token",
+			setup: func() *http.Request {
+				return bearer(httptest.NewRequest("GET", "/api/v1/order", nil), pat)
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testhelpers.WithCSRF(a, tt.setup())
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			sut := router.CreateRouter(a)
+
+			sut.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("%s status = %v, want %v", tt.name, rec.Code, tt.wantCode)
+			}
+
+			if tt.validator != nil {
+				tt.validator(t, rec)
+			}
+		})
+	}
+}
@@ -3,128 +3,274 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Modul-306/backend/audit"
+	"github.com/Modul-306/backend/auth"
 	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type UserRequest struct {
-	Name     string `json:"name"`
+	Name string `json:"name" validate:"required,max=255"`
+	// Password is checked against the password policy when set.
 	Password string `json:"password"`
-	Email    string `json:"email"`
-	IsAdmin  bool   `json:"is_admin"`
+	Email    string `json:"email" validate:"required,max=255,email"`
+	IsAdmin  *bool  `json:"is_admin,omitempty"`
+	// Roles replaces the user's roles. When empty, the roles are kept unless
+	// IsAdmin grants or revokes the admin role.
+	Roles []string `json:"roles"`
 }
 
+// UserResponse is how users are shown by the API. It never carries the
+// password hash.
 type UserResponse struct {
-	ID      int    `json:"id"`
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	IsAdmin bool   `json:"is_admin"`
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	IsAdmin       bool      `json:"is_admin"`
+	Roles         []string  `json:"roles"`
+	CreatedAt     time.Time `json:"created_at"`
+	// DeletionScheduledAt is set when the user deleted their account; it is
+	// purged then unless they sign in before.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 }
 
-func GetUsers(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
-	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
-		return
+func newUserResponse(user db.User) UserResponse {
+	return UserResponse{
+		ID:            int(user.ID),
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		IsAdmin:       slices.Contains(user.Roles, string(auth.RoleAdmin)),
+		Roles:         user.Roles,
+		CreatedAt:     user.CreatedAt.Time,
+
+		DeletionScheduledAt: optionalTime(user.DeletionScheduledAt),
 	}
-	defer conn.Close(h.r.Context())
+}
 
-	users, err := db.New(conn).GetUsers(h.r.Context())
+func GetUsers(h BaseHandler) {
+	users, err := h.queries.GetUsers(h.r.Context())
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
-	json.NewEncoder(h.w).Encode(users)
+	response := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newUserResponse(user))
+	}
+	json.NewEncoder(h.w).Encode(response)
 }
 
 func GetUser(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		problem.Error(h.w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close(h.r.Context())
+	if !h.authorizeOwner(userOwnership, int32(id)) {
+		return
+	}
+
+	user, err := h.queries.GetUser(h.r.Context(), int32(id))
+	if err != nil {
+		h.fail(err)
+		return
+	}
+
+	json.NewEncoder(h.w).Encode(newUserResponse(user))
+}
 
+func DeleteUser(h BaseHandler) {
 	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, "Invalid user ID", http.StatusBadRequest)
+		problem.Error(h.w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !h.authorizeOwner(userOwnership, int32(id)) {
+		return
+	}
+
+	// Owners delete their account through DELETE /api/v1/me, which asks for
+	// the password and keeps the account through the grace period.
+	if !h.principal.Can(userOwnership.override) {
+		problem.Error(h.w, "Delete your own account through DELETE /api/v1/me", http.StatusForbidden)
 		return
 	}
 
-	user, err := db.New(conn).GetUser(h.r.Context(), int32(id))
+	_, err = h.queries.DeleteUser(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusNotFound)
+		h.fail(err)
 		return
 	}
 
-	json.NewEncoder(h.w).Encode(user)
+	h.w.WriteHeader(http.StatusNoContent)
 }
 
-func DeleteUser(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+func UpdateUser(h BaseHandler, req UserRequest) {
+	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		problem.Error(h.w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close(h.r.Context())
 
-	id, err := strconv.Atoi(h.id)
+	if !h.authorizeOwner(userOwnership, int32(id)) {
+		return
+	}
+
+	existing, err := h.queries.GetUser(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, "Invalid user ID", http.StatusBadRequest)
+		h.fail(err)
 		return
 	}
 
-	user, err := db.New(conn).DeleteUser(h.r.Context(), int32(id))
+	roles := requestedRoles(req, existing.Roles)
+	for _, role := range roles {
+		if !auth.ValidRole(role) {
+			problem.Error(h.w, "Unknown role "+role, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Users may edit their own profile, but only user managers may change
+	// roles. Owners change their password and email through /api/v1/me,
+	// which asks for the current password.
+	if !h.principal.Can(userOwnership.override) {
+		if !slices.Equal(roles, existing.Roles) {
+			problem.Error(h.w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if req.Password != "" {
+			problem.Error(h.w, "Change your password through POST /api/v1/me/password", http.StatusForbidden)
+			return
+		}
+		if auth.NormalizeEmail(req.Email) != existing.Email {
+			problem.Error(h.w, "Change your email through POST /api/v1/me/email", http.StatusForbidden)
+			return
+		}
+	}
+
+	// An empty password keeps the current one.
+	hashedPassword := existing.Password
+	if req.Password != "" {
+		if err := h.app.Passwords.Check(req.Password); err != nil {
+			problem.Error(h.w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hashedPassword, err = h.app.Passwords.Hash(req.Password)
+		if err != nil {
+			h.fail(err)
+			return
+		}
+	}
+
+	user, err := h.queries.UpdateUser(h.r.Context(), db.UpdateUserParams{
+		ID:       int32(id),
+		Name:     req.Name,
+		Password: hashedPassword,
+		Email:    auth.NormalizeEmail(req.Email),
+		Roles:    roles,
+	})
+	if auth.UserConflict(h.w, err) {
+		return
+	}
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
-	h.w.WriteHeader(http.StatusNoContent)
-	json.NewEncoder(h.w).Encode(user)
+	// A new address is unverified until its owner confirms it.
+	if user.Email != existing.Email {
+		if err := auth.StartEmailVerification(h.r.Context(), h.app, user); err != nil {
+			h.app.Logger.Error("failed to start email verification", "error", err)
+		}
+	}
+
+	json.NewEncoder(h.w).Encode(newUserResponse(user))
+}
+
+// requestedRoles applies the role changes in req to current.
+func requestedRoles(req UserRequest, current []string) []string {
+	if len(req.Roles) > 0 {
+		return req.Roles
+	}
+	if req.IsAdmin == nil || *req.IsAdmin == slices.Contains(current, string(auth.RoleAdmin)) {
+		return current
+	}
+	if *req.IsAdmin {
+		return append(slices.Clone(current), string(auth.RoleAdmin))
+	}
+	return slices.DeleteFunc(slices.Clone(current), func(role string) bool {
+		return role == string(auth.RoleAdmin)
+	})
 }
 
-func UpdateUser(h BaseHandler) {
-	conn, err := db.CreateDBConnection()
+// UnlockUser lifts a login lockout of the user before it expires.
+func UnlockUser(h BaseHandler) {
+	id, err := strconv.Atoi(h.id)
+	if err != nil {
+		problem.Error(h.w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.queries.GetUser(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
-	defer conn.Close(h.r.Context())
 
-	var req UserRequest
-	if err := json.NewDecoder(h.r.Body).Decode(&req); err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+	if err := auth.ClearLoginFailures(h.r.Context(), h.app, user.Name); err != nil {
+		h.fail(err)
 		return
 	}
 
+	audit.Record(h.r.Context(), h.app, audit.Entry{
+		Action:  audit.AccountUnlocked,
+		ActorID: h.principal.UserID,
+		Target:  "user:" + strings.ToLower(user.Name),
+		IP:      auth.ClientIP(h.r, h.app.Config.Server.TrustProxyHeaders),
+	})
+
+	h.w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutUser revokes every session of the user. Their tokens stop working
+// with the next request; personal access tokens are not affected.
+func LogoutUser(h BaseHandler) {
 	id, err := strconv.Atoi(h.id)
 	if err != nil {
-		http.Error(h.w, "Invalid user ID", http.StatusBadRequest)
+		problem.Error(h.w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
 
-	IsAdmin := pgtype.Bool{}
-	err = IsAdmin.Scan(req.IsAdmin)
+	user, err := h.queries.GetUser(h.r.Context(), int32(id))
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusBadRequest)
+		h.fail(err)
 		return
 	}
 
-	user, err := db.New(conn).UpdateUser(h.r.Context(), db.UpdateUserParams{
-		ID:       int32(id),
-		Name:     req.Name,
-		Password: req.Password,
-		Email:    req.Email,
-		IsAdmin:  IsAdmin,
+	err = h.queries.RevokeUserSessions(h.r.Context(), db.RevokeUserSessionsParams{
+		UserID:    user.ID,
+		RevokedAt: pgtype.Timestamp{Time: h.app.Clock().UTC(), Valid: true},
 	})
 	if err != nil {
-		http.Error(h.w, err.Error(), http.StatusInternalServerError)
+		h.fail(err)
 		return
 	}
 
-	json.NewEncoder(h.w).Encode(user)
+	audit.Record(h.r.Context(), h.app, audit.Entry{
+		Action:  audit.SessionsRevoked,
+		ActorID: h.principal.UserID,
+		Target:  "user:" + strings.ToLower(user.Name),
+		IP:      auth.ClientIP(h.r, h.app.Config.Server.TrustProxyHeaders),
+	})
+
+	h.w.WriteHeader(http.StatusNoContent)
 }
@@ -7,14 +7,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
-	"time"
 
 	"github.com/Modul-306/backend/auth"
 	"github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
 	"github.com/Modul-306/backend/router"
 	"github.com/Modul-306/backend/tests/containers"
 	"github.com/Modul-306/backend/tests/testhelpers"
 	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestUserHandlers(t *testing.T) {
@@ -35,25 +36,29 @@ func TestUserHandlers(t *testing.T) {
 	testhelpers.SetupTestDB(t, conn)
 	defer testhelpers.CleanupTestDB(t, conn)
 
+	a := testhelpers.NewTestApp(t, postgres.URI)
+
 	// Create test user and get token
-	hashedPassword, _ := auth.HashPassword("testpass")
+	hashedPassword, _ := a.Passwords.Hash("testpass")
 	_, err = conn.Exec(context.Background(), `
-        INSERT INTO users (name, password, email, is_admin) 
+        INSERT INTO users (name, password, email, roles) 
         VALUES ($1, $2, $3, $4)
-        RETURNING id`, "testuser", hashedPassword, "test@example.com", true)
+        RETURNING id`, "testuser", hashedPassword, "test@example.com", []string{"customer", "admin"})
 	if err != nil {
 		t.Fatalf("failed to create test user: %v", err)
 	}
 
-	token, err := auth.CreateToken("testuser", time.Now().Add(time.Hour))
+	authCookie := testhelpers.SessionCookie(t, a, 1, "customer", "admin")
+
+	// Create a customer without admin rights
+	_, err = conn.Exec(context.Background(), `
+        INSERT INTO users (name, password, email) 
+        VALUES ($1, $2, $3)`, "customer", hashedPassword, "customer@example.com")
 	if err != nil {
-		t.Fatalf("failed to create auth token: %v", err)
+		t.Fatalf("failed to create customer: %v", err)
 	}
 
-	authCookie := &http.Cookie{
-		Name:  "token",
-		Value: token,
-	}
+	customerCookie := testhelpers.SessionCookie(t, a, 2, "customer")
 
 	tests := []struct {
 		name      string
@@ -61,6 +66,210 @@ func TestUserHandlers(t *testing.T) {
 		wantCode  int
 		validator func(*testing.T, *httptest.ResponseRecorder)
 	}{
+		{
+			name: "GetUsers as customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("GET", "/api/v1/user", nil)
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "GetUser self as customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("GET", "/api/v1/user/2", nil)
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "GetUser other as customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("GET", "/api/v1/user/1", nil)
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "UpdateUser self as customer",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:  "customer",
+					Email: "Customer@example.com",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusOK,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				user, err := a.Queries.GetUser(context.Background(), 2)
+				assert.NoError(t, err)
+				ok, _ := a.Passwords.Verify("testpass", user.Password)
+				assert.True(t, ok, "the password is kept")
+			},
+		},
+		{
+			name: "UpdateUser password as customer",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:     "customer",
+					Email:    "customer@example.com",
+					Password: "correct horse battery staple",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				user, err := a.Queries.GetUser(context.Background(), 2)
+				assert.NoError(t, err)
+				ok, _ := a.Passwords.Verify("testpass", user.Password)
+				assert.True(t, ok, "the password needs the current one; see /api/v1/me/password")
+			},
+		},
+		{
+			name: "UpdateUser email as customer",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:  "customer",
+					Email: "new-customer@example.com",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				user, err := a.Queries.GetUser(context.Background(), 2)
+				assert.NoError(t, err)
+				assert.Equal(t, "customer@example.com", user.Email, "the email needs the current password; see /api/v1/me/email")
+			},
+		},
+		{
+			name: "UpdateUser common password as admin",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:     "customer",
+					Email:    "customer@example.com",
+					Password: "Password123",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "UpdateUser password as admin",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:     "customer",
+					Email:    "customer@example.com",
+					Password: "correct horse battery staple",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(authCookie)
+				return req
+			},
+			wantCode: http.StatusOK,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				user, err := a.Queries.GetUser(context.Background(), 2)
+				assert.NoError(t, err)
+				assert.NotEqual(t, "correct horse battery staple", user.Password, "the password is hashed")
+				ok, _ := a.Passwords.Verify("correct horse battery staple", user.Password)
+				assert.True(t, ok)
+			},
+		},
+		{
+			name: "UpdateUser to taken name as customer",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:  "TestUser",
+					Email: "customer@example.com",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusConflict,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var response problem.Problem
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+				assert.Equal(t, auth.CodeUsernameTaken, response.Code)
+			},
+		},
+		{
+			name: "UpdateUser other as customer",
+			setup: func() *http.Request {
+				update := handlers.UserRequest{
+					Name:  "hijacked",
+					Email: "hijacked@example.com",
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/1", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "DeleteUser self as customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("DELETE", "/api/v1/user/2", nil)
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				user, err := a.Queries.GetUser(context.Background(), 2)
+				assert.NoError(t, err, "the account is kept; see DELETE /api/v1/me")
+				assert.False(t, user.DeletionScheduledAt.Valid)
+			},
+		},
+		{
+			name: "DeleteUser other as customer",
+			setup: func() *http.Request {
+				req := httptest.NewRequest("DELETE", "/api/v1/user/1", nil)
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Promote self to admin as customer",
+			setup: func() *http.Request {
+				isAdmin := true
+				update := handlers.UserRequest{
+					Name:    "customer",
+					Email:   "customer@example.com",
+					IsAdmin: &isAdmin,
+				}
+				body, _ := json.Marshal(update)
+				req := httptest.NewRequest("UPDATE", "/api/v1/user/2", bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.AddCookie(customerCookie)
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
 		{
 			name: "GetUser",
 			setup: func() *http.Request {
@@ -70,6 +279,7 @@ func TestUserHandlers(t *testing.T) {
 			},
 			wantCode: http.StatusOK,
 			validator: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				assert.NotContains(t, rec.Body.String(), "password")
 				var user handlers.UserResponse
 				if err := json.NewDecoder(rec.Body).Decode(&user); err != nil {
 					t.Errorf("failed to decode response: %v", err)
@@ -108,10 +318,10 @@ func TestUserHandlers(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := tt.setup()
+			req := testhelpers.WithCSRF(a, tt.setup())
 			rec := httptest.NewRecorder()
 
-			sut := router.CreateRouter()
+			sut := router.CreateRouter(a)
 
 			// Act
 			// changed act - calling GetById through production router
@@ -0,0 +1,140 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/mail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &mail.FileMailer{
+		Dir:   dir,
+		From:  "no-reply@example.com",
+		Clock: func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) },
+	}
+
+	err := mailer.Send(context.Background(), mail.Message{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "Hello\nworld",
+	})
+	assert.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	if !assert.Len(t, files, 1) {
+		return
+	}
+
+	f, err := os.Open(files[0])
+	assert.NoError(t, err)
+	defer f.Close()
+
+	msg, err := netmail.ReadMessage(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "no-reply@example.com", msg.Header.Get("From"))
+	assert.Equal(t, "user@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "Reset your password", msg.Header.Get("Subject"))
+
+	// Header injection through the recipient is refused
+	err = mailer.Send(context.Background(), mail.Message{
+		To:      "user@example.com\r\nBcc: other@example.com",
+		Subject: "Hi",
+	})
+	assert.Error(t, err)
+}
+
+// fakeSMTP accepts one message on a local port and hands it to the test.
+func fakeSMTP(t *testing.T) (addr string, received <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 fake ESMTP")
+		var envelope []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 fake")
+			case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"):
+				envelope = append(envelope, strings.TrimSpace(line))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				reply("250 OK")
+				ch <- strings.Join(envelope, "\n") + "\n" + data.String()
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), ch
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	mailer := &mail.SMTPMailer{Addr: addr, From: "no-reply@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := mailer.Send(ctx, mail.Message{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "https://example.com/reset-password?token=abc",
+	})
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		assert.Contains(t, msg, "MAIL FROM:<no-reply@example.com>")
+		assert.Contains(t, msg, "RCPT TO:<user@example.com>")
+		assert.Contains(t, msg, "Subject: Reset your password")
+		assert.Contains(t, msg, "token=abc")
+	case <-ctx.Done():
+		t.Fatal("fake SMTP server received nothing")
+	}
+}
@@ -0,0 +1,265 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrInvalidIDToken is returned for ID tokens that are malformed, not
+// signed by the provider, or not meant for this login.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// signingAlgorithms are the ID token algorithms accepted. HMAC is left out
+// on purpose: it would make the client secret a signing key.
+var signingAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384"}
+
+const (
+	// clockSkew is how far the provider's clock may be off from ours.
+	clockSkew = time.Minute
+	// keyRefreshInterval limits how often an unknown key ID makes us fetch
+	// the provider's keys again, e.g. after it rotated them.
+	keyRefreshInterval = time.Minute
+)
+
+// IDToken holds the claims of a validated ID token that logins use.
+type IDToken struct {
+	Issuer string
+	// Subject identifies the user at the provider. Unlike the email it
+	// never changes.
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// audience is the aud claim, which is either one string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// flag is a boolean claim that some providers send as a string.
+type flag bool
+
+func (f *flag) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*f = flag(b)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*f = s == "true"
+	return nil
+}
+
+type idClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     flag     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid is checked by Verify instead, as the jwt package does not know
+// about audience lists or nonces.
+func (c *idClaims) Valid() error {
+	return nil
+}
+
+// Verify validates an ID token (OpenID Connect Core 1.0, 3.1.3.7) and
+// returns its claims. nonce must be the one sent with the login.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idClaims{}
+	parser := jwt.Parser{ValidMethods: signingAlgorithms, SkipClaimsValidation: true}
+	_, err = parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.verificationKey(ctx, m, kid, t.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	now := p.clock()
+	switch {
+	case claims.Issuer != m.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q is not this client", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidIDToken)
+	case time.Unix(claims.ExpiresAt, 0).Add(clockSkew).Before(now):
+		return nil, fmt.Errorf("%w: token is expired", ErrInvalidIDToken)
+	case time.Unix(claims.IssuedAt, 0).Add(-clockSkew).After(now):
+		return nil, fmt.Errorf("%w: token is used before it was issued", ErrInvalidIDToken)
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+
+	return &IDToken{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     bool(claims.EmailVerified),
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// jwk is a public key as published by the provider (RFC 7517, 7518).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// keySet holds the provider's signing keys by key ID.
+type keySet struct {
+	keys      map[string]jwk
+	fetchedAt time.Time
+}
+
+// verificationKey returns the provider key kid for a token signed with alg.
+// An unknown kid refetches the keys, at most once per keyRefreshInterval.
+func (p *Provider) verificationKey(ctx context.Context, m *Metadata, kid, alg string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k, ok := p.keys.lookup(kid)
+	if !ok && (p.keys == nil || p.clock().Sub(p.keys.fetchedAt) >= keyRefreshInterval) {
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+			return nil, fmt.Errorf("fetching keys: %w", err)
+		}
+		p.keys = &keySet{keys: map[string]jwk{}, fetchedAt: p.clock()}
+		for _, key := range set.Keys {
+			if key.Use == "" || key.Use == "sig" {
+				p.keys.keys[key.KeyID] = key
+			}
+		}
+		k, ok = p.keys.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if k.Algorithm != "" && k.Algorithm != alg {
+		return nil, fmt.Errorf("algorithm %s does not match key %q", alg, kid)
+	}
+	return k.publicKey(alg)
+}
+
+// lookup finds the key kid. Tokens without a kid are accepted if the
+// provider has only one key.
+func (s *keySet) lookup(kid string) (jwk, bool) {
+	if s == nil {
+		return jwk{}, false
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// publicKey decodes the key for use with alg.
+func (k jwk) publicKey(alg string) (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		if alg[:2] != "RS" {
+			return nil, fmt.Errorf("algorithm %s does not match RSA key %q", alg, k.KeyID)
+		}
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() {
+			return nil, fmt.Errorf("RSA key %q is too weak", k.KeyID)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch {
+		case k.Curve == "P-256" && alg == "ES256":
+			curve = elliptic.P256()
+		case k.Curve == "P-384" && alg == "ES384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("algorithm %s does not match %s key %q", alg, k.Curve, k.KeyID)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC key %q is not on its curve", k.KeyID)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("malformed key")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,219 @@
+// Package oidc implements the relying party side of OpenID Connect: the
+// authorization code flow with PKCE (RFC 7636) and the validation of ID
+// tokens against the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryPath is appended to the issuer to find its configuration.
+const discoveryPath = "/.well-known/openid-configuration"
+
+// ErrCodeRejected is returned by Exchange when the provider refuses the
+// code, e.g. because it was used before or the verifier does not match.
+var ErrCodeRejected = errors.New("authorization code rejected")
+
+// Config describes the registration of this service as a client of one
+// provider.
+type Config struct {
+	// Issuer is the provider's issuer URL, e.g. https://accounts.google.com.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the user back to with the code.
+	RedirectURL string
+	// Scopes are requested besides "openid".
+	Scopes []string
+}
+
+// Metadata is the part of the provider configuration (OpenID Connect
+// Discovery 1.0) that the flow needs.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider is one OpenID Connect provider. Its configuration is discovered
+// on first use, so that a provider being down does not keep the service
+// from starting.
+type Provider struct {
+	config Config
+	client *http.Client
+	clock  func() time.Time
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *keySet
+}
+
+// NewProvider returns the provider described by cfg. It makes its requests
+// with client and checks token lifetimes against clock; nil selects
+// http.DefaultClient and time.Now.
+func NewProvider(cfg Config, client *http.Client, clock func() time.Time) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Provider{config: cfg, client: client, clock: clock}
+}
+
+// Metadata returns the provider configuration, discovering it if needed.
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m Metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+discoveryPath, &m); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", p.config.Issuer, err)
+	}
+	// The issuer must be the one asked for, or ID tokens from another
+	// provider could pass as ours.
+	if strings.TrimSuffix(m.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("discovering %s: provider claims to be %q", p.config.Issuer, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("discovering %s: configuration lacks an endpoint", p.config.Issuer)
+	}
+	if len(m.CodeChallengeMethods) > 0 && !slices.Contains(m.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("discovering %s: provider does not support PKCE with S256", p.config.Issuer)
+	}
+
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// NewSecret returns a random value for the state, nonce or PKCE code
+// verifier of a login.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge from verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to. The provider returns
+// state with the code; nonce comes back in the ID token, and the code can
+// only be redeemed with verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// tokenResponse is the answer of the token endpoint (RFC 6749, 5.1 and 5.2).
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems code at the token endpoint and returns the validated ID
+// token, which must carry nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*IDToken, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("exchanging code: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode == http.StatusBadRequest && tokens.Error == "invalid_grant" {
+		return nil, fmt.Errorf("%w: %s", ErrCodeRejected, tokens.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchanging code: %s: %s %s", resp.Status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("exchanging code: response has no ID token")
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,204 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/oidc"
+	"github.com/Modul-306/backend/tests/oidcmock"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+const redirectURL = "https://shop.example.com/oidc/mock/callback"
+
+var alice = oidcmock.User{
+	Subject:       "alice-1",
+	Email:         "alice@example.com",
+	EmailVerified: true,
+	Name:          "Alice",
+}
+
+// login runs the flow up to the code and returns it with its nonce and
+// verifier.
+func login(t *testing.T, mock *oidcmock.Provider, p *oidc.Provider) (code, nonce, verifier string) {
+	ctx := context.Background()
+	state, _ := oidc.NewSecret()
+	nonce, _ = oidc.NewSecret()
+	verifier, _ = oidc.NewSecret()
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("failed to build authorization URL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+	assert.Equal(t, oidc.CodeChallenge(verifier), u.Query().Get("code_challenge"))
+	assert.NotContains(t, authURL, verifier)
+
+	code, returned, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+	assert.Equal(t, state, returned)
+	return code, nonce, verifier
+}
+
+func TestExchange(t *testing.T) {
+	mock := oidcmock.New(t)
+	mock.SignInAs(alice)
+	p := oidc.NewProvider(mock.Config(redirectURL), nil, nil)
+	ctx := context.Background()
+
+	code, nonce, verifier := login(t, mock, p)
+	id, err := p.Exchange(ctx, code, verifier, nonce)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice-1", id.Subject)
+		assert.Equal(t, "alice@example.com", id.Email)
+		assert.True(t, id.EmailVerified)
+		assert.Equal(t, mock.Issuer(), id.Issuer)
+	}
+
+	// Codes work once
+	_, err = p.Exchange(ctx, code, verifier, nonce)
+	assert.ErrorIs(t, err, oidc.ErrCodeRejected)
+
+	// and only with their verifier
+	code, nonce, _ = login(t, mock, p)
+	_, err = p.Exchange(ctx, code, "guessed", nonce)
+	assert.ErrorIs(t, err, oidc.ErrCodeRejected)
+
+	// The ID token must carry the nonce of the login
+	code, _, verifier = login(t, mock, p)
+	_, err = p.Exchange(ctx, code, verifier, "other nonce")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+
+	// The client has to authenticate
+	wrong := mock.Config(redirectURL)
+	wrong.ClientSecret = "wrong"
+	other := oidc.NewProvider(wrong, nil, nil)
+	code, nonce, verifier = login(t, mock, other)
+	_, err = other.Exchange(ctx, code, verifier, nonce)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, oidc.ErrCodeRejected)
+}
+
+func TestVerify(t *testing.T) {
+	mock := oidcmock.New(t)
+	p := oidc.NewProvider(mock.Config(redirectURL), nil, nil)
+	ctx := context.Background()
+
+	valid := mock.Sign(mock.Claims(alice, "nonce"))
+	_, err := p.Verify(ctx, valid, "nonce")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+	}{
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"other authorized party", func(c jwt.MapClaims) {
+			c["aud"] = []string{mock.ClientID, "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"other nonce", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := mock.Claims(alice, "nonce")
+			tt.change(claims)
+			_, err := p.Verify(ctx, mock.Sign(claims), "nonce")
+			assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("audience list with this client as authorized party", func(t *testing.T) {
+		claims := mock.Claims(alice, "nonce")
+		claims["aud"] = []string{mock.ClientID, "other-client"}
+		claims["azp"] = mock.ClientID
+		_, err := p.Verify(ctx, mock.Sign(claims), "nonce")
+		assert.NoError(t, err)
+	})
+
+	t.Run("email_verified as string", func(t *testing.T) {
+		claims := mock.Claims(alice, "nonce")
+		claims["email_verified"] = "true"
+		id, err := p.Verify(ctx, mock.Sign(claims), "nonce")
+		if assert.NoError(t, err) {
+			assert.True(t, id.EmailVerified)
+		}
+	})
+
+	t.Run("tampered signature", func(t *testing.T) {
+		_, err := p.Verify(ctx, valid[:len(valid)-4]+"AAAA", "nonce")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	})
+
+	t.Run("signed with the client secret", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, mock.Claims(alice, "nonce"))
+		signed, _ := token.SignedString([]byte(mock.ClientSecret))
+		_, err := p.Verify(ctx, signed, "nonce")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, mock.Claims(alice, "nonce"))
+		signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		_, err := p.Verify(ctx, signed, "nonce")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, mock.Claims(alice, "nonce"))
+		token.Header["kid"] = "rotated-away"
+		signed, _ := token.SignedString(mustKey(t))
+		_, err := p.Verify(ctx, signed, "nonce")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	})
+}
+
+func TestDiscovery(t *testing.T) {
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.Metadata{
+			Issuer:                issuer,
+			AuthorizationEndpoint: "https://idp.example.com/authorize",
+			TokenEndpoint:         "https://idp.example.com/token",
+			JWKSURI:               "https://idp.example.com/jwks",
+		})
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// A provider must not claim to be another one
+	issuer = "https://idp.example.com"
+	p := oidc.NewProvider(oidc.Config{Issuer: server.URL}, nil, nil)
+	_, err := p.Metadata(ctx)
+	assert.ErrorContains(t, err, "claims to be")
+
+	// Failures are not remembered
+	issuer = server.URL + "/"
+	m, err := p.Metadata(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://idp.example.com/token", m.TokenEndpoint)
+	}
+}
+
+func mustKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
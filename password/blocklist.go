@@ -0,0 +1,43 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"strings"
+)
+
+//go:embed common.txt
+var common string
+
+// Blocklist is a set of passwords that may not be chosen. It is matched
+// case-insensitively.
+type Blocklist map[string]struct{}
+
+// DefaultBlocklist returns the frequently used passwords shipped with the
+// package.
+func DefaultBlocklist() Blocklist {
+	b := Blocklist{}
+	// Reading from a string cannot fail.
+	_ = b.Read(strings.NewReader(common))
+	return b
+}
+
+// Read adds the passwords in r, one per line. Blank lines and lines
+// starting with # are skipped.
+func (b Blocklist) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func (b Blocklist) Contains(password string) bool {
+	_, ok := b[strings.ToLower(password)]
+	return ok
+}
@@ -0,0 +1,164 @@
+// Package password hashes user passwords with bcrypt or argon2id and checks
+// new ones against a policy.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash algorithms.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// BcryptMaxLength is the number of bytes bcrypt looks at; the rest of a
+// longer password is ignored.
+const BcryptMaxLength = 72
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var encoding = base64.RawStdEncoding
+
+// Policy errors, wrapped with the limit that was broken.
+var (
+	ErrTooShort = errors.New("password is too short")
+	ErrTooLong  = errors.New("password is too long")
+	ErrCommon   = errors.New("password is too common")
+)
+
+type Argon2Params struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Service hashes passwords with Algorithm and verifies hashes made with
+// either algorithm, so that the algorithm can be switched at any time.
+type Service struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+	// MinLength is counted in characters, MaxLength in bytes.
+	MinLength int
+	MaxLength int
+	Blocklist Blocklist
+	// AllowPlaintext accepts stored values that are no known hash as
+	// plaintext passwords; see Verify.
+	AllowPlaintext bool
+}
+
+// Check returns an error wrapping ErrTooShort, ErrTooLong or ErrCommon if
+// password may not be chosen.
+func (s *Service) Check(password string) error {
+	if n := utf8.RuneCountInString(password); n < s.MinLength {
+		return fmt.Errorf("%w: it must have at least %d characters", ErrTooShort, s.MinLength)
+	}
+	if len(password) > s.MaxLength {
+		return fmt.Errorf("%w: it must not be longer than %d bytes", ErrTooLong, s.MaxLength)
+	}
+	if s.Blocklist.Contains(password) {
+		return fmt.Errorf("%w: choose one that is not on a list of frequently used passwords", ErrCommon)
+	}
+	return nil
+}
+
+// Hash hashes password with the configured algorithm. It does not apply
+// the policy; call Check first for passwords chosen by users.
+func (s *Service) Hash(password string) (string, error) {
+	if s.Algorithm == Argon2id {
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, s.Argon2.Iterations, s.Argon2.Memory, s.Argon2.Parallelism, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			s.Argon2.Memory, s.Argon2.Iterations, s.Argon2.Parallelism,
+			encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.BcryptCost)
+	return string(hash), err
+}
+
+// Verify reports whether password matches hash, and if so, whether hash
+// should be replaced by a new one because it was made with another
+// algorithm or cost.
+//
+// Values that are no known hash are taken to be plaintext, as stored by
+// earlier versions when a user updated their profile, if AllowPlaintext is
+// set. They always need a rehash.
+func (s *Service) Verify(password, hash string) (ok, rehash bool) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false
+		}
+		return true, s.Algorithm != Argon2id || params != s.Argon2
+
+	case strings.HasPrefix(hash, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, s.Algorithm != Bcrypt || err != nil || cost != s.BcryptCost
+
+	default:
+		if !s.AllowPlaintext || hash == "" || subtle.ConstantTimeCompare([]byte(password), []byte(hash)) != 1 {
+			return false, false
+		}
+		return true, true
+	}
+}
+
+// IsHash reports whether value is a hash Verify knows. Anything else is
+// either empty or plaintext left behind by earlier versions.
+func IsHash(value string) bool {
+	return strings.HasPrefix(value, "$argon2id$") || strings.HasPrefix(value, "$2")
+}
+
+// decodeArgon2 splits a hash of the form
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>.
+func decodeArgon2(hash string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	salt, err = encoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err = encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("malformed argon2id key")
+	}
+	return params, salt, key, nil
+}
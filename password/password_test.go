@@ -0,0 +1,99 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Modul-306/backend/password"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newService(algorithm string) *password.Service {
+	return &password.Service{
+		Algorithm:  algorithm,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1},
+		MinLength:  8,
+		MaxLength:  password.BcryptMaxLength,
+		Blocklist:  password.DefaultBlocklist(),
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{password.Bcrypt, password.Argon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			s := newService(algorithm)
+
+			hash, err := s.Hash("testpass")
+			assert.NoError(t, err)
+			assert.NotContains(t, hash, "testpass")
+
+			ok, rehash := s.Verify("testpass", hash)
+			assert.True(t, ok)
+			assert.False(t, rehash)
+
+			ok, _ = s.Verify("wrongpass", hash)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestVerifyRequestsRehash(t *testing.T) {
+	s := newService(password.Bcrypt)
+	bcryptHash, err := s.Hash("testpass")
+	assert.NoError(t, err)
+
+	// A higher cost
+	s.BcryptCost++
+	ok, rehash := s.Verify("testpass", bcryptHash)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	// Another algorithm
+	s = newService(password.Argon2id)
+	ok, rehash = s.Verify("testpass", bcryptHash)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	// Other argon2id parameters
+	argonHash, err := s.Hash("testpass")
+	assert.NoError(t, err)
+	s.Argon2.Iterations = 2
+	ok, rehash = s.Verify("testpass", argonHash)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	// Plaintext left behind by earlier versions, only while allowed
+	ok, _ = s.Verify("testpass", "testpass")
+	assert.False(t, ok)
+	assert.False(t, password.IsHash("testpass"))
+	s.AllowPlaintext = true
+	ok, rehash = s.Verify("testpass", "testpass")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+	ok, _ = s.Verify("", "")
+	assert.False(t, ok)
+	assert.True(t, password.IsHash(bcryptHash))
+	assert.True(t, password.IsHash(argonHash))
+
+	ok, _ = s.Verify("testpass", "$argon2id$v=19$m=64,t=1,p=1$bad")
+	assert.False(t, ok)
+}
+
+func TestCheck(t *testing.T) {
+	s := newService(password.Bcrypt)
+
+	assert.NoError(t, s.Check("correct horse battery staple"))
+	assert.True(t, errors.Is(s.Check("short"), password.ErrTooShort))
+	// Characters, not bytes, count towards the minimum
+	assert.True(t, errors.Is(s.Check("äöüäöüä"), password.ErrTooShort))
+	assert.True(t, errors.Is(s.Check(strings.Repeat("a", 73)), password.ErrTooLong))
+	assert.True(t, errors.Is(s.Check("Password123"), password.ErrCommon))
+
+	err := s.Blocklist.Read(strings.NewReader("# comment\n\nmodul306rocks\n"))
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(s.Check("Modul306Rocks"), password.ErrCommon))
+	assert.False(t, s.Blocklist.Contains("# comment"))
+}
@@ -0,0 +1,163 @@
+// Package problem writes error responses as RFC 7807 problem details.
+//
+// Every problem carries a machine-readable code next to the status. Codes
+// are stable, so clients may branch on them; titles and details are meant
+// for people and may change.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/validate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// Codes shared by all endpoints. Packages add codes of their own for
+// problems clients need to tell apart, such as a taken user name.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeBodyTooLarge     = "body_too_large"
+	CodeConflict         = "conflict"
+	CodeReference        = "reference_violation"
+	CodeInvalidValue     = "invalid_value"
+	CodeValidation       = "validation_failed"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal_error"
+	CodeUnavailable      = "unavailable"
+)
+
+// statusCodes is the code of problems that have nothing more specific.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeBodyTooLarge,
+	http.StatusUnprocessableEntity:   CodeInvalidValue,
+	http.StatusTooManyRequests:       CodeTooManyRequests,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// Problem is the body of an error response. It is also an error, so that
+// functions can return the problem they want answered.
+type Problem struct {
+	// Type is always about:blank; Code tells problems apart instead.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+	// Errors lists the broken rules of a request that failed validation.
+	Errors validate.Errors `json:"errors,omitempty"`
+}
+
+// New returns a problem with status and code. An empty code stands for the
+// generic code of status.
+func New(status int, code, detail string) *Problem {
+	if code == "" {
+		code = statusCodes[status]
+	}
+	if code == "" {
+		code = CodeInternal
+	}
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Code
+	}
+	return p.Code + ": " + p.Detail
+}
+
+// Internal reports whether the problem is not the client's fault. Its
+// cause should be logged, as the response does not show it.
+func (p *Problem) Internal() bool {
+	return p.Status >= http.StatusInternalServerError
+}
+
+// Write answers the request with p.
+func Write(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error answers the request with a problem of status and the generic code
+// of status. It is the problem counterpart of http.Error.
+func Error(w http.ResponseWriter, detail string, status int) {
+	Write(w, New(status, "", detail))
+}
+
+// FromError returns the problem to answer err with. Problems are returned
+// as they are. Requests failing validation are 422 and list the broken
+// rules, bodies over the size limit are 413. Missing rows are 404,
+// violated unique and foreign key constraints 409 and other values the
+// database rejects 422. Anything else is a 500 or, if the database cannot
+// be reached or has no free connection, a 503; their details stay out of
+// the response.
+func FromError(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var invalid validate.Errors
+	if errors.As(err, &invalid) {
+		p := New(http.StatusUnprocessableEntity, CodeValidation, "The request is invalid")
+		p.Errors = invalid
+		return p
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return New(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, fmt.Sprintf("The body must be at most %d bytes", tooLarge.Limit))
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return New(http.StatusNotFound, CodeNotFound, "Not found")
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return New(http.StatusConflict, CodeConflict, "Already exists")
+		case "23503": // foreign_key_violation
+			return New(http.StatusConflict, CodeReference, "Refers to a missing record or is still referred to")
+		case "23502", "23514", "22001", "22003", "22P02": // not_null, check, too long, out of range, invalid text
+			return New(http.StatusUnprocessableEntity, CodeInvalidValue, "Invalid value")
+		}
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, db.ErrAcquireTimeout) {
+		return New(http.StatusServiceUnavailable, CodeUnavailable, "Service unavailable, try again later")
+	}
+
+	return New(http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
@@ -0,0 +1,93 @@
+package problem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/problem"
+	"github.com/Modul-306/backend/validate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	problem.Error(rec, "Invalid blog ID", http.StatusBadRequest)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, problem.ContentType, rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"code": "bad_request",
+		"detail": "Invalid blog ID"
+	}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	problem.Write(rec, problem.New(http.StatusConflict, "username_taken", ""))
+	var p problem.Problem
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+	assert.Equal(t, "username_taken", p.Code)
+	assert.Equal(t, "Conflict", p.Title)
+	assert.NotContains(t, rec.Body.String(), "detail")
+	assert.NotContains(t, rec.Body.String(), "errors")
+
+	// Validation problems list every broken rule
+	rec = httptest.NewRecorder()
+	problem.Write(rec, problem.FromError(validate.Errors{
+		{Field: "name", Rule: "required", Message: "is required"},
+		{Field: "price", Rule: "min", Message: "must be at least 0"},
+	}))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Unprocessable Entity",
+		"status": 422,
+		"code": "validation_failed",
+		"detail": "The request is invalid",
+		"errors": [
+			{"field": "name", "rule": "required", "message": "is required"},
+			{"field": "price", "rule": "min", "message": "must be at least 0"}
+		]
+	}`, rec.Body.String())
+}
+
+func TestFromError(t *testing.T) {
+	secret := "relation \"users\" column \"password\""
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"no rows", fmt.Errorf("get blog: %w", pgx.ErrNoRows), http.StatusNotFound, problem.CodeNotFound},
+		{"unique", &pgconn.PgError{Code: "23505", Message: secret}, http.StatusConflict, problem.CodeConflict},
+		{"foreign key", &pgconn.PgError{Code: "23503", Message: secret}, http.StatusConflict, problem.CodeReference},
+		{"too long", &pgconn.PgError{Code: "22001", Message: secret}, http.StatusUnprocessableEntity, problem.CodeInvalidValue},
+		{"check", &pgconn.PgError{Code: "23514", Message: secret}, http.StatusUnprocessableEntity, problem.CodeInvalidValue},
+		{"other database error", &pgconn.PgError{Code: "42P01", Message: secret}, http.StatusInternalServerError, problem.CodeInternal},
+		{"timeout", fmt.Errorf("%s: %w", secret, context.DeadlineExceeded), http.StatusServiceUnavailable, problem.CodeUnavailable},
+		{"saturated pool", fmt.Errorf("%s: %w", secret, db.ErrAcquireTimeout), http.StatusServiceUnavailable, problem.CodeUnavailable},
+		{"anything else", errors.New(secret), http.StatusInternalServerError, problem.CodeInternal},
+		{"validation", validate.Errors{{Field: "name", Rule: "required", Message: "is required"}}, http.StatusUnprocessableEntity, problem.CodeValidation},
+		{"body too large", fmt.Errorf("decode: %w", &http.MaxBytesError{Limit: 10}), http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge},
+		{"problem", fmt.Errorf("wrapped: %w", problem.New(http.StatusTooManyRequests, "", "Try again later")), http.StatusTooManyRequests, problem.CodeTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := problem.FromError(tt.err)
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, tt.status >= 500, p.Internal())
+			assert.NotContains(t, p.Detail, secret, "details of the cause are not exposed")
+		})
+	}
+}
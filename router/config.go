@@ -1,44 +1,122 @@
 package router
 
 import (
+	"net/http"
+
+	"github.com/Modul-306/backend/app"
 	"github.com/Modul-306/backend/auth"
 	h "github.com/Modul-306/backend/handlers"
+	"github.com/Modul-306/backend/problem"
 	"github.com/gorilla/mux"
 )
 
-func CreateRouter() *mux.Router {
+// CreateRouter registers all routes against the dependencies owned by a.
+// Each protected route declares the permission it requires; see auth/rbac.go
+// for which roles grant it. Handlers additionally check that the caller owns
+// the blog, order or user record they act on; see handlers/ownership.go.
+// Placing orders also requires a verified email address. Errors are answered
+// as problem details; see problem/problem.go. Request bodies are limited in
+// size, and handlers taking one get it decoded and validated by
+// h.WithRequest; see the validate tags of the request structs. Unsafe requests
+// authenticated by cookies must pass the CSRF checks in auth/csrf.go.
+// Admins impersonating a user are kept from the routes that change the
+// account; see auth/impersonation.go.
+func CreateRouter(a *app.App) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(h.LimitBody(a.Config.Server.MaxBodyBytes))
+	router.Use(auth.CSRF(a))
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, "No such endpoint", http.StatusNotFound)
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, "Method not allowed on this endpoint", http.StatusMethodNotAllowed)
+	})
+
+	// Health endpoints; pool statistics show internal capacity, so only
+	// user managers may read them.
+	if a.Pool != nil {
+		router.HandleFunc("/api/v1/health/db", auth.IsAuthorized(a, auth.RequirePermission(auth.PermUsersManage, h.GetPoolStats(a.Pool)))).Methods("GET")
+	}
 
 	// Auth endpoints
-	router.HandleFunc("/api/v1/auth/login", auth.Login).Methods("POST")
-	router.HandleFunc("/api/v1/auth/sign-up", auth.SignUp).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", auth.JWKS(a)).Methods("GET")
+	router.HandleFunc("/api/v1/auth/login", auth.Login(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/login/mfa", auth.LoginMFA(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/magic-link", auth.RequestMagicLink(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/magic-link/redeem", auth.RedeemMagicLink(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/sign-up", auth.SignUp(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/oidc", auth.ListOIDCProviders(a)).Methods("GET")
+	router.HandleFunc("/api/v1/auth/oidc/{provider}", auth.StartOIDCLogin(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/oidc/{provider}/callback", auth.OIDCCallback(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/passkey/begin", auth.BeginPasskeyLogin(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/passkey/finish", auth.FinishPasskeyLogin(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/refresh", auth.Refresh(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/csrf", auth.GetCSRFToken(a)).Methods("GET")
+	router.HandleFunc("/api/v1/auth/logout", auth.Logout(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/forgot-password", auth.ForgotPassword(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/reset-password", auth.ResetPassword(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/verify", auth.VerifyEmail(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/verify/resend", auth.IsAuthorized(a, auth.DenyImpersonation(auth.ResendVerification(a)))).Methods("POST")
+	router.HandleFunc("/api/v1/auth/confirm-email-change", auth.ConfirmEmailChange(a)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/logout-all", auth.IsAuthorized(a, auth.DenyImpersonation(auth.LogoutAll(a)))).Methods("POST")
+
+	// Second factor endpoints
+	router.HandleFunc("/api/v1/auth/mfa/totp", auth.IsAuthorized(a, auth.SetupTOTP(a))).Methods("POST")
+	router.HandleFunc("/api/v1/auth/mfa/totp/confirm", auth.IsAuthorized(a, auth.ConfirmTOTP(a))).Methods("POST")
+	router.HandleFunc("/api/v1/auth/mfa/totp", auth.IsAuthorized(a, auth.DisableTOTP(a))).Methods("DELETE")
+	router.HandleFunc("/api/v1/auth/mfa/policy", auth.IsAuthorized(a, auth.RequirePermission(auth.PermUsersManage, auth.GetMFAPolicy(a)))).Methods("GET")
+	router.HandleFunc("/api/v1/auth/mfa/policy", auth.IsAuthorized(a, auth.RequirePermission(auth.PermUsersManage, auth.UpdateMFAPolicy(a)))).Methods("UPDATE")
+
+	// Own account endpoints
+	router.HandleFunc("/api/v1/me", h.WithAuthAndBase(a, h.GetMe)).Methods("GET")
+	router.HandleFunc("/api/v1/me", h.WithOwnAuthAndBase(a, h.WithRequest(h.UpdateMe))).Methods("UPDATE")
+	router.HandleFunc("/api/v1/me", auth.IsAuthorized(a, auth.DeleteAccount(a))).Methods("DELETE")
+	router.HandleFunc("/api/v1/me/password", auth.IsAuthorized(a, auth.ChangePassword(a))).Methods("POST")
+	router.HandleFunc("/api/v1/me/email", auth.IsAuthorized(a, auth.ChangeEmail(a))).Methods("POST")
+	router.HandleFunc("/api/v1/me/identities", auth.IsAuthorized(a, auth.GetIdentities(a))).Methods("GET")
+	router.HandleFunc("/api/v1/me/identities/{provider}", auth.IsAuthorized(a, auth.LinkIdentity(a))).Methods("POST")
+	router.HandleFunc("/api/v1/me/identities/{provider}", auth.IsAuthorized(a, auth.UnlinkIdentity(a))).Methods("DELETE")
+	router.HandleFunc("/api/v1/me/sessions", auth.IsAuthorized(a, auth.GetSessions(a))).Methods("GET")
+	router.HandleFunc("/api/v1/me/sessions/{id}", auth.IsAuthorized(a, auth.RevokeSession(a))).Methods("DELETE")
+	router.HandleFunc("/api/v1/me/passkeys", auth.IsAuthorized(a, auth.GetPasskeys(a))).Methods("GET")
+	router.HandleFunc("/api/v1/me/passkeys/begin", auth.IsAuthorized(a, auth.BeginPasskeyRegistration(a))).Methods("POST")
+	router.HandleFunc("/api/v1/me/passkeys/finish", auth.IsAuthorized(a, auth.FinishPasskeyRegistration(a))).Methods("POST")
+	router.HandleFunc("/api/v1/me/passkeys/{id}", auth.IsAuthorized(a, auth.DeletePasskey(a))).Methods("DELETE")
 
 	// Blog endpoints
-	router.HandleFunc("/api/v1/blogs", h.WithBaseHandler(h.GetBlogs)).Methods("GET")
-	router.HandleFunc("/api/v1/blogs/{id}", h.WithBaseHandler(h.GetBlog)).Methods("GET")
-	router.HandleFunc("/api/v1/blogs", h.WithAuthAndBase(h.CreateBlog)).Methods("POST")
-	router.HandleFunc("/api/v1/blogs/{id}", h.WithAuthAndBase(h.UpdateBlog)).Methods("UPDATE")
-	router.HandleFunc("/api/v1/blogs/{id}", h.WithAuthAndBase(h.DeleteBlog)).Methods("DELETE")
+	router.HandleFunc("/api/v1/blogs", h.WithBaseHandler(a, h.GetBlogs)).Methods("GET")
+	router.HandleFunc("/api/v1/blogs/{id}", h.WithBaseHandler(a, h.GetBlog)).Methods("GET")
+	router.HandleFunc("/api/v1/blogs", h.WithPermission(a, auth.PermBlogsWrite, h.WithRequest(h.CreateBlog))).Methods("POST")
+	router.HandleFunc("/api/v1/blogs/{id}", h.WithPermission(a, auth.PermBlogsWrite, h.WithRequest(h.UpdateBlog))).Methods("UPDATE")
+	router.HandleFunc("/api/v1/blogs/{id}", h.WithPermission(a, auth.PermBlogsWrite, h.DeleteBlog)).Methods("DELETE")
 
 	// User endpoints
-	router.HandleFunc("/api/v1/user", h.WithAuthAndBase(h.GetUsers)).Methods("GET")
-	router.HandleFunc("/api/v1/user/{id}", h.WithAuthAndBase(h.GetUser)).Methods("GET")
-	router.HandleFunc("/api/v1/user/{id}", h.WithAuthAndBase(h.DeleteUser)).Methods("DELETE")
-	router.HandleFunc("/api/v1/user/{id}", h.WithAuthAndBase(h.UpdateUser)).Methods("UPDATE")
+	router.HandleFunc("/api/v1/user", h.WithPermission(a, auth.PermUsersManage, h.GetUsers)).Methods("GET")
+	router.HandleFunc("/api/v1/user/{id}", h.WithAuthAndBase(a, h.GetUser)).Methods("GET")
+	router.HandleFunc("/api/v1/user/{id}", h.WithOwnAuthAndBase(a, h.DeleteUser)).Methods("DELETE")
+	router.HandleFunc("/api/v1/user/{id}", h.WithOwnAuthAndBase(a, h.WithRequest(h.UpdateUser))).Methods("UPDATE")
+	router.HandleFunc("/api/v1/user/{id}/unlock", h.WithPermission(a, auth.PermUsersManage, h.UnlockUser)).Methods("POST")
+	router.HandleFunc("/api/v1/user/{id}/logout", h.WithPermission(a, auth.PermUsersManage, h.LogoutUser)).Methods("POST")
+	router.HandleFunc("/api/v1/user/{id}/impersonate", auth.IsAuthorized(a, auth.RequirePermission(auth.PermUsersManage, auth.Impersonate(a)))).Methods("POST")
+
+	// Personal access token endpoints
+	router.HandleFunc("/api/v1/tokens", h.WithAuthAndBase(a, h.GetPersonalAccessTokens)).Methods("GET")
+	router.HandleFunc("/api/v1/tokens", h.WithOwnAuthAndBase(a, h.WithRequest(h.CreatePersonalAccessToken))).Methods("POST")
+	router.HandleFunc("/api/v1/tokens/{id}", h.WithOwnAuthAndBase(a, h.DeletePersonalAccessToken)).Methods("DELETE")
 
 	// Product endpoints
-	router.HandleFunc("/api/v1/products", h.WithBaseHandler(h.GetProducts)).Methods("GET")
-	router.HandleFunc("/api/v1/products/{id}", h.WithBaseHandler(h.GetProduct)).Methods("GET")
-	router.HandleFunc("/api/v1/products", h.WithAuthAndBase(h.CreateProduct)).Methods("POST")
-	router.HandleFunc("/api/v1/products/{id}", h.WithAuthAndBase(h.UpdateProduct)).Methods("UPDATE")
-	router.HandleFunc("/api/v1/products/{id}", h.WithAuthAndBase(h.DeleteProduct)).Methods("DELETE")
+	router.HandleFunc("/api/v1/products", h.WithBaseHandler(a, h.GetProducts)).Methods("GET")
+	router.HandleFunc("/api/v1/products/{id}", h.WithBaseHandler(a, h.GetProduct)).Methods("GET")
+	router.HandleFunc("/api/v1/products", h.WithPermission(a, auth.PermProductsWrite, h.WithRequest(h.CreateProduct))).Methods("POST")
+	router.HandleFunc("/api/v1/products/{id}", h.WithPermission(a, auth.PermProductsWrite, h.WithRequest(h.UpdateProduct))).Methods("UPDATE")
+	router.HandleFunc("/api/v1/products/{id}", h.WithPermission(a, auth.PermProductsWrite, h.DeleteProduct)).Methods("DELETE")
 
 	// Order endpoints
-	router.HandleFunc("/api/v1/order", h.WithAuthAndBase(h.GetOrders)).Methods("GET")
-	router.HandleFunc("/api/v1/order/{id}", h.WithAuthAndBase(h.GetOrder)).Methods("GET")
-	router.HandleFunc("/api/v1/order", h.WithAuthAndBase(h.CreateOrder)).Methods("POST")
-	router.HandleFunc("/api/v1/order/{id}", h.WithAuthAndBase(h.UpdateOrder)).Methods("UPDATE")
-	router.HandleFunc("/api/v1/order/{id}", h.WithAuthAndBase(h.DeleteOrder)).Methods("DELETE")
+	router.HandleFunc("/api/v1/order", h.WithPermission(a, auth.PermOrdersRead, h.GetOrders)).Methods("GET")
+	router.HandleFunc("/api/v1/order/{id}", h.WithPermission(a, auth.PermOrdersRead, h.GetOrder)).Methods("GET")
+	router.HandleFunc("/api/v1/order", h.WithVerifiedPermission(a, auth.PermOrdersWrite, h.WithRequest(h.CreateOrder))).Methods("POST")
+	router.HandleFunc("/api/v1/order/{id}", h.WithPermission(a, auth.PermOrdersWrite, h.WithRequest(h.UpdateOrder))).Methods("UPDATE")
+	router.HandleFunc("/api/v1/order/{id}", h.WithPermission(a, auth.PermOrdersWrite, h.DeleteOrder)).Methods("DELETE")
 
 	return router
 }
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_products;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS blogs;
+DROP TABLE IF EXISTS users;
@@ -1,4 +1,6 @@
-CREATE TABLE users (
+-- Deployments set up before migrations existed already have these tables
+-- from sql/schema.sql, which matched them exactly; they are kept.
+CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     password VARCHAR(255) NOT NULL,
@@ -7,7 +9,7 @@ CREATE TABLE users (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE blogs (
+CREATE TABLE IF NOT EXISTS blogs (
     id SERIAL PRIMARY KEY,
     title VARCHAR(255) NOT NULL,
     content TEXT NOT NULL,
@@ -17,7 +19,7 @@ CREATE TABLE blogs (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE products (
+CREATE TABLE IF NOT EXISTS products (
     id SERIAL PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     price DECIMAL(10, 2) NOT NULL,
@@ -26,7 +28,7 @@ CREATE TABLE products (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE orders (
+CREATE TABLE IF NOT EXISTS orders (
     id SERIAL PRIMARY KEY,
     address TEXT NOT NULL,
     user_id INT NOT NULL REFERENCES users(id),
@@ -34,10 +36,10 @@ CREATE TABLE orders (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE order_products (
+CREATE TABLE IF NOT EXISTS order_products (
     id SERIAL PRIMARY KEY,
     order_id INT NOT NULL REFERENCES orders(id),
     product_id INT NOT NULL REFERENCES products(id),
     quantity INT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
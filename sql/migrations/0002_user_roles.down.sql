@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN DEFAULT FALSE;
+
+UPDATE users SET is_admin = 'admin' = ANY(roles);
+
+ALTER TABLE users DROP COLUMN roles;
@@ -0,0 +1,20 @@
+-- login_failures counts failed logins per key, which is either
+-- "user:<name>" or "ip:<address>".
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    -- actor_id is the user who acted, if any.
+    actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+    target TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
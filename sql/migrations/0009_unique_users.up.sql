@@ -0,0 +1,30 @@
+-- Emails are stored trimmed and in lower case from now on.
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+-- Names and emails that differ only in case clash below. Rather than pick a
+-- winner, list them all so they can be renamed or merged by hand.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s %L (user ids %s)', kind, value, ids), '; ')
+    INTO duplicates
+    FROM (
+        SELECT 'name' AS kind, lower(name) AS value, string_agg(id::TEXT, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY lower(name)
+        HAVING count(*) > 1
+        UNION ALL
+        SELECT 'email', lower(email), string_agg(id::TEXT, ', ' ORDER BY id)
+        FROM users
+        GROUP BY lower(email)
+        HAVING count(*) > 1
+    ) AS d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users must be unique by name and email, resolve these duplicates first: %', duplicates;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX users_name_key ON users (lower(name));
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
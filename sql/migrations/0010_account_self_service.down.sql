@@ -0,0 +1,13 @@
+ALTER TABLE order_products
+    DROP CONSTRAINT order_products_order_id_fkey,
+    ADD CONSTRAINT order_products_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders(id);
+ALTER TABLE orders
+    DROP CONSTRAINT orders_user_id_fkey,
+    ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE blogs
+    DROP CONSTRAINT blogs_user_id_fkey,
+    ADD CONSTRAINT blogs_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+DROP TABLE IF EXISTS email_changes;
+
+ALTER TABLE users DROP COLUMN deletion_scheduled_at;
@@ -0,0 +1,25 @@
+-- deletion_scheduled_at is when an account whose owner asked to delete it
+-- is purged, unless they sign in again before.
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+
+-- email_changes holds address changes until the new address is confirmed.
+CREATE TABLE email_changes (
+    token_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX email_changes_user_id_idx ON email_changes (user_id);
+
+-- Deleting an account deletes everything that belongs to it.
+ALTER TABLE blogs
+    DROP CONSTRAINT blogs_user_id_fkey,
+    ADD CONSTRAINT blogs_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE orders
+    DROP CONSTRAINT orders_user_id_fkey,
+    ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE order_products
+    DROP CONSTRAINT order_products_order_id_fkey,
+    ADD CONSTRAINT order_products_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS magic_link_requests;
+DROP TABLE IF EXISTS magic_links;
@@ -0,0 +1,26 @@
+CREATE TABLE magic_links (
+    token_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- email is the address the link was sent to; it stops working once the
+    -- user's address changes.
+    email VARCHAR(255) NOT NULL,
+    -- browser_hash is the hash of the cookie of the browser the link is
+    -- bound to, or empty if it works in any browser.
+    browser_hash TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX magic_links_user_id_idx ON magic_links (user_id);
+
+-- magic_link_requests counts requested sign-in links per address and client
+-- IP, whether or not the address belongs to an account.
+CREATE TABLE magic_link_requests (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX magic_link_requests_email_idx ON magic_link_requests (email, created_at);
+CREATE INDEX magic_link_requests_ip_idx ON magic_link_requests (ip, created_at);
@@ -0,0 +1,32 @@
+-- user_identities links accounts to users of OpenID Connect providers. An
+-- account has at most one identity per provider.
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    -- subject is the provider's ID of the user, which unlike the email
+    -- never changes.
+    subject TEXT NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- email is the address the provider gave when the identity was linked.
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject),
+    CONSTRAINT user_identities_user_id_provider_key UNIQUE (user_id, provider)
+);
+
+-- oidc_states holds logins that are waiting for the user to come back from
+-- the provider.
+CREATE TABLE oidc_states (
+    state_hash TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    -- nonce and code_verifier are useless without the code, which only the
+    -- user's browser gets.
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    -- browser_hash is the hash of the cookie of the browser that started
+    -- the login.
+    browser_hash TEXT NOT NULL,
+    -- user_id is set when a signed-in user links the identity instead of
+    -- signing in with it.
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL
+);
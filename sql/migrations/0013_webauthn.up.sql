@@ -0,0 +1,27 @@
+-- webauthn_credentials holds the passkeys users registered.
+CREATE TABLE webauthn_credentials (
+    -- id is the credential ID the authenticator chose.
+    id BYTEA PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    -- public_key is the COSE_Key from the attested credential data.
+    public_key BYTEA NOT NULL,
+    -- sign_count is the authenticator's signature counter at the last
+    -- login, which a cloned authenticator fails to keep up with.
+    sign_count BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- webauthn_challenges holds ceremonies that are waiting for the
+-- authenticator's response.
+CREATE TABLE webauthn_challenges (
+    challenge_hash TEXT PRIMARY KEY,
+    -- user_id is the user registering a passkey. Logins do not know the
+    -- user until the response names the credential.
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    ceremony TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
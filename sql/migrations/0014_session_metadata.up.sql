@@ -0,0 +1,12 @@
+-- Sessions remember where they were started and when they were last used,
+-- so that users can tell their devices apart.
+ALTER TABLE sessions
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMP;
+
+UPDATE sessions SET last_seen_at = created_at;
+
+ALTER TABLE sessions
+    ALTER COLUMN last_seen_at SET NOT NULL,
+    ALTER COLUMN last_seen_at SET DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,3 @@
+ALTER TABLE audit_log DROP COLUMN IF EXISTS detail;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS impersonator_id;
@@ -0,0 +1,7 @@
+-- impersonator_id marks sessions an admin started to act as the user. They
+-- are not shown to the user and end when either of them is deleted.
+ALTER TABLE sessions ADD COLUMN impersonator_id INT REFERENCES users(id) ON DELETE CASCADE;
+
+-- detail describes the event further, e.g. the request made while
+-- impersonating.
+ALTER TABLE audit_log ADD COLUMN detail TEXT NOT NULL DEFAULT '';
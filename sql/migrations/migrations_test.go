@@ -0,0 +1,182 @@
+package migrations_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Modul-306/backend/sql/migrations"
+	"github.com/Modul-306/backend/tests/containers"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INT);")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INT);")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+
+	loaded, err := migrations.Load(fsys)
+	assert.NoError(t, err)
+	assert.Len(t, loaded, 2)
+	assert.Equal(t, int64(1), loaded[0].Version)
+	assert.Equal(t, "first", loaded[0].Name)
+	assert.Equal(t, int64(2), loaded[1].Version)
+
+	delete(fsys, "0002_second.down.sql")
+	_, err = migrations.Load(fsys)
+	assert.ErrorContains(t, err, "missing down file")
+
+	fsys["schema.sql"] = &fstest.MapFile{Data: []byte("")}
+	_, err = migrations.Load(fsys)
+	assert.ErrorContains(t, err, "name must look like")
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	_, err := migrations.New(nil)
+	assert.NoError(t, err)
+}
+
+func TestMigrator(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	ctx := context.Background()
+	connect := func() *pgx.Conn {
+		conn, err := pgx.Connect(ctx, postgres.URI)
+		if err != nil {
+			t.Fatalf("failed to connect to database: %v", err)
+		}
+		t.Cleanup(func() { conn.Close(context.Background()) })
+		return conn
+	}
+
+	fsys := fstest.MapFS{
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INT);")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INT);")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+
+	// Replicas starting together must apply every migration exactly once
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		conn := connect()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			migrator, err := migrations.NewFromFS(conn, fsys)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = migrator.Up(ctx)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	conn := connect()
+	migrator, err := migrations.NewFromFS(conn, fsys)
+	assert.NoError(t, err)
+
+	statuses, err := migrator.Status(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.True(t, statuses[1].Applied)
+
+	assert.NoError(t, migrator.Down(ctx))
+	statuses, err = migrator.Status(ctx)
+	assert.NoError(t, err)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+
+	assert.NoError(t, migrator.To(ctx, 0))
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT to_regclass('a') IS NOT NULL").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.Error(t, migrator.To(ctx, 7))
+}
+
+func TestMigrateExistingSchema(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	// Before migrations, deployments loaded the same tables from sql/schema.sql
+	schema, err := os.ReadFile("0001_init.up.sql")
+	assert.NoError(t, err)
+	_, err = conn.Exec(ctx, string(schema))
+	assert.NoError(t, err)
+	_, err = conn.Exec(ctx, `INSERT INTO users (name, password, email, is_admin) VALUES ('alice', 'x', 'alice@example.com', TRUE)`)
+	assert.NoError(t, err)
+
+	migrator, err := migrations.New(conn)
+	assert.NoError(t, err)
+	assert.NoError(t, migrator.Up(ctx))
+
+	var roles []string
+	err = conn.QueryRow(ctx, "SELECT roles FROM users WHERE name = 'alice'").Scan(&roles)
+	assert.NoError(t, err)
+	assert.Contains(t, roles, "admin")
+}
+
+func TestUniqueUsersMigration(t *testing.T) {
+	postgres, err := containers.NewTestPostgres(t)
+	if err != nil {
+		t.Fatalf("failed to create test container: %v", err)
+	}
+	defer postgres.Cleanup(t)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, postgres.URI)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	migrator, err := migrations.New(conn)
+	assert.NoError(t, err)
+	assert.NoError(t, migrator.To(ctx, 8))
+
+	_, err = conn.Exec(ctx, `
+        INSERT INTO users (name, password, email)
+        VALUES ('alice', 'x', 'alice@example.com'), ('Alice', 'x', ' ALICE@example.com'), ('bob', 'x', 'bob@example.com')
+    `)
+	assert.NoError(t, err)
+
+	// Duplicates are listed and nothing is changed
+	err = migrator.To(ctx, 9)
+	assert.ErrorContains(t, err, `name 'alice' (user ids 1, 2)`)
+	assert.ErrorContains(t, err, `email 'alice@example.com' (user ids 1, 2)`)
+
+	_, err = conn.Exec(ctx, `UPDATE users SET name = 'alice2', email = 'alice2@example.com' WHERE id = 2`)
+	assert.NoError(t, err)
+	assert.NoError(t, migrator.To(ctx, 9))
+
+	_, err = conn.Exec(ctx, `INSERT INTO users (name, password, email) VALUES ('BOB', 'x', 'other@example.com')`)
+	assert.Error(t, err)
+}
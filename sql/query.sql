@@ -5,27 +5,67 @@ WHERE id = $1 LIMIT 1;
 
 -- name: GetUserByUsername :one
 SELECT * FROM users
-WHERE name = $1 LIMIT 1;
+WHERE lower(name) = lower($1) LIMIT 1;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users
+WHERE lower(email) = lower($1) LIMIT 1;
 
 -- name: GetUsers :many
 SELECT * FROM users;
 
 -- name: CreateUser :one
-INSERT INTO users (name, password, email, is_admin)
-VALUES ($1, $2, $3, $4)
+INSERT INTO users (name, password, email)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: CreateVerifiedUser :one
+INSERT INTO users (name, password, email, email_verified)
+VALUES ($1, $2, $3, TRUE)
 RETURNING *;
 
 -- name: UpdateUser :one
 UPDATE users
-SET name = $1, password = $2, email = $3, is_admin = $4
+SET name = $1, password = $2, email = $3, roles = $4,
+    email_verified = email_verified AND email = $3
 WHERE id = $5
 RETURNING *;
 
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET password = $2
+WHERE id = $1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = TRUE
+WHERE id = $1 AND email = $2;
+
 -- name: DeleteUser :one
 DELETE FROM users
 WHERE id = $1
 RETURNING *;
 
+-- name: ChangeUserEmail :one
+UPDATE users
+SET email = $2, email_verified = TRUE
+WHERE id = $1
+RETURNING *;
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = $2
+WHERE id = $1;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET deletion_scheduled_at = NULL
+WHERE id = $1 AND deletion_scheduled_at IS NOT NULL;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deletion_scheduled_at <= $1;
+
 -- Blog queries
 -- name: GetBlog :one
 SELECT * FROM blogs
@@ -89,6 +129,10 @@ WHERE id = $1 LIMIT 1;
 -- name: GetOrders :many
 SELECT * FROM orders;
 
+-- name: GetOrdersByUser :many
+SELECT * FROM orders
+WHERE user_id = $1;
+
 -- name: CreateOrder :one
 INSERT INTO orders (address, user_id, is_completed)
 VALUES ($1, $2, false)
@@ -131,3 +175,331 @@ DELETE FROM order_products
 WHERE id = $1
 RETURNING *;
 
+
+-- Session queries
+-- name: CreateSession :one
+INSERT INTO sessions (id, user_id, expires_at, mfa_verified, user_agent, ip, created_at, last_seen_at, impersonator_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8)
+RETURNING *;
+
+-- name: GetSession :one
+SELECT * FROM sessions
+WHERE id = $1 LIMIT 1;
+
+-- name: GetUserSessions :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2 AND impersonator_id IS NULL
+ORDER BY last_seen_at DESC, id;
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = $2
+WHERE id = $1;
+
+-- name: RevokeSession :exec
+UPDATE sessions
+SET revoked_at = $2
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserSessions :exec
+UPDATE sessions
+SET revoked_at = $2
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOtherUserSessions :exec
+UPDATE sessions
+SET revoked_at = $3
+WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL;
+
+-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens (token_hash, session_id)
+VALUES ($1, $2);
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token_hash = $1 LIMIT 1;
+
+-- name: UseRefreshToken :one
+UPDATE refresh_tokens
+SET used_at = $2
+WHERE token_hash = $1 AND used_at IS NULL
+RETURNING *;
+
+-- Personal access token queries
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetPersonalAccessTokenByHash :one
+SELECT * FROM personal_access_tokens
+WHERE token_hash = $1 LIMIT 1;
+
+-- name: GetPersonalAccessTokensByUser :many
+SELECT * FROM personal_access_tokens
+WHERE user_id = $1
+ORDER BY id;
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens
+SET last_used_at = $2
+WHERE id = $1;
+
+-- name: DeletePersonalAccessToken :one
+DELETE FROM personal_access_tokens
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: DeleteUserPersonalAccessTokens :exec
+DELETE FROM personal_access_tokens
+WHERE user_id = $1;
+
+-- Password reset queries
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = $2
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+RETURNING *;
+
+-- name: DeleteUserPasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
+
+-- Email verification queries
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetLatestEmailVerificationToken :one
+SELECT * FROM email_verification_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: UseEmailVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE token_hash = $1 AND expires_at > $2
+RETURNING *;
+
+-- name: DeleteUserEmailVerificationTokens :exec
+DELETE FROM email_verification_tokens
+WHERE user_id = $1;
+
+-- Email change queries
+-- name: CreateEmailChange :exec
+INSERT INTO email_changes (token_hash, user_id, new_email, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: UseEmailChange :one
+DELETE FROM email_changes
+WHERE token_hash = $1 AND expires_at > $2
+RETURNING *;
+
+-- name: DeleteUserEmailChanges :exec
+DELETE FROM email_changes
+WHERE user_id = $1;
+
+-- MFA queries
+-- name: CreateTOTPCredential :execrows
+INSERT INTO totp_credentials (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+WHERE totp_credentials.confirmed_at IS NULL;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials
+WHERE user_id = $1 LIMIT 1;
+
+-- name: ConfirmTOTPCredential :execrows
+UPDATE totp_credentials
+SET confirmed_at = $2, last_used_step = $3
+WHERE user_id = $1 AND confirmed_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_credentials
+SET last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2;
+
+-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credentials
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = $3
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: GetMFAChallenge :one
+SELECT * FROM mfa_challenges
+WHERE token_hash = $1 LIMIT 1;
+
+-- name: CountMFAChallengeAttempt :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1
+RETURNING attempts;
+
+-- name: DeleteMFAChallenge :exec
+DELETE FROM mfa_challenges
+WHERE token_hash = $1;
+
+-- name: GetMFARequiredRoles :many
+SELECT role FROM mfa_required_roles
+ORDER BY role;
+
+-- name: SetMFARequiredRoles :exec
+WITH removed AS (
+    DELETE FROM mfa_required_roles
+    WHERE role <> ALL(@roles::text[])
+)
+INSERT INTO mfa_required_roles (role)
+SELECT unnest(@roles::text[])
+ON CONFLICT DO NOTHING;
+
+-- Login throttling queries
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures
+WHERE key = $1 LIMIT 1;
+
+-- name: RecordLoginFailure :one
+-- An expired lockout starts the count afresh.
+INSERT INTO login_failures (key, failures, last_failure_at)
+VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE WHEN login_failures.locked_until <= $2 THEN 1 ELSE login_failures.failures + 1 END,
+    locked_until = CASE WHEN login_failures.locked_until <= $2 THEN NULL ELSE login_failures.locked_until END,
+    last_failure_at = $2
+RETURNING *;
+
+-- name: LockLoginKey :exec
+UPDATE login_failures
+SET locked_until = $2
+WHERE key = $1;
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_failures
+WHERE key = $1;
+
+-- Magic link queries
+-- name: CreateMagicLink :exec
+INSERT INTO magic_links (token_hash, user_id, email, browser_hash, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: UseMagicLink :one
+-- Links bound to a browser only work with the hash of its cookie.
+DELETE FROM magic_links
+WHERE token_hash = $1 AND expires_at > $2 AND browser_hash IN ('', $3)
+RETURNING *;
+
+-- name: DeleteUserMagicLinks :exec
+DELETE FROM magic_links
+WHERE user_id = $1;
+
+-- name: CreateMagicLinkRequest :exec
+INSERT INTO magic_link_requests (email, ip, created_at)
+VALUES ($1, $2, $3);
+
+-- name: GetMagicLinkRequestsByEmail :one
+SELECT count(*) AS requests, min(created_at)::timestamp AS oldest
+FROM magic_link_requests
+WHERE email = $1 AND created_at > $2;
+
+-- name: GetMagicLinkRequestsByIP :one
+SELECT count(*) AS requests, min(created_at)::timestamp AS oldest
+FROM magic_link_requests
+WHERE ip = $1 AND created_at > $2;
+
+-- name: DeleteMagicLinkRequestsBefore :exec
+DELETE FROM magic_link_requests
+WHERE created_at <= $1;
+
+-- OpenID Connect queries
+-- name: CreateOIDCState :exec
+INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, browser_hash, user_id, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: UseOIDCState :one
+DELETE FROM oidc_states
+WHERE state_hash = $1 AND provider = $2 AND browser_hash = $3 AND expires_at > $4
+RETURNING *;
+
+-- name: DeleteOIDCStatesBefore :exec
+DELETE FROM oidc_states
+WHERE expires_at <= $1;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE provider = $1 AND subject = $2;
+
+-- name: GetUserIdentities :many
+SELECT * FROM user_identities
+WHERE user_id = $1
+ORDER BY provider;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities
+WHERE user_id = $1 AND provider = $2;
+
+-- WebAuthn queries
+-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge_hash, user_id, ceremony, expires_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: UseWebAuthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge_hash = $1 AND ceremony = $2 AND expires_at > $3
+RETURNING *;
+
+-- name: DeleteWebAuthnChallengesBefore :exec
+DELETE FROM webauthn_challenges
+WHERE expires_at <= $1;
+
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetWebAuthnCredential :one
+SELECT * FROM webauthn_credentials
+WHERE id = $1;
+
+-- name: GetUserWebAuthnCredentials :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at, id;
+
+-- name: UpdateWebAuthnCredentialUse :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, last_used_at = $3
+WHERE id = $1;
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1 AND user_id = $2;
+
+-- Audit queries
+-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (action, actor_id, target, ip, detail, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
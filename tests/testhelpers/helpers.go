@@ -3,61 +3,152 @@ package testhelpers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/Modul-306/backend/app"
+	"github.com/Modul-306/backend/auth"
+	"github.com/Modul-306/backend/config"
+	"github.com/Modul-306/backend/db"
+	"github.com/Modul-306/backend/sql/migrations"
+	"github.com/Modul-306/backend/token"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// findProjectRoot looks for sql/schema.sql file walking up the directory tree
-func findProjectRoot() (string, error) {
-	dir, err := os.Getwd()
+// SetupTestDB applies every migration with the same runner the service uses.
+func SetupTestDB(t *testing.T, conn *pgx.Conn) {
+	migrator, err := migrations.New(conn)
 	if err != nil {
-		return "", err
+		t.Fatalf("failed to load migrations: %v", err)
 	}
 
-	for {
-		if _, err := os.Stat(filepath.Join(dir, "sql", "schema.sql")); err == nil {
-			return dir, nil
-		}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+}
 
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			return "", fmt.Errorf("could not find project root")
-		}
-		dir = parent
+// NewTestPool opens a connection pool against the test database that is closed when the test ends.
+func NewTestPool(t *testing.T, uri string) *db.Pool {
+	pool, err := db.NewPool(context.Background(), uri, db.DefaultPoolConfig())
+	if err != nil {
+		t.Fatalf("failed to create connection pool: %v", err)
 	}
+	t.Cleanup(pool.Close)
+
+	return pool
 }
 
-func SetupTestDB(t *testing.T, conn *pgx.Conn) {
-	root, err := findProjectRoot()
+// TestConfig returns a valid configuration with a fixed JWT key and cheap password hashing.
+func TestConfig() config.Config {
+	cfg := config.Default()
+	cfg.Auth.JWTKey = "test_secret_key_0123456789abcdef"
+	cfg.Auth.BcryptCost = bcrypt.MinCost
+	return cfg
+}
+
+// NewTestApp wires an App against the test database at uri.
+func NewTestApp(t *testing.T, uri string) *app.App {
+	pool := NewTestPool(t, uri)
+
+	cfg := TestConfig()
+	cfg.Mail.Dir = t.TempDir()
+
+	a, err := app.New(pool, cfg, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	if err != nil {
+		t.Fatalf("failed to create app: %v", err)
+	}
+
+	return a
+}
+
+// SentMail returns the raw messages the app's file mailer has written so
+// far, oldest first.
+func SentMail(t *testing.T, a *app.App) []string {
+	files, err := filepath.Glob(filepath.Join(a.Config.Mail.Dir, "*.eml"))
 	if err != nil {
-		t.Fatalf("failed to find project root: %v", err)
+		t.Fatalf("failed to list mail: %v", err)
+	}
+	sort.Strings(files)
+
+	messages := make([]string, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read mail: %v", err)
+		}
+		messages = append(messages, string(data))
 	}
+	return messages
+}
 
-	schemaPath := filepath.Join(root, "sql", "schema.sql")
-	schema, err := os.ReadFile(schemaPath)
+// SessionCookie starts a session for the existing user userID and returns
+// the access token cookie for it, as if the user had logged in with roles.
+func SessionCookie(t *testing.T, a *app.App, userID int32, roles ...string) *http.Cookie {
+	session, err := a.Queries.CreateSession(context.Background(), db.CreateSessionParams{
+		ID:        fmt.Sprintf("test-session-%d-%d", userID, time.Now().UnixNano()),
+		UserID:    userID,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(time.Hour).UTC(), Valid: true},
+		CreatedAt: pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	})
 	if err != nil {
-		t.Fatalf("failed to read schema file at %s: %v", schemaPath, err)
+		t.Fatalf("failed to create session: %v", err)
 	}
 
-	_, err = conn.Exec(context.Background(), string(schema))
+	tokenString, err := a.Tokens.Create(token.Subject{
+		UserID:    userID,
+		Roles:     roles,
+		SessionID: session.ID,
+	}, time.Now().Add(time.Hour))
 	if err != nil {
-		t.Fatalf("failed to execute schema: %v", err)
+		t.Fatalf("failed to create auth token: %v", err)
+	}
+
+	return &http.Cookie{
+		Name:  "token",
+		Value: tokenString,
+	}
+}
+
+// WithCSRF sets the CSRF header that a browser client would send along with
+// the session cookies of req, and returns req.
+func WithCSRF(a *app.App, req *http.Request) *http.Request {
+	if c, err := req.Cookie("csrf_token"); err == nil {
+		req.Header.Set(auth.CSRFHeader, c.Value)
+		return req
+	}
+	if c, err := req.Cookie("token"); err == nil {
+		if claims, err := a.Tokens.Parse(c.Value); err == nil {
+			req.Header.Set(auth.CSRFHeader, auth.CSRFToken(claims.SessionID))
+			return req
+		}
 	}
+	if c, err := req.Cookie("refresh_token"); err == nil {
+		sum := sha256.Sum256([]byte(c.Value))
+		if refresh, err := a.Queries.GetRefreshToken(req.Context(), hex.EncodeToString(sum[:])); err == nil {
+			req.Header.Set(auth.CSRFHeader, auth.CSRFToken(refresh.SessionID))
+		}
+	}
+	return req
 }
 
+// CleanupTestDB rolls back every migration.
 func CleanupTestDB(t *testing.T, conn *pgx.Conn) {
-	_, err := conn.Exec(context.Background(), `
-        DROP TABLE IF EXISTS order_products CASCADE;
-        DROP TABLE IF EXISTS orders CASCADE;
-        DROP TABLE IF EXISTS products CASCADE;
-        DROP TABLE IF EXISTS blogs CASCADE;
-        DROP TABLE IF EXISTS users CASCADE;
-    `)
+	migrator, err := migrations.New(conn)
 	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+
+	if err := migrator.To(context.Background(), 0); err != nil {
 		t.Fatalf("failed to cleanup database: %v", err)
 	}
 }
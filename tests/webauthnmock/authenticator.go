@@ -0,0 +1,220 @@
+// Package webauthnmock is a software authenticator for tests. It plays both
+// the browser and a platform authenticator that creates discoverable P-256
+// passkeys, and answers every ceremony without asking the user.
+package webauthnmock
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/Modul-306/backend/webauthn"
+)
+
+// Authenticator is a software authenticator.
+type Authenticator struct {
+	// Origin is the origin the browser reports the ceremonies ran on.
+	Origin string
+	// SkipUserVerification answers without the user verified flag, like a
+	// security key without a PIN.
+	SkipUserVerification bool
+	// Format, if set, replaces the "none" attestation format.
+	Format string
+	// NoSignCount always reports a zero signature counter, like
+	// authenticators that keep none.
+	NoSignCount bool
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New returns an authenticator without credentials used on origin.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create answers options like navigator.credentials.create().
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, c := range a.credentials {
+		for _, excluded := range options.ExcludeCredentials {
+			if bytes.Equal(c.id, excluded.ID) {
+				return webauthn.RegistrationResponse{}, errors.New("authenticator already holds an excluded credential")
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	c := &credential{id: id, rpID: options.RP.ID, userHandle: options.User.ID, key: key}
+	a.credentials = append(a.credentials, c)
+
+	// Attested credential data: an all-zero AAGUID, the ID and the key.
+	attested := make([]byte, 16, 16+2+len(id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, encode(cborMap{
+		{int64(1), int64(2)},  // kty: EC2
+		{int64(3), int64(-7)}, // alg: ES256
+		{int64(-1), int64(1)}, // crv: P-256
+		{int64(-2), key.X.FillBytes(make([]byte, 32))},
+		{int64(-3), key.Y.FillBytes(make([]byte, 32))},
+	})...)
+
+	format := a.Format
+	if format == "" {
+		format = "none"
+	}
+	return webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAttestationResponse{
+			ClientDataJSON: a.clientData("webauthn.create", options.Challenge),
+			AttestationObject: encode(cborMap{
+				{"fmt", format},
+				{"attStmt", cborMap{}},
+				{"authData", a.authenticatorData(c, 0x40, attested)},
+			}),
+		},
+	}, nil
+}
+
+// Get answers options like navigator.credentials.get(). It uses the newest
+// credential for the relying party that options allow.
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var c *credential
+	for _, candidate := range a.credentials {
+		if candidate.rpID != options.RPID {
+			continue
+		}
+		allowed := len(options.AllowCredentials) == 0
+		for _, allow := range options.AllowCredentials {
+			allowed = allowed || bytes.Equal(candidate.id, allow.ID)
+		}
+		if allowed {
+			c = candidate
+		}
+	}
+	if c == nil {
+		return webauthn.AssertionResponse{}, errors.New("no credential for relying party")
+	}
+
+	if !a.NoSignCount {
+		c.signCount++
+	}
+	authData := a.authenticatorData(c, 0, nil)
+	clientData := a.clientData("webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	return webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawID: c.id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         signature,
+			UserHandle:        c.userHandle,
+		},
+	}, nil
+}
+
+// SetSignCount sets the signature counter of the newest credential, e.g. to
+// act like a clone of it.
+func (a *Authenticator) SetSignCount(n uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.credentials[len(a.credentials)-1].signCount = n
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *Authenticator) authenticatorData(c *credential, flags byte, attested []byte) []byte {
+	flags |= 0x01 // user present
+	if !a.SkipUserVerification {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, c.signCount)
+	return append(data, attested...)
+}
+
+// cborMap is a CBOR map that keeps its order.
+type cborMap [][2]any
+
+// encode encodes the few types the authenticator needs as CBOR.
+func encode(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case cborMap:
+		out := head(5, uint64(len(v)))
+		for _, kv := range v {
+			out = append(out, encode(kv[0])...)
+			out = append(out, encode(kv[1])...)
+		}
+		return out
+	}
+	panic("webauthnmock: cannot encode value")
+}
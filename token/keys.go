@@ -0,0 +1,242 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Algorithms a Key can use.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// ErrNoSigningKey is returned when no key of the ring may sign at the current time.
+var ErrNoSigningKey = errors.New("no signing key is active")
+
+// Key is one signing key of a KeyRing.
+//
+// To rotate, add a new key whose NotBefore lies in the future, so that it is
+// published in the JWKS before anything is signed with it, and set the old
+// key's NotAfter to at least one token lifetime after that.
+type Key struct {
+	ID        string
+	Algorithm string
+	// Secret is the shared secret of HS256 keys.
+	Secret []byte
+	// Private is the private key of RS256, ES256 and EdDSA keys.
+	Private crypto.Signer
+	// NotBefore is when the key starts signing new tokens.
+	NotBefore time.Time
+	// NotAfter is when tokens signed with the key stop being accepted. The
+	// zero time means the key never expires.
+	NotAfter time.Time
+}
+
+func (k Key) validate() error {
+	if k.ID == "" {
+		return errors.New("key has no ID")
+	}
+	if !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore) {
+		return fmt.Errorf("key %q: not_after must be after not_before", k.ID)
+	}
+
+	switch k.Algorithm {
+	case HS256:
+		if len(k.Secret) == 0 {
+			return fmt.Errorf("key %q: HS256 needs a secret", k.ID)
+		}
+		return nil
+	case RS256:
+		if private, ok := k.Private.(*rsa.PrivateKey); !ok || private.N.BitLen() < 2048 {
+			return fmt.Errorf("key %q: RS256 needs an RSA private key of at least 2048 bits", k.ID)
+		}
+	case ES256:
+		if private, ok := k.Private.(*ecdsa.PrivateKey); !ok || private.Curve != elliptic.P256() {
+			return fmt.Errorf("key %q: ES256 needs a P-256 private key", k.ID)
+		}
+	case EdDSA:
+		if _, ok := k.Private.(ed25519.PrivateKey); !ok {
+			return fmt.Errorf("key %q: EdDSA needs an Ed25519 private key", k.ID)
+		}
+	default:
+		return fmt.Errorf("key %q: unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+
+	if len(k.Secret) > 0 {
+		return fmt.Errorf("key %q: %s keys take a private key, not a secret", k.ID, k.Algorithm)
+	}
+	return nil
+}
+
+func (k Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k Key) signingKey() interface{} {
+	if k.Algorithm == HS256 {
+		return k.Secret
+	}
+	return k.Private
+}
+
+func (k Key) verificationKey() interface{} {
+	if k.Algorithm == HS256 {
+		return k.Secret
+	}
+	return k.Private.Public()
+}
+
+// signs reports whether the key may sign new tokens at now.
+func (k Key) signs(now time.Time) bool {
+	return !now.Before(k.NotBefore) && k.verifies(now)
+}
+
+// verifies reports whether tokens signed with the key are accepted at now.
+func (k Key) verifies(now time.Time) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+// KeyRing holds every key that may sign or verify tokens.
+type KeyRing struct {
+	keys []Key
+}
+
+// NewKeyRing validates keys and returns a ring holding them.
+func NewKeyRing(keys ...Key) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key ring is empty")
+	}
+
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if err := k.validate(); err != nil {
+			return nil, err
+		}
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate key ID %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+
+	return &KeyRing{keys: keys}, nil
+}
+
+// SigningKey returns the key that signs new tokens at now: the most recently
+// activated key that has not expired.
+func (r *KeyRing) SigningKey(now time.Time) (Key, error) {
+	var found *Key
+	for i, k := range r.keys {
+		if k.signs(now) && (found == nil || k.NotBefore.After(found.NotBefore)) {
+			found = &r.keys[i]
+		}
+	}
+	if found == nil {
+		return Key{}, ErrNoSigningKey
+	}
+	return *found, nil
+}
+
+func (r *KeyRing) lookup(id string) (Key, bool) {
+	for _, k := range r.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// JWK is the public part of a key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys accepted at now, including keys that do not
+// sign yet. HS256 keys are secret and never published.
+func (r *KeyRing) JWKS(now time.Time) JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range r.keys {
+		if k.Algorithm == HS256 || !k.verifies(now) {
+			continue
+		}
+
+		jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+		switch public := k.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			point, err := public.ECDH()
+			if err != nil {
+				continue
+			}
+			// The uncompressed point is 0x04 || X || Y.
+			xy := point.Bytes()[1:]
+			jwk.KeyType = "EC"
+			jwk.Curve = "P-256"
+			jwk.X = encode(xy[:len(xy)/2])
+			jwk.Y = encode(xy[len(xy)/2:])
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = encode(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParsePrivateKey reads a PEM encoded PKCS #8, PKCS #1 (RSA) or SEC 1 (EC) private key.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key in PEM block %q", block.Type)
+}
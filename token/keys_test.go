@@ -0,0 +1,196 @@
+package token_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsymmetricKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	for _, key := range []token.Key{
+		{ID: "rsa", Algorithm: token.RS256, Private: rsaKey},
+		{ID: "ec", Algorithm: token.ES256, Private: ecKey},
+		{ID: "ed", Algorithm: token.EdDSA, Private: edKey},
+	} {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			keys, err := token.NewKeyRing(key)
+			assert.NoError(t, err)
+			signer := token.NewSigner(keys, "backend", "api", time.Now)
+
+			tokenString, err := signer.Create(token.Subject{UserID: 1, SessionID: "session"}, time.Now().Add(time.Minute))
+			assert.NoError(t, err)
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+			assert.NoError(t, err)
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+			assert.Equal(t, key.Algorithm, parsed.Header["alg"])
+
+			_, err = signer.Parse(tokenString)
+			assert.NoError(t, err)
+
+			jwks := signer.JWKS()
+			if assert.Len(t, jwks.Keys, 1) {
+				assert.Equal(t, key.ID, jwks.Keys[0].KeyID)
+				assert.Equal(t, key.Algorithm, jwks.Keys[0].Algorithm)
+				assert.Equal(t, "sig", jwks.Keys[0].Use)
+			}
+		})
+	}
+}
+
+func TestAlgorithmMustMatchKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	keys, err := token.NewKeyRing(token.Key{ID: "rsa", Algorithm: token.RS256, Private: rsaKey})
+	assert.NoError(t, err)
+	signer := token.NewSigner(keys, "backend", "api", time.Now)
+
+	claims := jwt.MapClaims{
+		"sub": "1",
+		"sid": "session",
+		"iss": "backend",
+		"aud": "api",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"jti": "id",
+	}
+
+	// HS256 with the public key as secret must not verify against an RSA key
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa"
+	forgedString, err := forged.SignedString(publicPEM)
+	assert.NoError(t, err)
+	_, err = signer.Parse(forgedString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+
+	// Unsigned tokens are never accepted
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsigned.Header["kid"] = "rsa"
+	unsignedString, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+	_, err = signer.Parse(unsignedString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+
+	// Neither are tokens for unknown keys
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	unknown.Header["kid"] = "other"
+	unknownString, err := unknown.SignedString(rsaKey)
+	assert.NoError(t, err)
+	_, err = signer.Parse(unknownString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+}
+
+func TestKeyRotation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	// The new key is published a day before it signs, and the old one is
+	// accepted for an hour after that.
+	keys, err := token.NewKeyRing(
+		token.Key{ID: "old", Algorithm: token.EdDSA, Private: oldKey, NotAfter: start.Add(25 * time.Hour)},
+		token.Key{ID: "new", Algorithm: token.EdDSA, Private: newKey, NotBefore: start.Add(24 * time.Hour)},
+	)
+	assert.NoError(t, err)
+	signer := token.NewSigner(keys, "backend", "api", clock)
+
+	create := func() string {
+		tokenString, err := signer.Create(token.Subject{UserID: 1, SessionID: "session"}, now.Add(time.Hour))
+		assert.NoError(t, err)
+		return tokenString
+	}
+	kid := func(tokenString string) interface{} {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+		assert.NoError(t, err)
+		return parsed.Header["kid"]
+	}
+
+	beforeRotation := create()
+	assert.Equal(t, "old", kid(beforeRotation))
+	assert.Len(t, signer.JWKS().Keys, 2)
+
+	now = start.Add(24*time.Hour + time.Minute)
+	afterRotation := create()
+	assert.Equal(t, "new", kid(afterRotation))
+	_, err = signer.Parse(afterRotation)
+	assert.NoError(t, err)
+
+	// After the overlap, the old key is retired
+	now = start.Add(25*time.Hour + time.Minute)
+	_, err = signer.Parse(beforeRotation)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+	if assert.Len(t, signer.JWKS().Keys, 1) {
+		assert.Equal(t, "new", signer.JWKS().Keys[0].KeyID)
+	}
+}
+
+func TestKeyRingValidation(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	_, err = token.NewKeyRing()
+	assert.Error(t, err)
+
+	_, err = token.NewKeyRing(token.Key{ID: "a", Algorithm: token.RS256, Private: ecKey})
+	assert.Error(t, err, "algorithm must match the key type")
+
+	_, err = token.NewKeyRing(token.Key{ID: "a", Algorithm: "none", Secret: []byte("secret")})
+	assert.Error(t, err)
+
+	_, err = token.NewKeyRing(
+		token.Key{ID: "a", Algorithm: token.HS256, Secret: []byte("secret")},
+		token.Key{ID: "a", Algorithm: token.ES256, Private: ecKey},
+	)
+	assert.Error(t, err, "key IDs must be unique")
+
+	// HMAC secrets are never published
+	keys, err := token.NewKeyRing(token.Key{ID: "a", Algorithm: token.HS256, Secret: []byte("secret")})
+	assert.NoError(t, err)
+	assert.Empty(t, keys.JWKS(time.Now()).Keys)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	assert.NoError(t, err)
+	parsed, err := token.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.True(t, ecKey.Equal(parsed))
+
+	der, err = x509.MarshalECPrivateKey(ecKey)
+	assert.NoError(t, err)
+	parsed, err = token.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.True(t, ecKey.Equal(parsed))
+
+	_, err = token.ParsePrivateKey([]byte("not a key"))
+	assert.Error(t, err)
+}
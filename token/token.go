@@ -0,0 +1,219 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, expired or not signed by us.
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrLegacyToken is returned for tokens issued before they carried a user ID.
+// Their holders have to sign in again.
+var ErrLegacyToken = fmt.Errorf("%w: token has no subject; it predates user IDs in tokens, sign in again", ErrInvalidToken)
+
+// Claims are the contents of an access token. The user ID is the standard
+// `sub` claim, so renaming a user does not invalidate their tokens.
+type Claims struct {
+	Roles []string `json:"roles"`
+	// SessionID names the server-side session the token was issued for, so
+	// that revoking the session also rejects its access tokens.
+	SessionID string `json:"sid"`
+	// Actor is set on impersonation tokens and names the user acting as the
+	// subject, like the act claim of RFC 8693.
+	Actor *Actor `json:"act,omitempty"`
+	jwt.StandardClaims
+}
+
+// Actor identifies who really holds an impersonation token.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (int32, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: subject %q is not a user ID", ErrInvalidToken, c.Subject)
+	}
+	return int32(id), nil
+}
+
+// ActorID returns the user acting through an impersonation token, or 0 for
+// other tokens.
+func (c *Claims) ActorID() (int32, error) {
+	if c.Actor == nil {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(c.Actor.Subject, 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%w: actor %q is not a user ID", ErrInvalidToken, c.Actor.Subject)
+	}
+	return int32(id), nil
+}
+
+// Subject identifies who a token is issued to.
+type Subject struct {
+	UserID    int32
+	Roles     []string
+	SessionID string
+	// ActorID is the user impersonating UserID, or 0.
+	ActorID int32
+}
+
+// Signer creates and verifies the JWTs handed out to clients.
+type Signer struct {
+	keys     *KeyRing
+	issuer   string
+	audience string
+	clock    func() time.Time
+}
+
+// NewSigner returns a Signer that signs with the active key of keys, stamps
+// and requires issuer and audience, and checks expiry against clock.
+func NewSigner(keys *KeyRing, issuer, audience string, clock func() time.Time) *Signer {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Signer{keys: keys, issuer: issuer, audience: audience, clock: clock}
+}
+
+// JWKS returns the public keys that verifiers should currently trust.
+func (s *Signer) JWKS() JWKS {
+	return s.keys.JWKS(s.clock())
+}
+
+// Create issues a token for subject that expires at expirationTime.
+func (s *Signer) Create(subject Subject, expirationTime time.Time) (string, error) {
+	key, err := s.keys.SigningKey(s.clock())
+	if err != nil {
+		return "", err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{
+		Roles:     subject.Roles,
+		SessionID: subject.SessionID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatInt(int64(subject.UserID), 10),
+			Issuer:    s.issuer,
+			Audience:  s.audience,
+			IssuedAt:  s.clock().Unix(),
+			ExpiresAt: expirationTime.Unix(),
+			Id:        id,
+		},
+	}
+	if subject.ActorID != 0 {
+		claims.Actor = &Actor{Subject: strconv.FormatInt(int64(subject.ActorID), 10)}
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// Parse verifies tokenString and returns its claims.
+func (s *Signer) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.Parser{
+		ValidMethods:         []string{HS256, RS256, ES256, EdDSA},
+		SkipClaimsValidation: true,
+	}
+
+	_, err := parser.ParseWithClaims(tokenString, claims, s.verificationKey)
+	if err != nil {
+		if isLegacy(tokenString) {
+			return nil, ErrLegacyToken
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, ErrLegacyToken
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+	if _, err := claims.ActorID(); err != nil {
+		return nil, err
+	}
+
+	now := s.clock().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	if !claims.VerifyIssuedAt(now, true) {
+		return nil, fmt.Errorf("%w: token is used before it was issued", ErrInvalidToken)
+	}
+	if !claims.VerifyIssuer(s.issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !claims.VerifyAudience(s.audience, true) {
+		return nil, fmt.Errorf("%w: unexpected audience %q", ErrInvalidToken, claims.Audience)
+	}
+	if claims.Id == "" {
+		return nil, fmt.Errorf("%w: token has no ID", ErrInvalidToken)
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("%w: token has no session", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// verificationKey picks the key named by the token's kid header. The
+// algorithm must be the one the key was created for, so that e.g. a public
+// RSA key can never be used as an HMAC secret, and "none" is never accepted.
+func (s *Signer) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no key ID")
+	}
+
+	key, ok := s.keys.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method == nil || token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("algorithm %v does not match key %q", token.Header["alg"], kid)
+	}
+	if !key.verifies(s.clock()) {
+		return nil, fmt.Errorf("key %q has expired", kid)
+	}
+
+	return key.verificationKey(), nil
+}
+
+// isLegacy reports whether tokenString looks like a token from before user
+// IDs, which carried a username and no subject or key ID. Its signature
+// cannot be checked, but it is only used to explain a rejection.
+func isLegacy(tokenString string) bool {
+	claims := jwt.MapClaims{}
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims)
+	if err != nil {
+		return false
+	}
+	_, hasKeyID := token.Header["kid"]
+	_, hasUsername := claims["username"]
+	_, hasSubject := claims["sub"]
+	return hasUsername && !hasSubject && !hasKeyID
+}
+
+// newID returns a random token ID for the `jti` claim.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
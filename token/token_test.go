@@ -0,0 +1,119 @@
+package token_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func hmacRing(t *testing.T, id, secret string) *token.KeyRing {
+	keys, err := token.NewKeyRing(token.Key{ID: id, Algorithm: token.HS256, Secret: []byte(secret)})
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	keys := hmacRing(t, "test", "test_secret_key")
+	signer := token.NewSigner(keys, "backend", "api", clock)
+
+	tokenString, err := signer.Create(token.Subject{UserID: 42, Roles: []string{"customer"}, SessionID: "session"}, now.Add(time.Minute))
+	assert.NoError(t, err)
+
+	claims, err := signer.Parse(tokenString)
+	assert.NoError(t, err)
+	userID, err := claims.UserID()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(42), userID)
+	assert.Equal(t, []string{"customer"}, claims.Roles)
+	assert.Equal(t, "session", claims.SessionID)
+	assert.Equal(t, "backend", claims.Issuer)
+	assert.Equal(t, "api", claims.Audience)
+	assert.Equal(t, now.Unix(), claims.IssuedAt)
+	assert.NotEmpty(t, claims.Id)
+	actorID, err := claims.ActorID()
+	assert.NoError(t, err)
+	assert.Zero(t, actorID)
+	assert.Nil(t, claims.Actor)
+
+	// Every token gets its own ID
+	other, err := signer.Create(token.Subject{UserID: 42, SessionID: "session"}, now.Add(time.Minute))
+	assert.NoError(t, err)
+	otherClaims, err := signer.Parse(other)
+	assert.NoError(t, err)
+	assert.NotEqual(t, claims.Id, otherClaims.Id)
+
+	// Tokens signed with another key are rejected
+	otherKey := token.NewSigner(hmacRing(t, "test", "other_key"), "backend", "api", clock)
+	_, err = otherKey.Parse(tokenString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+
+	// Tokens for another issuer or audience are rejected
+	otherIssuer := token.NewSigner(keys, "someone-else", "api", clock)
+	_, err = otherIssuer.Parse(tokenString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+
+	otherAudience := token.NewSigner(keys, "backend", "another-api", clock)
+	_, err = otherAudience.Parse(tokenString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+
+	// Expiry is checked against the injected clock
+	now = now.Add(2 * time.Minute)
+	_, err = signer.Parse(tokenString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+}
+
+func TestImpersonationToken(t *testing.T) {
+	signer := token.NewSigner(hmacRing(t, "test", "test_secret_key"), "backend", "api", time.Now)
+
+	tokenString, err := signer.Create(token.Subject{UserID: 42, SessionID: "session", ActorID: 7}, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	claims, err := signer.Parse(tokenString)
+	assert.NoError(t, err)
+	userID, _ := claims.UserID()
+	assert.Equal(t, int32(42), userID)
+	actorID, err := claims.ActorID()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7), actorID)
+
+	// The actor must be a user ID
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &token.Claims{
+		SessionID: "session",
+		Actor:     &token.Actor{Subject: "admin"},
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "42",
+			Issuer:    "backend",
+			Audience:  "api",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			Id:        "id",
+		},
+	})
+	forged.Header["kid"] = "test"
+	tokenString, err = forged.SignedString([]byte("test_secret_key"))
+	assert.NoError(t, err)
+	_, err = signer.Parse(tokenString)
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+}
+
+func TestLegacyToken(t *testing.T) {
+	signer := token.NewSigner(hmacRing(t, "test", "test_secret_key"), "backend", "api", time.Now)
+
+	// Tokens issued before user IDs only carried the username
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": "testuser",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	})
+	tokenString, err := legacy.SignedString([]byte("test_secret_key"))
+	assert.NoError(t, err)
+
+	_, err = signer.Parse(tokenString)
+	assert.True(t, errors.Is(err, token.ErrLegacyToken))
+	assert.True(t, errors.Is(err, token.ErrInvalidToken))
+}
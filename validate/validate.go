@@ -0,0 +1,173 @@
+// Package validate checks request structs against rules declared in their
+// validate tags, e.g.
+//
+//	Name string `json:"name" validate:"required,max=255"`
+//
+// Rules are separated by commas and checked in order; a field fails on its
+// first broken rule. Fields are named by their JSON name. The rules are:
+//
+//   - required: strings must not be blank, numbers not zero and slices not
+//     empty.
+//   - min=N, max=N: bounds the length of strings in characters, the length
+//     of slices and the value of numbers.
+//   - url: an absolute http or https URL.
+//   - email: a bare email address.
+//   - path: a URL path such as /news/2024-launch, made of lower-case
+//     letters, digits, hyphens and underscores.
+//
+// Empty strings pass every rule but required, so optional fields only need
+// to be well-formed when set.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is a rule a field breaks.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors lists every field that breaks a rule, in field order.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Field + " " + fe.Message
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+var rules = map[string]bool{"required": true, "min": true, "max": true, "url": true, "email": true, "path": true}
+
+var pathPattern = regexp.MustCompile(`^(/[a-z0-9_-]+)+$`)
+
+// Struct checks v, a struct or a pointer to one, and returns Errors if any
+// field breaks a rule. It panics on malformed tags, which are programming
+// errors.
+func Struct(v any) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a struct", v))
+	}
+
+	var errs Errors
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+		if fe, ok := checkField(jsonName(field), value.Field(i), tag); !ok {
+			errs = append(errs, fe)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// checkField applies the rules of tag to v and returns the first one it
+// breaks.
+func checkField(name string, v reflect.Value, tag string) (FieldError, bool) {
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(rule, "=")
+		if message, ok := check(v, rule, arg); !ok {
+			return FieldError{Field: name, Rule: rule, Message: message}, false
+		}
+	}
+	return FieldError{}, true
+}
+
+// check applies one rule to v. It returns the message to show if v breaks
+// it.
+func check(v reflect.Value, rule, arg string) (string, bool) {
+	if !rules[rule] {
+		panic("validate: unknown rule " + rule)
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "is required", rule != "required"
+		}
+		v = v.Elem()
+	}
+	if rule != "required" && v.Kind() == reflect.String && v.String() == "" {
+		return "", true
+	}
+
+	switch rule {
+	case "required":
+		switch v.Kind() {
+		case reflect.String:
+			return "is required", strings.TrimSpace(v.String()) != ""
+		case reflect.Slice, reflect.Map:
+			return "is required", v.Len() > 0
+		default:
+			return "is required", !v.IsZero()
+		}
+	case "min", "max":
+		return checkBound(v, rule, arg)
+	case "url":
+		u, err := url.Parse(v.String())
+		ok := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		return "must be an absolute http or https URL", ok
+	case "email":
+		addr, err := mail.ParseAddress(v.String())
+		return "must be an email address", err == nil && addr.Address == v.String()
+	case "path":
+		return "must be a path like /news/my-post of lower-case letters, digits, hyphens and underscores", pathPattern.MatchString(v.String())
+	}
+	return "", true
+}
+
+func checkBound(v reflect.Value, rule, arg string) (string, bool) {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: %s needs a number, got %q", rule, arg))
+	}
+
+	verb, unit := "be", ""
+	var n float64
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = float64(utf8.RuneCountInString(v.String())), " characters long"
+	case reflect.Slice, reflect.Map:
+		n, verb, unit = float64(v.Len()), "have", " entries"
+		if bound == 1 {
+			unit = " entry"
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	default:
+		panic("validate: " + rule + " does not apply to " + v.Kind().String())
+	}
+
+	if rule == "min" {
+		return "must " + verb + " at least " + arg + unit, n >= bound
+	}
+	return "must " + verb + " at most " + arg + unit, n <= bound
+}
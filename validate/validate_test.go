@@ -0,0 +1,95 @@
+package validate_test
+
+import (
+	"testing"
+
+	"github.com/Modul-306/backend/validate"
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	Name    string   `json:"name" validate:"required,max=5"`
+	Price   float64  `json:"price" validate:"min=0"`
+	Image   string   `json:"image_url" validate:"url"`
+	Email   string   `json:"email" validate:"email"`
+	Path    string   `json:"path" validate:"path"`
+	Tags    []string `json:"tags" validate:"required,max=2"`
+	Limit   *int     `json:"limit" validate:"min=1"`
+	Ignored string   `json:"ignored"`
+}
+
+func valid() request {
+	return request{Name: "Tea", Tags: []string{"hot"}}
+}
+
+func TestStruct(t *testing.T) {
+	assert.NoError(t, validate.Struct(valid()))
+
+	full := valid()
+	full.Image = "https://example.com/tea.jpg"
+	full.Email = "alice@example.com"
+	full.Path = "/news/green_tea-2024"
+	limit := 3
+	full.Limit = &limit
+	assert.NoError(t, validate.Struct(&full), "pointers to structs work too")
+
+	tests := []struct {
+		name   string
+		change func(*request)
+		want   validate.FieldError
+	}{
+		{"blank", func(r *request) { r.Name = "  " }, validate.FieldError{Field: "name", Rule: "required", Message: "is required"}},
+		{"too long", func(r *request) { r.Name = "Rooibos" }, validate.FieldError{Field: "name", Rule: "max", Message: "must be at most 5 characters long"}},
+		{"characters, not bytes", func(r *request) { r.Name = "Thé à" }, validate.FieldError{}},
+		{"negative", func(r *request) { r.Price = -0.01 }, validate.FieldError{Field: "price", Rule: "min", Message: "must be at least 0"}},
+		{"relative URL", func(r *request) { r.Image = "tea.jpg" }, validate.FieldError{Field: "image_url", Rule: "url", Message: "must be an absolute http or https URL"}},
+		{"script URL", func(r *request) { r.Image = "javascript:alert(1)" }, validate.FieldError{Field: "image_url", Rule: "url", Message: "must be an absolute http or https URL"}},
+		{"named address", func(r *request) { r.Email = "Alice <alice@example.com>" }, validate.FieldError{Field: "email", Rule: "email", Message: "must be an email address"}},
+		{"path traversal", func(r *request) { r.Path = "/../admin" }, validate.FieldError{Field: "path", Rule: "path"}},
+		{"path without slash", func(r *request) { r.Path = "news" }, validate.FieldError{Field: "path", Rule: "path"}},
+		{"no tags", func(r *request) { r.Tags = nil }, validate.FieldError{Field: "tags", Rule: "required", Message: "is required"}},
+		{"too many tags", func(r *request) { r.Tags = []string{"a", "b", "c"} }, validate.FieldError{Field: "tags", Rule: "max", Message: "must have at most 2 entries"}},
+		{"pointer", func(r *request) { zero := 0; r.Limit = &zero }, validate.FieldError{Field: "limit", Rule: "min", Message: "must be at least 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.change(&r)
+			err := validate.Struct(r)
+			if tt.want == (validate.FieldError{}) {
+				assert.NoError(t, err)
+				return
+			}
+
+			var errs validate.Errors
+			if assert.ErrorAs(t, err, &errs) && assert.Len(t, errs, 1) {
+				assert.Equal(t, tt.want.Field, errs[0].Field)
+				assert.Equal(t, tt.want.Rule, errs[0].Rule)
+				if tt.want.Message != "" {
+					assert.Equal(t, tt.want.Message, errs[0].Message)
+				}
+			}
+		})
+	}
+
+	// Every broken field is reported, with its first broken rule
+	err := validate.Struct(request{Name: "", Price: -1, Tags: []string{"a", "b", "c"}})
+	var errs validate.Errors
+	if assert.ErrorAs(t, err, &errs) {
+		assert.Equal(t, validate.Errors{
+			{Field: "name", Rule: "required", Message: "is required"},
+			{Field: "price", Rule: "min", Message: "must be at least 0"},
+			{Field: "tags", Rule: "max", Message: "must have at most 2 entries"},
+		}, errs)
+	}
+	assert.Contains(t, err.Error(), "name is required")
+}
+
+func TestStructPanicsOnBadRules(t *testing.T) {
+	assert.Panics(t, func() {
+		validate.Struct(struct {
+			Name string `validate:"requird"`
+		}{})
+	})
+	assert.Panics(t, func() { validate.Struct("not a struct") })
+}
@@ -0,0 +1,151 @@
+package webauthn
+
+import (
+	"errors"
+	"math"
+	"unicode/utf8"
+)
+
+// The subset of CBOR (RFC 8949) that attestation objects and COSE keys use:
+// integers, byte and text strings, arrays, maps and the simple values
+// false, true and null. Tags, floats and indefinite lengths are rejected.
+
+var errCBOR = errors.New("malformed CBOR")
+
+// maxCBORDepth bounds the nesting of arrays and maps.
+const maxCBORDepth = 16
+
+type cborDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// decodeCBOR decodes the first item of data. Integers become int64, maps
+// map[any]any with int64 or string keys. It also returns how many bytes the
+// item took, as authenticator data holds CBOR followed by more data.
+func decodeCBOR(data []byte) (any, int, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.item()
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+func (d *cborDecoder) item() (any, error) {
+	if d.pos >= len(d.data) {
+		return nil, errCBOR
+	}
+	head := d.data[d.pos]
+	d.pos++
+	major, info := head>>5, head&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+		return nil, errCBOR
+	}
+
+	n, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(n), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(n), nil
+	case 2:
+		return d.bytes(n)
+	case 3:
+		b, err := d.bytes(n)
+		if err != nil || !utf8.Valid(b) {
+			return nil, errCBOR
+		}
+		return string(b), nil
+	case 4, 5:
+		// Every item takes at least one byte, which bounds what a short
+		// input can make us allocate.
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+		if d.depth++; d.depth > maxCBORDepth {
+			return nil, errCBOR
+		}
+		defer func() { d.depth-- }()
+
+		if major == 4 {
+			items := make([]any, n)
+			for i := range items {
+				if items[i], err = d.item(); err != nil {
+					return nil, err
+				}
+			}
+			return items, nil
+		}
+
+		m := make(map[any]any, n)
+		for range n {
+			k, err := d.item()
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+			if _, dup := m[k]; dup {
+				return nil, errCBOR
+			}
+			if m[k], err = d.item(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, errCBOR
+}
+
+// argument reads the count or value that follows the initial byte.
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(d.data)-d.pos < size {
+			return 0, errCBOR
+		}
+		var n uint64
+		for _, b := range d.data[d.pos : d.pos+size] {
+			n = n<<8 | uint64(b)
+		}
+		d.pos += size
+		return n, nil
+	}
+	return 0, errCBOR
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBOR
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
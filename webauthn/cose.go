@@ -0,0 +1,114 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) of the credential keys accepted.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the accepted algorithms in the order of preference in
+// which they are offered to authenticators.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE_Key labels and values (RFC 9052, 9053).
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	// The meaning of the negative labels depends on the key type.
+	coseCurve = -1
+	coseX     = -2
+	coseY     = -3
+	coseN     = -1
+	coseE     = -2
+
+	keyTypeOKP = 1
+	keyTypeEC2 = 2
+	keyTypeRSA = 3
+
+	curveP256    = 1
+	curveEd25519 = 6
+)
+
+var errKey = errors.New("unsupported or malformed credential key")
+
+// publicKey is a decoded COSE_Key.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes the COSE_Key data, which must use one of
+// Algorithms.
+func parsePublicKey(data []byte) (publicKey, error) {
+	v, n, err := decodeCBOR(data)
+	if err != nil || n != len(data) {
+		return publicKey{}, errKey
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return publicKey{}, errKey
+	}
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlgorithm)].(int64)
+	bytesAt := func(label int64) []byte {
+		b, _ := m[label].([]byte)
+		return b
+	}
+
+	switch {
+	case alg == AlgES256 && kty == keyTypeEC2:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, y := bytesAt(coseX), bytesAt(coseY)
+		if crv != curveP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, errKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, errKey
+		}
+		return publicKey{alg: alg, key: key}, nil
+
+	case alg == AlgEdDSA && kty == keyTypeOKP:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x := bytesAt(coseX)
+		if crv != curveEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errKey
+		}
+		return publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case alg == AlgRS256 && kty == keyTypeRSA:
+		n, e := new(big.Int).SetBytes(bytesAt(coseN)), new(big.Int).SetBytes(bytesAt(coseE))
+		if n.BitLen() < 2048 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return publicKey{}, errKey
+		}
+		return publicKey{alg: alg, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	}
+	return publicKey{}, fmt.Errorf("%w: algorithm %d", errKey, alg)
+}
+
+// verify checks sig over data.
+func (k publicKey) verify(data, sig []byte) bool {
+	digest := sha256.Sum256(data)
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
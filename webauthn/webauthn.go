@@ -0,0 +1,417 @@
+// Package webauthn implements the relying party side of Web Authentication
+// (WebAuthn Level 2): registering passkeys and verifying the assertions
+// they make at login. Only attestation "none" is supported, so nothing is
+// known about an authenticator beyond its key.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidResponse is returned for responses that are malformed, do
+	// not belong to the ceremony or are not signed by the credential.
+	ErrInvalidResponse = errors.New("invalid WebAuthn response")
+	// ErrSignCount is returned by VerifyAssertion when the signature
+	// counter did not increase, which suggests the credential was cloned.
+	ErrSignCount = errors.New("signature counter did not increase")
+)
+
+// maxCredentialIDLength is the longest credential ID authenticators may
+// return.
+const maxCredentialIDLength = 1023
+
+// Flags of authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+	flagExtensions   = 0x80
+)
+
+// Config describes the relying party.
+type Config struct {
+	// RPID is the domain credentials are scoped to, e.g. example.com. It
+	// must be the host of the origins or a parent domain of it.
+	RPID   string
+	RPName string
+	// Origins are the web origins the ceremonies may take place on, e.g.
+	// https://shop.example.com.
+	Origins []string
+	// Timeout is how long the user has to answer the authenticator.
+	Timeout time.Duration
+}
+
+// RelyingParty runs the ceremonies for one relying party.
+type RelyingParty struct {
+	config   Config
+	rpIDHash [32]byte
+}
+
+// New returns the relying party described by cfg.
+func New(cfg Config) *RelyingParty {
+	return &RelyingParty{config: cfg, rpIDHash: sha256.Sum256([]byte(cfg.RPID))}
+}
+
+// NewChallenge returns a random challenge for a ceremony.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Bytes is binary data, which the JSON forms of WebAuthn messages encode
+// as unpadded base64url.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// User is the account a credential is created for. ID is the user handle
+// that passkeys return at login; it must not contain personal data.
+type User struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create() as
+// publicKey.
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get() as publicKey.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the credential returned by
+// navigator.credentials.create(), in the form of its toJSON().
+type RegistrationResponse struct {
+	ID       string                           `json:"id"`
+	RawID    Bytes                            `json:"rawId"`
+	Type     string                           `json:"type"`
+	Response AuthenticatorAttestationResponse `json:"response"`
+}
+
+type AuthenticatorAttestationResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// AssertionResponse is the credential returned by
+// navigator.credentials.get(), in the form of its toJSON().
+type AssertionResponse struct {
+	ID       string                         `json:"id"`
+	RawID    Bytes                          `json:"rawId"`
+	Type     string                         `json:"type"`
+	Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+type AuthenticatorAssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle"`
+}
+
+// Credential is a registered passkey.
+type Credential struct {
+	ID []byte
+	// PublicKey is the credential's COSE_Key.
+	PublicKey []byte
+	// SignCount is the last signature counter the authenticator reported.
+	SignCount uint32
+}
+
+// CreationOptions returns the options to register a passkey for user.
+// Authenticators that already hold one of the credentials in exclude
+// refuse, so that a user does not register the same one twice.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude [][]byte) CreationOptions {
+	params := make([]CredentialParameter, len(Algorithms))
+	for i, alg := range Algorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+	excluded := make([]CredentialDescriptor, len(exclude))
+	for i, id := range exclude {
+		excluded[i] = CredentialDescriptor{Type: "public-key", ID: id}
+	}
+
+	return CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: excluded,
+		// Passkeys are discoverable, so that logins need no user name,
+		// and verify the user, e.g. by fingerprint, so that they are a
+		// second factor in themselves.
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options to log in with any passkey of the
+// relying party.
+func (rp *RelyingParty) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+// clientData is the part of the client data that the relying party checks.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ResponseChallenge returns the challenge that clientDataJSON answers, so
+// that the ceremony it belongs to can be looked up. Nothing is verified.
+func ResponseChallenge(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("%w: malformed challenge", ErrInvalidResponse)
+	}
+	return challenge, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	got, _ := base64.RawURLEncoding.DecodeString(data.Challenge)
+	switch {
+	case data.Type != ceremony:
+		return fmt.Errorf("%w: type %q is not %s", ErrInvalidResponse, data.Type, ceremony)
+	case len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1:
+		return fmt.Errorf("%w: challenge does not match", ErrInvalidResponse)
+	case !slices.Contains(rp.config.Origins, data.Origin):
+		return fmt.Errorf("%w: unexpected origin %q", ErrInvalidResponse, data.Origin)
+	case data.CrossOrigin:
+		return fmt.Errorf("%w: ceremony ran in a cross-origin frame", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// authenticatorData is the data the authenticator signs (WebAuthn, 6.1).
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// credentialID and publicKey are only set during registration.
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	malformed := fmt.Errorf("%w: malformed authenticator data", ErrInvalidResponse)
+	if len(data) < 37 {
+		return authenticatorData{}, malformed
+	}
+	ad := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.flags&flagAttestedData != 0 {
+		// The AAGUID of the authenticator model is skipped, as it means
+		// nothing without attestation.
+		if len(rest) < 18 {
+			return authenticatorData{}, malformed
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > maxCredentialIDLength || len(rest) < n {
+			return authenticatorData{}, malformed
+		}
+		ad.credentialID, rest = rest[:n], rest[n:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, malformed
+		}
+		ad.publicKey, rest = rest[:n], rest[n:]
+	}
+	if ad.flags&flagExtensions != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, malformed
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return authenticatorData{}, malformed
+	}
+	return ad, nil
+}
+
+// check verifies that ad is meant for this relying party and that the user
+// was both present and verified.
+func (rp *RelyingParty) check(ad authenticatorData) error {
+	switch {
+	case subtle.ConstantTimeCompare(ad.rpIDHash, rp.rpIDHash[:]) != 1:
+		return fmt.Errorf("%w: credential is scoped to another relying party", ErrInvalidResponse)
+	case ad.flags&flagUserPresent == 0:
+		return fmt.Errorf("%w: user was not present", ErrInvalidResponse)
+	case ad.flags&flagUserVerified == 0:
+		return fmt.Errorf("%w: user was not verified", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// VerifyRegistration verifies the answer to CreationOptions with challenge
+// (WebAuthn, 7.1) and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(resp RegistrationResponse, challenge []byte) (Credential, error) {
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	v, n, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil || n != len(resp.Response.AttestationObject) {
+		return Credential{}, fmt.Errorf("%w: malformed attestation object", ErrInvalidResponse)
+	}
+	object, _ := v.(map[any]any)
+	format, _ := object["fmt"].(string)
+	statement, _ := object["attStmt"].(map[any]any)
+	authData, _ := object["authData"].([]byte)
+	if format != "none" || len(statement) != 0 {
+		return Credential{}, fmt.Errorf("%w: attestation format %q is not supported", ErrInvalidResponse, format)
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := rp.check(ad); err != nil {
+		return Credential{}, err
+	}
+	if ad.credentialID == nil {
+		return Credential{}, fmt.Errorf("%w: no credential was created", ErrInvalidResponse)
+	}
+	if !bytes.Equal(ad.credentialID, resp.RawID) {
+		return Credential{}, fmt.Errorf("%w: credential ID does not match", ErrInvalidResponse)
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	return Credential{
+		ID:        bytes.Clone(ad.credentialID),
+		PublicKey: bytes.Clone(ad.publicKey),
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyAssertion verifies the answer to RequestOptions with challenge made
+// with cred (WebAuthn, 7.2) and returns the new signature counter, which
+// the caller stores.
+func (rp *RelyingParty) VerifyAssertion(resp AssertionResponse, challenge []byte, cred Credential) (uint32, error) {
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(resp.RawID, cred.ID) {
+		return 0, fmt.Errorf("%w: credential ID does not match", ErrInvalidResponse)
+	}
+
+	ad, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.check(ad); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(bytes.Clone(resp.Response.AuthenticatorData), clientDataHash[:]...)
+	if !key.verify(signed, resp.Response.Signature) {
+		return 0, fmt.Errorf("%w: signature does not verify", ErrInvalidResponse)
+	}
+
+	// Authenticators without a counter always report zero.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return ad.signCount, nil
+}
@@ -0,0 +1,186 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Modul-306/backend/tests/webauthnmock"
+	"github.com/Modul-306/backend/webauthn"
+	"github.com/stretchr/testify/assert"
+)
+
+const origin = "https://shop.example.com"
+
+func newRelyingParty() *webauthn.RelyingParty {
+	return webauthn.New(webauthn.Config{
+		RPID:    "example.com",
+		RPName:  "Shop",
+		Origins: []string{origin},
+		Timeout: 5 * time.Minute,
+	})
+}
+
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthnmock.Authenticator) webauthn.Credential {
+	challenge, err := webauthn.NewChallenge()
+	assert.NoError(t, err)
+	resp, err := authenticator.Create(rp.CreationOptions(challenge, webauthn.User{ID: []byte("1"), Name: "alice"}, nil))
+	if err != nil {
+		t.Fatalf("failed to create credential: %v", err)
+	}
+	cred, err := rp.VerifyRegistration(resp, challenge)
+	if err != nil {
+		t.Fatalf("failed to register credential: %v", err)
+	}
+	return cred
+}
+
+func TestRegistration(t *testing.T) {
+	rp := newRelyingParty()
+	authenticator := webauthnmock.New(origin)
+
+	challenge, _ := webauthn.NewChallenge()
+	options := rp.CreationOptions(challenge, webauthn.User{ID: []byte("1"), Name: "alice"}, nil)
+	assert.Equal(t, "none", options.Attestation)
+	assert.Equal(t, "required", options.AuthenticatorSelection.UserVerification)
+
+	resp, err := authenticator.Create(options)
+	assert.NoError(t, err)
+
+	// Responses survive the JSON the browser sends
+	data, _ := json.Marshal(resp)
+	var decoded webauthn.RegistrationResponse
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+
+	got, err := webauthn.ResponseChallenge(decoded.Response.ClientDataJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, challenge, got)
+
+	cred, err := rp.VerifyRegistration(decoded, challenge)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte(resp.RawID), cred.ID)
+		assert.NotEmpty(t, cred.PublicKey)
+		assert.Zero(t, cred.SignCount)
+	}
+
+	other, _ := webauthn.NewChallenge()
+	_, err = rp.VerifyRegistration(resp, other)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse, "other challenge")
+
+	// Registered credentials are excluded
+	_, err = authenticator.Create(rp.CreationOptions(challenge, webauthn.User{ID: []byte("1")}, [][]byte{cred.ID}))
+	assert.Error(t, err)
+
+	tests := []struct {
+		name   string
+		change func(*webauthnmock.Authenticator, *webauthn.CreationOptions)
+	}{
+		{"other origin", func(a *webauthnmock.Authenticator, _ *webauthn.CreationOptions) {
+			a.Origin = "https://evil.example.net"
+		}},
+		{"other relying party", func(_ *webauthnmock.Authenticator, o *webauthn.CreationOptions) { o.RP.ID = "evil.example.net" }},
+		{"user not verified", func(a *webauthnmock.Authenticator, _ *webauthn.CreationOptions) { a.SkipUserVerification = true }},
+		{"attestation other than none", func(a *webauthnmock.Authenticator, _ *webauthn.CreationOptions) { a.Format = "packed" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := webauthnmock.New(origin)
+			challenge, _ := webauthn.NewChallenge()
+			options := rp.CreationOptions(challenge, webauthn.User{ID: []byte("1")}, nil)
+			tt.change(authenticator, &options)
+
+			resp, err := authenticator.Create(options)
+			assert.NoError(t, err)
+			_, err = rp.VerifyRegistration(resp, challenge)
+			assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+		})
+	}
+
+	t.Run("malformed attestation object", func(t *testing.T) {
+		resp, _ := authenticator.Create(rp.CreationOptions(challenge, webauthn.User{ID: []byte("1")}, nil))
+		for _, object := range [][]byte{nil, {0xa1}, {0x9f}, {0xbf, 0xff}, append(resp.Response.AttestationObject, 0)} {
+			resp.Response.AttestationObject = object
+			_, err := rp.VerifyRegistration(resp, challenge)
+			assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+		}
+	})
+}
+
+func TestAssertion(t *testing.T) {
+	rp := newRelyingParty()
+	authenticator := webauthnmock.New(origin)
+	cred := register(t, rp, authenticator)
+
+	challenge, _ := webauthn.NewChallenge()
+	options := rp.RequestOptions(challenge)
+	assert.Empty(t, options.AllowCredentials, "passkeys are discoverable")
+
+	resp, err := authenticator.Get(options)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), []byte(resp.Response.UserHandle))
+	count, err := rp.VerifyAssertion(resp, challenge, cred)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), count)
+	cred.SignCount = count
+
+	// The counter has to grow
+	_, err = rp.VerifyAssertion(resp, challenge, cred)
+	assert.ErrorIs(t, err, webauthn.ErrSignCount)
+
+	authenticator.SetSignCount(0)
+	resp, _ = authenticator.Get(options)
+	_, err = rp.VerifyAssertion(resp, challenge, cred)
+	assert.ErrorIs(t, err, webauthn.ErrSignCount, "a clone lagging behind")
+
+	// Changed authenticator data breaks the signature
+	resp, _ = authenticator.Get(options)
+	resp.Response.AuthenticatorData[36]++
+	_, err = rp.VerifyAssertion(resp, challenge, cred)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+
+	t.Run("authenticator without counter", func(t *testing.T) {
+		authenticator := webauthnmock.New(origin)
+		authenticator.NoSignCount = true
+		cred := register(t, rp, authenticator)
+		for range 2 {
+			resp, _ := authenticator.Get(options)
+			count, err := rp.VerifyAssertion(resp, challenge, cred)
+			assert.NoError(t, err)
+			assert.Zero(t, count)
+		}
+	})
+
+	t.Run("signed by another credential", func(t *testing.T) {
+		other := webauthnmock.New(origin)
+		register(t, rp, other)
+		resp, _ := other.Get(options)
+		resp.RawID = cred.ID
+		_, err := rp.VerifyAssertion(resp, challenge, cred)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("registration answered as login", func(t *testing.T) {
+		resp, _ := authenticator.Get(options)
+		var data map[string]any
+		json.Unmarshal(resp.Response.ClientDataJSON, &data)
+		data["type"] = "webauthn.create"
+		resp.Response.ClientDataJSON, _ = json.Marshal(data)
+		_, err := rp.VerifyAssertion(resp, challenge, cred)
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("user not verified", func(t *testing.T) {
+		authenticator.SkipUserVerification = true
+		defer func() { authenticator.SkipUserVerification = false }()
+		resp, _ := authenticator.Get(options)
+		_, err := rp.VerifyAssertion(resp, challenge, webauthn.Credential{ID: cred.ID, PublicKey: cred.PublicKey})
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+
+	t.Run("other challenge", func(t *testing.T) {
+		resp, _ := authenticator.Get(options)
+		other, _ := webauthn.NewChallenge()
+		_, err := rp.VerifyAssertion(resp, other, webauthn.Credential{ID: cred.ID, PublicKey: cred.PublicKey})
+		assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+}